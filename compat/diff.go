@@ -0,0 +1,640 @@
+// Package compat compares two revisions of a set of lexicons,
+// classifying the changes between them according to the atproto
+// rules for lexicon evolution: new fields must be optional,
+// required fields cannot be removed or made optional, types cannot
+// change, and constraints cannot be tightened. XRPC outputs and
+// messages are only read by clients, so there the reverse applies
+// to constraints: they may be tightened but not loosened.
+package compat
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// Kind classifies a change.
+type Kind string
+
+const (
+	// DefAdded and DefRemoved are used when a definition
+	// is added or removed, including when its whole lexicon is.
+	DefAdded   Kind = "def-added"
+	DefRemoved Kind = "def-removed"
+
+	// TypeChanged is used when the type of a schema changes.
+	TypeChanged Kind = "type-changed"
+
+	// FieldAdded and FieldRemoved are used when a property
+	// of an object or a query parameter is added or removed.
+	FieldAdded   Kind = "field-added"
+	FieldRemoved Kind = "field-removed"
+
+	// FieldRequired is used when an optional field becomes
+	// required, and FieldOptional for the reverse.
+	FieldRequired Kind = "field-required"
+	FieldOptional Kind = "field-optional"
+
+	// EnumNarrowed and EnumWidened are used when values
+	// are removed from or added to an enum.
+	EnumNarrowed Kind = "enum-narrowed"
+	EnumWidened  Kind = "enum-widened"
+
+	// MemberAdded and MemberRemoved are used when a union
+	// gains or loses a member.
+	MemberAdded   Kind = "union-member-added"
+	MemberRemoved Kind = "union-member-removed"
+
+	// RefChanged is used when a ref refers to a different definition.
+	RefChanged Kind = "ref-changed"
+
+	// Tightened and Loosened are used when any other constraint,
+	// such as maxLength or format, is made more or less strict.
+	Tightened Kind = "constraint-tightened"
+	Loosened  Kind = "constraint-loosened"
+
+	// KeyChanged is used when the record key type changes.
+	KeyChanged Kind = "key-changed"
+
+	// BodyAdded and BodyRemoved are used when the input, output
+	// or message of an XRPC definition is added or removed,
+	// and EncodingChanged when its encoding changes.
+	BodyAdded       Kind = "body-added"
+	BodyRemoved     Kind = "body-removed"
+	EncodingChanged Kind = "encoding-changed"
+
+	// ErrorAdded and ErrorRemoved are used when an
+	// XRPC definition declares a new error or stops
+	// declaring one.
+	ErrorAdded   Kind = "error-added"
+	ErrorRemoved Kind = "error-removed"
+)
+
+// Change describes a single difference between two
+// revisions of a lexicon definition.
+type Change struct {
+	// Def holds the fully qualified name of the definition,
+	// for example "app.bsky.feed.post" or "app.bsky.feed.defs#postView".
+	Def string
+
+	// Path holds the dot-separated path within the definition's
+	// lexicon JSON to the schema or keyword that changed, for example
+	// "record.properties.text.maxLength". It's empty for changes
+	// to the definition as a whole.
+	Path string
+
+	// Kind classifies the change.
+	Kind Kind
+
+	// Breaking reports whether the change can break
+	// existing data or clients.
+	Breaking bool
+
+	// Msg describes the change.
+	Msg string
+}
+
+func (c *Change) String() string {
+	if c.Path == "" {
+		return fmt.Sprintf("%s: %s (%s)", c.Def, c.Msg, c.Kind)
+	}
+	return fmt.Sprintf("%s %s: %s (%s)", c.Def, c.Path, c.Msg, c.Kind)
+}
+
+// position describes where a schema is used, which determines
+// whether some changes are breaking.
+type position int
+
+const (
+	// dataPosition is used for the schemas of records and other
+	// definitions, which describe data that's both written and
+	// read by clients. Clients rely on required fields staying
+	// required, and existing data may not meet tighter constraints.
+	dataPosition position = iota
+
+	// requestPosition is used for the parameters and input
+	// of XRPC requests, which may be made less strict without
+	// breaking existing clients.
+	requestPosition
+
+	// responsePosition is used for the output and messages of
+	// XRPC definitions, which are only read by clients, so they
+	// may be made more strict but not less.
+	responsePosition
+)
+
+// breaks reports whether making a constraint at pos more strict,
+// if tighter is true, or less strict otherwise, can break clients.
+func (pos position) breaks(tighter bool) bool {
+	if pos == responsePosition {
+		return !tighter
+	}
+	return tighter
+}
+
+// Diff returns the changes between the old and new revisions of
+// a set of lexicons, ordered by definition name. Changes to
+// descriptions are not included.
+//
+// Each definition is compared once, on its own, so refs are
+// not followed: a definition that's only used through a ref from
+// an XRPC output, message or input is still compared as data.
+// For example, tightening a constraint in an object definition used
+// only as a query's output is reported as breaking, even though
+// the same change made directly in the output schema is not.
+func Diff(oldSchemas, newSchemas []*gen.Schema) []*Change {
+	oldByID := schemasByID(oldSchemas)
+	newByID := schemasByID(newSchemas)
+	var changes []*Change
+	for _, id := range unionKeys(oldByID, newByID) {
+		var oldDefs, newDefs map[string]*gen.TypeSchema
+		if s := oldByID[id]; s != nil {
+			oldDefs = s.Defs
+		}
+		if s := newByID[id]; s != nil {
+			newDefs = s.Defs
+		}
+		for _, name := range unionKeys(oldDefs, newDefs) {
+			d := &differ{
+				id:  id,
+				def: gen.TypeName(id, name),
+			}
+			oldDef, newDef := oldDefs[name], newDefs[name]
+			switch {
+			case newDef == nil:
+				d.add("", DefRemoved, true, "definition removed")
+			case oldDef == nil:
+				d.add("", DefAdded, false, "definition added")
+			default:
+				d.compare("", oldDef, newDef, dataPosition)
+			}
+			changes = append(changes, d.changes...)
+		}
+	}
+	return changes
+}
+
+// differ compares two revisions of a single definition.
+type differ struct {
+	// id holds the ID of the lexicon holding the definition.
+	id string
+
+	// def holds the fully qualified name of the definition.
+	def string
+
+	changes []*Change
+}
+
+func (d *differ) add(path string, kind Kind, breaking bool, msg string, args ...any) {
+	d.changes = append(d.changes, &Change{
+		Def:      d.def,
+		Path:     path,
+		Kind:     kind,
+		Breaking: breaking,
+		Msg:      fmt.Sprintf(msg, args...),
+	})
+}
+
+// tightened records a constraint at pos that's been
+// tightened if tighter is true, or loosened otherwise.
+func (d *differ) tightened(path string, pos position, tighter bool, msg string, args ...any) {
+	if tighter {
+		d.add(path, Tightened, pos.breaks(true), msg, args...)
+	} else {
+		d.add(path, Loosened, pos.breaks(false), msg, args...)
+	}
+}
+
+// compare compares the old and new revisions of the schema at path.
+func (d *differ) compare(path string, old, new *gen.TypeSchema, pos position) {
+	if old.Type != new.Type {
+		d.add(path, TypeChanged, true, "type changed from %s to %s", old.Type, new.Type)
+		return
+	}
+	switch old.Type {
+	case "record":
+		if old.Key != new.Key {
+			d.add(join(path, "key"), KeyChanged, true, "record key changed from %q to %q", old.Key, new.Key)
+		}
+		if old.Record != nil && new.Record != nil {
+			d.compare(join(path, "record"), old.Record, new.Record, pos)
+		}
+	case "query", "procedure", "subscription":
+		d.compareParams(join(path, "parameters"), old.Parameters, new.Parameters)
+		d.compareBody(join(path, "input"), old.Input, new.Input, requestPosition)
+		d.compareBody(join(path, "output"), old.Output, new.Output, responsePosition)
+		var oldMessage, newMessage *gen.BodyType
+		if old.Message != nil {
+			oldMessage = &gen.BodyType{Schema: old.Message.Schema}
+		}
+		if new.Message != nil {
+			newMessage = &gen.BodyType{Schema: new.Message.Schema}
+		}
+		d.compareBody(join(path, "message"), oldMessage, newMessage, responsePosition)
+		d.compareErrors(join(path, "errors"), old.Errors, new.Errors)
+	case "object", "params":
+		d.compareObject(path, old, new, pos)
+	case "array":
+		d.lowerBound(join(path, "minLength"), pos, intValue(old.MinLength), intValue(new.MinLength))
+		d.upperBound(join(path, "maxLength"), pos, intValue(old.MaxLength), intValue(new.MaxLength))
+		if old.Items != nil && new.Items != nil {
+			d.compare(join(path, "items"), old.Items, new.Items, pos)
+		}
+	case "string":
+		d.compareFormat(join(path, "format"), pos, old.Format, new.Format)
+		d.lowerBound(join(path, "minLength"), pos, intValue(old.MinLength), intValue(new.MinLength))
+		d.upperBound(join(path, "maxLength"), pos, intValue(old.MaxLength), intValue(new.MaxLength))
+		d.upperBound(join(path, "maxGraphemes"), pos, intValue(old.MaxGraphemes), intValue(new.MaxGraphemes))
+		d.compareConst(join(path, "const"), pos, old.Const, new.Const)
+		d.compareEnum(join(path, "enum"), pos, old.Enum, new.Enum)
+	case "integer", "number":
+		d.lowerBound(join(path, "minimum"), pos, old.Minimum, new.Minimum)
+		d.upperBound(join(path, "maximum"), pos, old.Maximum, new.Maximum)
+		d.compareConst(join(path, "const"), pos, old.Const, new.Const)
+		d.compareEnum(join(path, "enum"), pos, old.Enum, new.Enum)
+	case "boolean":
+		d.compareConst(join(path, "const"), pos, old.Const, new.Const)
+	case "bytes":
+		d.lowerBound(join(path, "minLength"), pos, intValue(old.MinLength), intValue(new.MinLength))
+		d.upperBound(join(path, "maxLength"), pos, intValue(old.MaxLength), intValue(new.MaxLength))
+	case "blob", "image", "video", "audio":
+		d.upperBound(join(path, "maxSize"), pos, intValue(old.MaxSize), intValue(new.MaxSize))
+		d.upperBound(join(path, "maxWidth"), pos, intValue(old.MaxWidth), intValue(new.MaxWidth))
+		d.upperBound(join(path, "maxHeight"), pos, intValue(old.MaxHeight), intValue(new.MaxHeight))
+		d.upperBound(join(path, "maxLength"), pos, intValue(old.MaxLength), intValue(new.MaxLength))
+		d.compareAccept(join(path, "accept"), pos, old.Accept, new.Accept)
+	case "ref":
+		oldRef, newRef := d.resolveRef(old.Ref), d.resolveRef(new.Ref)
+		if oldRef != newRef {
+			d.add(join(path, "ref"), RefChanged, true, "ref changed from %s to %s", oldRef, newRef)
+		}
+	case "union":
+		d.compareUnion(path, old, new, pos)
+	}
+}
+
+// compareObject compares two revisions of an object or params schema.
+func (d *differ) compareObject(path string, old, new *gen.TypeSchema, pos position) {
+	for _, name := range unionKeys(old.Properties, new.Properties) {
+		propPath := join(path, "properties", name)
+		oldProp, newProp := old.Properties[name], new.Properties[name]
+		oldRequired, newRequired := slices.Contains(old.Required, name), slices.Contains(new.Required, name)
+		switch {
+		case newProp == nil:
+			d.add(propPath, FieldRemoved, true, "field removed")
+			continue
+		case oldProp == nil:
+			if newRequired {
+				d.add(propPath, FieldAdded, pos.breaks(true), "required field added")
+			} else {
+				d.add(propPath, FieldAdded, false, "optional field added")
+			}
+			continue
+		case !oldRequired && newRequired:
+			d.add(propPath, FieldRequired, pos.breaks(true), "field changed from optional to required")
+		case oldRequired && !newRequired:
+			// Making a request field optional can't break a client that
+			// provides it, but clients may rely on data fields being present.
+			d.add(propPath, FieldOptional, pos != requestPosition, "field changed from required to optional")
+		}
+		oldNullable, newNullable := slices.Contains(old.Nullable, name), slices.Contains(new.Nullable, name)
+		if oldNullable != newNullable {
+			d.tightened(propPath, pos, oldNullable, "field changed from %s to %s", nullability(oldNullable), nullability(newNullable))
+		}
+		d.compare(propPath, oldProp, newProp, pos)
+	}
+}
+
+// compareParams compares two revisions of the parameters
+// of an XRPC definition, either of which may be nil.
+func (d *differ) compareParams(path string, old, new *gen.TypeSchema) {
+	if old == nil && new == nil {
+		return
+	}
+	// Having no parameters is the same as having none declared.
+	if old == nil {
+		old = &gen.TypeSchema{Type: "params"}
+	}
+	if new == nil {
+		new = &gen.TypeSchema{Type: "params"}
+	}
+	d.compare(path, old, new, requestPosition)
+}
+
+// compareBody compares two revisions of the input,
+// output or message of an XRPC definition.
+func (d *differ) compareBody(path string, old, new *gen.BodyType, pos position) {
+	switch {
+	case old == nil && new == nil:
+		return
+	case new == nil:
+		d.add(path, BodyRemoved, true, "%s removed", lastElem(path))
+		return
+	case old == nil:
+		// A new output can't break clients that ignore it,
+		// but a new input must be provided by clients.
+		d.add(path, BodyAdded, pos == requestPosition, "%s added", lastElem(path))
+		return
+	}
+	if old.Encoding != new.Encoding {
+		d.add(join(path, "encoding"), EncodingChanged, true, "encoding changed from %q to %q", old.Encoding, new.Encoding)
+	}
+	schemaPath := join(path, "schema")
+	switch {
+	case old.Schema == nil && new.Schema == nil:
+	case new.Schema == nil:
+		d.tightened(schemaPath, pos, false, "schema removed")
+	case old.Schema == nil:
+		d.tightened(schemaPath, pos, true, "schema added")
+	default:
+		d.compare(schemaPath, old.Schema, new.Schema, pos)
+	}
+}
+
+// compareErrors compares the errors declared by two
+// revisions of an XRPC definition.
+func (d *differ) compareErrors(path string, old, new []gen.Error) {
+	oldNames, newNames := errorNames(old), errorNames(new)
+	for _, name := range oldNames {
+		if !slices.Contains(newNames, name) {
+			d.add(path, ErrorRemoved, false, "error %s removed", name)
+		}
+	}
+	for _, name := range newNames {
+		if !slices.Contains(oldNames, name) {
+			d.add(path, ErrorAdded, false, "error %s added", name)
+		}
+	}
+}
+
+// compareUnion compares two revisions of a union schema.
+func (d *differ) compareUnion(path string, old, new *gen.TypeSchema, pos position) {
+	oldRefs, newRefs := d.resolveRefs(old.Refs), d.resolveRefs(new.Refs)
+	refsPath := join(path, "refs")
+	for _, ref := range oldRefs {
+		if !slices.Contains(newRefs, ref) {
+			d.add(refsPath, MemberRemoved, pos.breaks(true), "union member %s removed", ref)
+		}
+	}
+	for _, ref := range newRefs {
+		if !slices.Contains(oldRefs, ref) {
+			// Clients must be prepared for unknown members
+			// of open unions, but not of closed ones.
+			d.add(refsPath, MemberAdded, new.Closed && pos != requestPosition, "union member %s added", ref)
+		}
+	}
+	if old.Closed != new.Closed {
+		d.tightened(join(path, "closed"), pos, new.Closed, "union changed from %s to %s", openness(old.Closed), openness(new.Closed))
+	}
+}
+
+// compareEnum compares two revisions of an enum.
+func (d *differ) compareEnum(path string, pos position, old, new []any) {
+	switch {
+	case len(old) == 0 && len(new) == 0:
+	case len(new) == 0:
+		d.tightened(path, pos, false, "enum removed")
+	case len(old) == 0:
+		d.tightened(path, pos, true, "enum added")
+	default:
+		oldValues, newValues := jsonValues(old), jsonValues(new)
+		if removed := missing(oldValues, newValues); len(removed) > 0 {
+			d.add(path, EnumNarrowed, pos.breaks(true), "enum values removed: %s", strings.Join(removed, ", "))
+		}
+		if added := missing(newValues, oldValues); len(added) > 0 {
+			d.add(path, EnumWidened, pos.breaks(false), "enum values added: %s", strings.Join(added, ", "))
+		}
+	}
+}
+
+// compareConst compares two revisions of a const value.
+func (d *differ) compareConst(path string, pos position, old, new any) {
+	oldValue, newValue := jsonValue(old), jsonValue(new)
+	switch {
+	case oldValue == newValue:
+	case new == nil:
+		d.tightened(path, pos, false, "const removed")
+	case old == nil:
+		d.tightened(path, pos, true, "const %s added", newValue)
+	default:
+		// Neither value allows the other, so this
+		// breaks clients whichever way the data flows.
+		d.add(path, Tightened, true, "const changed from %s to %s", oldValue, newValue)
+	}
+}
+
+// compareFormat compares two revisions of a string format.
+func (d *differ) compareFormat(path string, pos position, old, new string) {
+	switch {
+	case old == new:
+	case new == "":
+		d.tightened(path, pos, false, "format %s removed", old)
+	case old == "":
+		d.tightened(path, pos, true, "format %s added", new)
+	default:
+		d.add(path, Tightened, true, "format changed from %s to %s", old, new)
+	}
+}
+
+// compareAccept compares two revisions of the
+// MIME types accepted by a blob.
+func (d *differ) compareAccept(path string, pos position, old, new []string) {
+	switch {
+	case len(old) == 0 && len(new) == 0:
+	case len(new) == 0:
+		d.tightened(path, pos, false, "accept removed")
+	case len(old) == 0:
+		d.tightened(path, pos, true, "accept added")
+	default:
+		var removed, added []string
+		for _, t := range old {
+			if !acceptsType(new, t) {
+				removed = append(removed, t)
+			}
+		}
+		for _, t := range new {
+			if !acceptsType(old, t) {
+				added = append(added, t)
+			}
+		}
+		if len(removed) > 0 {
+			d.tightened(path, pos, true, "no longer accepts %s", strings.Join(removed, ", "))
+		}
+		if len(added) > 0 {
+			d.tightened(path, pos, false, "now accepts %s", strings.Join(added, ", "))
+		}
+	}
+}
+
+// upperBound compares two revisions of an upper bound
+// such as maxLength. A nil bound is unlimited.
+func (d *differ) upperBound(path string, pos position, old, new any) {
+	d.bound(path, pos, old, new, func(old, new float64) bool {
+		return new < old
+	})
+}
+
+// lowerBound compares two revisions of a lower bound
+// such as minimum. A nil bound is unlimited.
+func (d *differ) lowerBound(path string, pos position, old, new any) {
+	d.bound(path, pos, old, new, func(old, new float64) bool {
+		return new > old
+	})
+}
+
+func (d *differ) bound(path string, pos position, old, new any, tighter func(old, new float64) bool) {
+	keyword := lastElem(path)
+	oldValue, oldOK := number(old)
+	newValue, newOK := number(new)
+	switch {
+	case !oldOK && !newOK:
+	case !newOK:
+		d.tightened(path, pos, false, "%s %v removed", keyword, oldValue)
+	case !oldOK:
+		d.tightened(path, pos, true, "%s %v added", keyword, newValue)
+	case oldValue < newValue:
+		d.tightened(path, pos, tighter(oldValue, newValue), "%s increased from %v to %v", keyword, oldValue, newValue)
+	case oldValue > newValue:
+		d.tightened(path, pos, tighter(oldValue, newValue), "%s reduced from %v to %v", keyword, oldValue, newValue)
+	}
+}
+
+// resolveRef returns the fully qualified name of
+// the definition referred to by ref.
+func (d *differ) resolveRef(ref string) string {
+	return gen.TypeName(gen.SplitRef(d.id, ref))
+}
+
+func (d *differ) resolveRefs(refs []string) []string {
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = d.resolveRef(ref)
+	}
+	return names
+}
+
+// acceptsType reports whether the MIME type t is accepted
+// by the patterns in accept, which may include ranges such as
+// "image/*" or "*/*".
+func acceptsType(accept []string, t string) bool {
+	for _, pattern := range accept {
+		if pattern == t || pattern == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// number returns x as a float64, reporting
+// whether it holds a number at all.
+func number(x any) (float64, bool) {
+	switch x := x.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	}
+	return 0, false
+}
+
+// intValue returns *p, or nil if p is nil.
+func intValue(p *int) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// jsonValue returns x encoded as JSON.
+func jsonValue(x any) string {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return fmt.Sprint(x)
+	}
+	return string(data)
+}
+
+func jsonValues(xs []any) []string {
+	values := make([]string, len(xs))
+	for i, x := range xs {
+		values[i] = jsonValue(x)
+	}
+	return values
+}
+
+// missing returns the elements of xs that aren't in ys.
+func missing(xs, ys []string) []string {
+	var m []string
+	for _, x := range xs {
+		if !slices.Contains(ys, x) {
+			m = append(m, x)
+		}
+	}
+	return m
+}
+
+func errorNames(errs []gen.Error) []string {
+	names := make([]string, len(errs))
+	for i, e := range errs {
+		names[i] = e.Name
+	}
+	return names
+}
+
+func nullability(nullable bool) string {
+	if nullable {
+		return "nullable"
+	}
+	return "non-nullable"
+}
+
+func openness(closed bool) string {
+	if closed {
+		return "closed"
+	}
+	return "open"
+}
+
+func schemasByID(schemas []*gen.Schema) map[string]*gen.Schema {
+	m := make(map[string]*gen.Schema)
+	for _, s := range schemas {
+		m[s.ID] = s
+	}
+	return m
+}
+
+// join joins the non-empty elements of a dot-separated path.
+func join(elems ...string) string {
+	var nonEmpty []string
+	for _, e := range elems {
+		if e != "" {
+			nonEmpty = append(nonEmpty, e)
+		}
+	}
+	return strings.Join(nonEmpty, ".")
+}
+
+func lastElem(path string) string {
+	return path[strings.LastIndex(path, ".")+1:]
+}
+
+// unionKeys returns the keys that are in either
+// of the given maps, in sorted order.
+func unionKeys[V any](m1, m2 map[string]V) []string {
+	ks := sorted.Keys(m1)
+	for k := range m2 {
+		if _, ok := m1[k]; !ok {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	return ks
+}
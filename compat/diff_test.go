@@ -0,0 +1,159 @@
+package compat_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/compat"
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+var diffTests = []struct {
+	testName string
+	old, new string
+	want     []string
+}{{
+	testName: "RecordMaxLengthReduced",
+	old:      `{"type": "record", "record": {"type": "object", "properties": {"text": {"type": "string", "maxLength": 100}}}}`,
+	new:      `{"type": "record", "record": {"type": "object", "properties": {"text": {"type": "string", "maxLength": 50}}}}`,
+	want: []string{
+		"breaking com.example.test record.properties.text.maxLength: maxLength reduced from 100 to 50 (constraint-tightened)",
+	},
+}, {
+	testName: "OutputMaxLengthReduced",
+	old:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"text": {"type": "string", "maxLength": 100}}}}}`,
+	new:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"text": {"type": "string", "maxLength": 50}}}}}`,
+	want: []string{
+		"compatible com.example.test output.schema.properties.text.maxLength: maxLength reduced from 100 to 50 (constraint-tightened)",
+	},
+}, {
+	testName: "OutputMaxLengthIncreased",
+	old:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"text": {"type": "string", "maxLength": 100}}}}}`,
+	new:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"text": {"type": "string", "maxLength": 200}}}}}`,
+	want: []string{
+		"breaking com.example.test output.schema.properties.text.maxLength: maxLength increased from 100 to 200 (constraint-loosened)",
+	},
+}, {
+	testName: "OutputEnum",
+	old:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"n": {"type": "integer", "enum": [1, 2]}}}}}`,
+	new:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"n": {"type": "integer", "enum": [2, 3]}}}}}`,
+	want: []string{
+		"compatible com.example.test output.schema.properties.n.enum: enum values removed: 1 (enum-narrowed)",
+		"breaking com.example.test output.schema.properties.n.enum: enum values added: 3 (enum-widened)",
+	},
+}, {
+	testName: "OutputFieldRequired",
+	old:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"a": {"type": "string"}}}}}`,
+	new:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "required": ["a"], "properties": {"a": {"type": "string"}}}}}`,
+	want: []string{
+		"compatible com.example.test output.schema.properties.a: field changed from optional to required (field-required)",
+	},
+}, {
+	testName: "ParamsMaximumReduced",
+	old:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 100}}}}`,
+	new:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 50}}}}`,
+	want: []string{
+		"breaking com.example.test parameters.properties.limit.maximum: maximum reduced from 100 to 50 (constraint-tightened)",
+	},
+}, {
+	testName: "ParamsMaximumIncreased",
+	old:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 100}}}}`,
+	new:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 200}}}}`,
+	want: []string{
+		"compatible com.example.test parameters.properties.limit.maximum: maximum increased from 100 to 200 (constraint-loosened)",
+	},
+}, {
+	testName: "OutputConstChanged",
+	old:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"v": {"type": "integer", "const": 1}}}}}`,
+	new:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"v": {"type": "integer", "const": 2}}}}}`,
+	want: []string{
+		"breaking com.example.test output.schema.properties.v.const: const changed from 1 to 2 (constraint-tightened)",
+	},
+}, {
+	testName: "OpenUnionMemberAdded",
+	old:      `{"type": "object", "properties": {"u": {"type": "union", "refs": ["#a"]}}}`,
+	new:      `{"type": "object", "properties": {"u": {"type": "union", "refs": ["#a", "#b"]}}}`,
+	want: []string{
+		"compatible com.example.test properties.u.refs: union member com.example.test#b added (union-member-added)",
+	},
+}, {
+	testName: "ClosedUnionMemberAdded",
+	old:      `{"type": "object", "properties": {"u": {"type": "union", "refs": ["#a"], "closed": true}}}`,
+	new:      `{"type": "object", "properties": {"u": {"type": "union", "refs": ["#a", "#b"], "closed": true}}}`,
+	want: []string{
+		"breaking com.example.test properties.u.refs: union member com.example.test#b added (union-member-added)",
+	},
+}, {
+	testName: "ClosedUnionMemberAddedToInput",
+	old:      `{"type": "procedure", "input": {"encoding": "application/json", "schema": {"type": "union", "refs": ["#a"], "closed": true}}}`,
+	new:      `{"type": "procedure", "input": {"encoding": "application/json", "schema": {"type": "union", "refs": ["#a", "#b"], "closed": true}}}`,
+	want: []string{
+		"compatible com.example.test input.schema.refs: union member com.example.test#b added (union-member-added)",
+	},
+}}
+
+func TestDiff(t *testing.T) {
+	for _, test := range diffTests {
+		t.Run(test.testName, func(t *testing.T) {
+			changes := compat.Diff(
+				[]*gen.Schema{testSchema(t, test.old)},
+				[]*gen.Schema{testSchema(t, test.new)},
+			)
+			var got []string
+			for _, c := range changes {
+				status := "compatible"
+				if c.Breaking {
+					status = "breaking"
+				}
+				got = append(got, status+" "+c.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("unexpected changes\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+// testSchema returns a com.example.test lexicon
+// with the given JSON as its main definition.
+func testSchema(t *testing.T, main string) *gen.Schema {
+	t.Helper()
+	var schema gen.Schema
+	err := json.Unmarshal([]byte(`{"lexicon": 1, "id": "com.example.test", "defs": {"main": `+main+`}}`), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &schema
+}
+
+// TestDiffRefFromOutput pins down that definitions reached
+// through a ref are compared as data, whatever refers to them.
+func TestDiffRefFromOutput(t *testing.T) {
+	const lexicon = `{
+		"lexicon": 1,
+		"id": "com.example.test",
+		"defs": {
+			"main": {"type": "query", "output": {"encoding": "application/json", "schema": {"type": "ref", "ref": "#view"}}},
+			"view": {"type": "object", "properties": {"text": {"type": "string", "maxLength": %d}}}
+		}
+	}`
+	var oldSchema, newSchema gen.Schema
+	if err := json.Unmarshal([]byte(fmt.Sprintf(lexicon, 100)), &oldSchema); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(lexicon, 50)), &newSchema); err != nil {
+		t.Fatal(err)
+	}
+	changes := compat.Diff([]*gen.Schema{&oldSchema}, []*gen.Schema{&newSchema})
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes, want 1: %v", len(changes), changes)
+	}
+	c := changes[0]
+	// The same change in the output schema itself would not be breaking.
+	const want = "com.example.test#view properties.text.maxLength: maxLength reduced from 100 to 50 (constraint-tightened)"
+	if got := c.String(); got != want || !c.Breaking {
+		t.Errorf("unexpected change %q (breaking %v), want %q (breaking true)", got, c.Breaking, want)
+	}
+}
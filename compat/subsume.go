@@ -0,0 +1,592 @@
+package compat
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/ast/astutil"
+	"cuelang.org/go/cue/token"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// Incompatibility describes a place where one revision of
+// a definition does not subsume the other: there are instances
+// that are valid under one revision but not the other.
+type Incompatibility struct {
+	// Def holds the fully qualified name of the definition.
+	Def string
+
+	// Part holds the part of the definition that was checked,
+	// as accepted by gen.Lexicons.Part, or the empty string
+	// if the definition itself was checked.
+	Part string
+
+	// Path holds the path within the part to a value that's
+	// allowed by the more specific revision but not by the
+	// more general one, as determined by Direction.
+	Path []string
+
+	// Direction holds the direction in which the part was checked.
+	Direction Direction
+
+	// Msg describes the problem.
+	Msg string
+}
+
+func (inc *Incompatibility) String() string {
+	e := gen.InstanceError{Path: inc.Path, Msg: inc.Msg}
+	if inc.Part == "" {
+		return fmt.Sprintf("%s: %v", inc.Def, &e)
+	}
+	return fmt.Sprintf("%s %s: %v", inc.Def, inc.Part, &e)
+}
+
+// Direction says which revision of a part of a
+// definition must subsume the other.
+type Direction int
+
+const (
+	// Forward means that every instance that's valid under the old
+	// revision must be valid under the new one, so that existing
+	// data remains valid and servers using the new revision accept
+	// parameters from existing clients, as Diff requires. It's used
+	// for records, parameters and definitions that aren't checked
+	// by part.
+	Forward Direction = iota
+
+	// Backward means that every instance that's valid under the new
+	// revision must be valid under the old one. It's used for outputs
+	// and messages, so that clients using the old revision can read
+	// them, as Diff requires, and for inputs, so that requests from
+	// clients using the new revision are accepted by servers
+	// using the old one.
+	Backward
+)
+
+func (d Direction) String() string {
+	if d == Backward {
+		return "old subsumes new"
+	}
+	return "new subsumes old"
+}
+
+// Subsume checks each definition that's in both oldLexicons and
+// newLexicons using CUE subsumption, in the direction appropriate
+// to each part of the definition, and returns the incompatibilities
+// found, ordered by definition name. Both sets of lexicons must
+// have been loaded with the same cue.Context.
+//
+// Records are checked by their record schema and XRPC definitions by
+// their parameters, input, output and message schemas; any other
+// definition is checked as a whole.
+//
+// CUE's subsumption is not complete: constraints that it can't compare,
+// such as two different regular expressions, are reported as
+// incompatibilities even if one is in fact more general.
+func Subsume(oldLexicons, newLexicons *gen.Lexicons) []*Incompatibility {
+	var incs []*Incompatibility
+	for _, id := range oldLexicons.IDs() {
+		oldSchema, newSchema := oldLexicons.Schema(id), newLexicons.Schema(id)
+		if newSchema == nil {
+			continue
+		}
+		for _, name := range sorted.Keys(oldSchema.Defs) {
+			if newSchema.Defs[name] == nil {
+				continue
+			}
+			def := gen.TypeName(id, name)
+			for _, part := range checkedParts(oldSchema.Defs[name]) {
+				oldValue, oldErr := lookupPart(oldLexicons, def, part)
+				newValue, newErr := lookupPart(newLexicons, def, part)
+				if oldErr != nil || newErr != nil {
+					// The part was added or removed, which is
+					// already evident from the lexicons.
+					continue
+				}
+				dir := partDirection(part)
+				s := &subsumer{
+					general:  "new",
+					specific: "old",
+					visiting: make(map[[2]string]bool),
+				}
+				w, v := newValue, oldValue
+				if dir == Backward {
+					s.general, s.specific = s.specific, s.general
+					w, v = v, w
+				}
+				for _, inc := range s.subsumes(w, v, nil) {
+					inc.Def = def
+					inc.Part = part
+					inc.Direction = dir
+					incs = append(incs, inc)
+				}
+			}
+		}
+	}
+	return incs
+}
+
+// checkedParts returns the parts of the definition t
+// that Subsume checks.
+func checkedParts(t *gen.TypeSchema) []string {
+	switch t.Type {
+	case "record":
+		return []string{"record"}
+	case "query":
+		return []string{"parameters", "output"}
+	case "procedure":
+		return []string{"parameters", "input", "output"}
+	case "subscription":
+		return []string{"parameters", "message"}
+	}
+	return []string{""}
+}
+
+// partDirection returns the direction in which
+// the given part is checked.
+func partDirection(part string) Direction {
+	switch part {
+	case "input", "output", "message":
+		return Backward
+	}
+	return Forward
+}
+
+func lookupPart(l *gen.Lexicons, def, part string) (cue.Value, error) {
+	if part == "" {
+		return l.Def(def)
+	}
+	return l.Part(def, part)
+}
+
+// subsumer checks subsumption between two revisions of a value.
+type subsumer struct {
+	// general and specific hold the names of the revisions
+	// that should be more general and more specific,
+	// for use in messages.
+	general, specific string
+
+	// visiting holds the pairs of references to definitions,
+	// in the general and specific revisions respectively,
+	// that are currently being checked. Recursive definitions
+	// refer back to themselves, so finding the same pair again
+	// means that there's nothing new to check.
+	visiting map[[2]string]bool
+}
+
+// subsumes checks whether w subsumes v: that is, whether every
+// instance of v is also an instance of w. If not, it returns
+// incompatibilities describing counterexamples, with their paths
+// relative to the value at path.
+//
+// CUE's Subsume method does not handle disjunctions with defaults,
+// list element types or builtin validators such as strings.MaxRunes,
+// so subsumes walks the values itself, only using Subsume to compare
+// the remaining constraints on scalar values.
+func (s *subsumer) subsumes(w, v cue.Value, path []string) []*Incompatibility {
+	if key, ok := refPair(w, v); ok {
+		if s.visiting[key] {
+			return nil
+		}
+		s.visiting[key] = true
+		defer delete(s.visiting, key)
+	}
+	var incs []*Incompatibility
+	for _, dv := range disjuncts(v) {
+		incs = append(incs, s.subsumesDisjunct(w, dv, path)...)
+	}
+	return incs
+}
+
+// refPair returns the paths of the definitions that w and v
+// refer to, reporting whether they are both references.
+func refPair(w, v cue.Value) ([2]string, bool) {
+	_, wp := w.ReferencePath()
+	_, vp := v.ReferencePath()
+	if len(wp.Selectors()) == 0 || len(vp.Selectors()) == 0 {
+		return [2]string{}, false
+	}
+	return [2]string{wp.String(), vp.String()}, true
+}
+
+// subsumesDisjunct checks whether w subsumes v,
+// which is not a disjunction.
+func (s *subsumer) subsumesDisjunct(w, v cue.Value, path []string) []*Incompatibility {
+	var best []*Incompatibility
+	for _, dw := range disjuncts(w) {
+		incs := s.subsumesValue(dw, v, path)
+		if len(incs) == 0 {
+			return nil
+		}
+		// Report the problems with the disjunct that
+		// matches the most, such as the union member
+		// with the same $type.
+		if best == nil || depth(incs) > depth(best) || depth(incs) == depth(best) && len(incs) < len(best) {
+			best = incs
+		}
+	}
+	return best
+}
+
+// depth returns the length of the longest path in incs.
+func depth(incs []*Incompatibility) int {
+	n := 0
+	for _, inc := range incs {
+		n = max(n, len(inc.Path))
+	}
+	return n
+}
+
+// subsumesValue checks whether w subsumes v, where
+// neither is a disjunction.
+func (s *subsumer) subsumesValue(w, v cue.Value, path []string) []*Incompatibility {
+	if isStructuralCycle(w) && isStructuralCycle(v) && w.Err().Error() == v.Err().Error() {
+		// CUE found the same structural cycle in both revisions.
+		return nil
+	}
+	wk, vk := kind(w), kind(v)
+	if wk == cue.TopKind {
+		return nil
+	}
+	if vk&^wk != 0 {
+		return incompatible(path, "%s allows %v but %s only allows %v", s.specific, vk, s.general, wk)
+	}
+	switch vk {
+	case cue.StructKind:
+		return s.subsumesStruct(w, v, path)
+	case cue.ListKind:
+		return s.subsumesList(w, v, path)
+	case cue.StringKind:
+		if incs := s.subsumesStringLimits(w, v, path); incs != nil {
+			return incs
+		}
+	}
+	return s.subsumesScalar(w, v, path)
+}
+
+// isStructuralCycle reports whether v is an error caused by a
+// structural cycle. CUE doesn't export the error code, so
+// this looks at the message.
+func isStructuralCycle(v cue.Value) bool {
+	err := v.Err()
+	return err != nil && strings.Contains(err.Error(), "structural cycle")
+}
+
+// kind returns the kind of the values allowed by v.
+func kind(v cue.Value) cue.Kind {
+	if k := v.IncompleteKind(); k != cue.BottomKind {
+		return k
+	}
+	// A list with validators such as list.MinItems evaluates
+	// to an error because the list is incomplete, so use
+	// the kind of the list without them.
+	return baseConstraint(v, false).IncompleteKind()
+}
+
+// subsumesStruct checks whether the struct w subsumes the struct v.
+func (s *subsumer) subsumesStruct(w, v cue.Value, path []string) []*Incompatibility {
+	if v.Allows(cue.AnyString) && !w.Allows(cue.AnyString) {
+		return incompatible(path, "%s allows any field but %s is closed", s.specific, s.general)
+	}
+	iter, err := v.Fields(cue.Optional(true))
+	if err != nil {
+		return incompatible(path, "%v", err)
+	}
+	var incs []*Incompatibility
+	for iter.Next() {
+		name := iter.Selector().Unquoted()
+		fieldPath := append(path[:len(path):len(path)], name)
+		wf := w.LookupPath(cue.MakePath(cue.Str(name).Optional()))
+		if !wf.Exists() {
+			if !w.Allows(cue.Str(name)) {
+				incs = append(incs, incompatible(fieldPath, "field allowed by %s but not by %s", s.specific, s.general)...)
+			}
+			continue
+		}
+		incs = append(incs, s.subsumes(wf, iter.Value(), fieldPath)...)
+	}
+	iter, err = w.Fields(cue.Optional(true))
+	if err != nil {
+		return append(incs, incompatible(path, "%v", err)...)
+	}
+	for iter.Next() {
+		if iter.Selector().ConstraintType() != cue.RequiredConstraint {
+			continue
+		}
+		name := iter.Selector().Unquoted()
+		vf := v.LookupPath(cue.MakePath(cue.Str(name).Optional()))
+		if !vf.Exists() || isOptional(v, name) {
+			incs = append(incs, incompatible(append(path[:len(path):len(path)], name), "field required by %s but optional in %s", s.general, s.specific)...)
+		}
+	}
+	return incs
+}
+
+// isOptional reports whether the field with the
+// given name in the struct v is optional.
+func isOptional(v cue.Value, name string) bool {
+	iter, err := v.Fields(cue.Optional(true))
+	if err != nil {
+		return false
+	}
+	for iter.Next() {
+		if iter.Selector().Unquoted() == name {
+			return iter.IsOptional()
+		}
+	}
+	return false
+}
+
+// subsumesList checks whether the list w subsumes the list v.
+func (s *subsumer) subsumesList(w, v cue.Value, path []string) []*Incompatibility {
+	var incs []*Incompatibility
+	elemPath := append(path[:len(path):len(path)], "0")
+	ve := baseConstraint(v, false).LookupPath(cue.MakePath(cue.AnyIndex))
+	we := baseConstraint(w, false).LookupPath(cue.MakePath(cue.AnyIndex))
+	switch {
+	case !ve.Exists():
+	case !we.Exists():
+		incs = incompatible(elemPath, "%s allows elements but %s does not", s.specific, s.general)
+	default:
+		incs = s.subsumes(we, ve, elemPath)
+	}
+	// Evaluating a list loses any validators such as list.MaxItems,
+	// so look for them in the unevaluated expression.
+	return append(incs, s.subsumesValidators(validators(w, false), validators(v, false), path)...)
+}
+
+// subsumesStringLimits checks the limits on lexicon strings,
+// which are held in definitions inside the lexicue #string
+// definition rather than in the string constraint itself.
+func (s *subsumer) subsumesStringLimits(w, v cue.Value, path []string) []*Incompatibility {
+	for _, limit := range []struct {
+		name  string
+		upper bool
+	}{
+		{"#minLength", false},
+		{"#maxLength", true},
+		{"#maxGraphemes", true},
+	} {
+		p := cue.MakePath(cue.Def(limit.name))
+		wn, werr := w.LookupPath(p).Int64()
+		vn, verr := v.LookupPath(p).Int64()
+		switch {
+		case werr != nil:
+		case verr != nil:
+			return incompatible(path, "%s has %s %d but %s has no limit", s.general, limit.name[1:], wn, s.specific)
+		case limit.upper && wn < vn, !limit.upper && wn > vn:
+			return incompatible(path, "%s has %s %d but %s has %d", s.specific, limit.name[1:], vn, s.general, wn)
+		}
+	}
+	return nil
+}
+
+// subsumesScalar checks whether the scalar constraint w
+// subsumes the scalar constraint v.
+func (s *subsumer) subsumesScalar(w, v cue.Value, path []string) []*Incompatibility {
+	if v.IsConcrete() {
+		if err := w.Unify(v).Validate(cue.Concrete(true)); err != nil {
+			return incompatible(path, "%s allows %v but %s does not", s.specific, v, s.general)
+		}
+		return nil
+	}
+	wv, vv := validators(w, true), validators(v, true)
+	if incs := s.subsumesValidators(wv, vv, path); incs != nil {
+		return incs
+	}
+	wb, vb := baseConstraint(w, true), baseConstraint(v, true)
+	if err := wb.Subsume(vb, cue.Raw()); err != nil {
+		return incompatible(path, "%s allows %v but %s only allows %v", s.specific, vb, s.general, wb)
+	}
+	return nil
+}
+
+// validator describes a call to a builtin validator
+// such as strings.MaxRunes(300).
+type validator struct {
+	name string
+	args []cue.Value
+}
+
+func (v validator) String() string {
+	args := make([]string, len(v.args))
+	for i, arg := range v.args {
+		args[i] = fmt.Sprint(arg)
+	}
+	return v.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// validators returns the builtin validators that are conjuncts of v.
+// If eval is true, the validators are found in the evaluated value,
+// otherwise in the expression that v was derived from.
+func validators(v cue.Value, eval bool) []validator {
+	if eval {
+		v = v.Eval()
+	}
+	var vs []validator
+	for _, c := range conjuncts(v) {
+		op, args := c.Expr()
+		if op != cue.CallOp || len(args) == 0 {
+			continue
+		}
+		vs = append(vs, validator{
+			name: fmt.Sprint(args[0]),
+			args: args[1:],
+		})
+	}
+	return vs
+}
+
+// subsumesValidators checks that each validator in wv
+// is implied by one in vv.
+func (s *subsumer) subsumesValidators(wv, vv []validator, path []string) []*Incompatibility {
+outer:
+	for _, w := range wv {
+		for _, v := range vv {
+			if implies(v, w) {
+				continue outer
+			}
+		}
+		return incompatible(path, "%s requires %v", s.general, w)
+	}
+	return nil
+}
+
+// sizeLimits maps from the name of each builtin validator that
+// limits the size of a value to whether its argument is an
+// upper bound rather than a lower one.
+var sizeLimits = map[string]bool{
+	"list.MaxItems":    true,
+	"list.MinItems":    false,
+	"strings.MaxRunes": true,
+	"strings.MinRunes": false,
+	"struct.MaxFields": true,
+	"struct.MinFields": false,
+}
+
+// implies reports whether any value that satisfies
+// v also satisfies w.
+func implies(v, w validator) bool {
+	if v.name != w.name || len(v.args) != len(w.args) {
+		return false
+	}
+	if upper, ok := sizeLimits[v.name]; ok && len(v.args) == 1 {
+		vn, verr := v.args[0].Int64()
+		wn, werr := w.args[0].Int64()
+		if verr == nil && werr == nil {
+			if upper {
+				return vn <= wn
+			}
+			return vn >= wn
+		}
+	}
+	return v.String() == w.String()
+}
+
+// baseConstraint returns v without any builtin validators.
+// If eval is true, the validators are removed from the evaluated
+// value, otherwise from the expression that v was derived from.
+func baseConstraint(v cue.Value, eval bool) cue.Value {
+	if eval {
+		v = v.Eval()
+	}
+	var base cue.Value
+	for _, c := range conjuncts(v) {
+		if op, _ := c.Expr(); op == cue.CallOp {
+			continue
+		}
+		if !base.Exists() {
+			base = c
+		} else {
+			base = base.Unify(c)
+		}
+	}
+	if !base.Exists() {
+		return v.Context().CompileString("_")
+	}
+	return base
+}
+
+// conjuncts returns the conjuncts of v.
+func conjuncts(v cue.Value) []cue.Value {
+	op, args := v.Expr()
+	if op != cue.AndOp {
+		return []cue.Value{v}
+	}
+	var cs []cue.Value
+	for _, arg := range args {
+		cs = append(cs, conjuncts(arg)...)
+	}
+	return cs
+}
+
+// disjuncts returns the disjuncts of v, ignoring defaults
+// on scalar values. Concrete disjuncts that are also allowed
+// by another disjunct, as defaults usually are, are omitted.
+func disjuncts(v cue.Value) []cue.Value {
+	ds := allDisjuncts(v)
+	if len(ds) < 2 {
+		return ds
+	}
+	var pruned []cue.Value
+outer:
+	for i, d := range ds {
+		if d.IsConcrete() {
+			for j, other := range ds {
+				if j != i && !other.IsConcrete() && other.Unify(d).Validate(cue.Concrete(true)) == nil {
+					continue outer
+				}
+			}
+		}
+		pruned = append(pruned, d)
+	}
+	return pruned
+}
+
+func allDisjuncts(v cue.Value) []cue.Value {
+	if _, ok := v.Default(); ok && v.IncompleteKind()&(cue.StructKind|cue.ListKind) == 0 {
+		v = withoutDefaults(v)
+	}
+	op, args := v.Eval().Expr()
+	if op != cue.OrOp {
+		return []cue.Value{v}
+	}
+	var ds []cue.Value
+	for _, arg := range args {
+		ds = append(ds, allDisjuncts(arg)...)
+	}
+	return ds
+}
+
+// withoutDefaults returns the scalar value v with its default markers
+// removed, so that the disjuncts can be found. CUE evaluates a
+// disjunction with a default to a single value, which hides the
+// disjuncts.
+func withoutDefaults(v cue.Value) cue.Value {
+	x, ok := v.Syntax(cue.Raw()).(ast.Expr)
+	if !ok {
+		return v
+	}
+	x = astutil.Apply(x, nil, func(c astutil.Cursor) bool {
+		if u, ok := c.Node().(*ast.UnaryExpr); ok && u.Op == token.MUL {
+			c.Replace(u.X)
+		}
+		return true
+	}).(ast.Expr)
+	stripped := v.Context().BuildExpr(x, cue.InferBuiltins(true))
+	if stripped.Err() != nil {
+		return v
+	}
+	return stripped
+}
+
+func incompatible(path []string, msg string, args ...any) []*Incompatibility {
+	return []*Incompatibility{{
+		Path: path,
+		Msg:  fmt.Sprintf(msg, args...),
+	}}
+}
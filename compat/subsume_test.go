@@ -0,0 +1,156 @@
+package compat_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/compat"
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+var subsumeTests = []struct {
+	testName string
+	old, new string
+	want     []string
+}{{
+	testName: "RecursiveUnchanged",
+	old:      `{"type": "object", "required": ["text"], "properties": {"text": {"type": "string", "maxLength": 100}, "replies": {"type": "array", "items": {"type": "ref", "ref": "#main"}}}}`,
+	new:      `{"type": "object", "required": ["text"], "properties": {"text": {"type": "string", "maxLength": 100}, "replies": {"type": "array", "items": {"type": "ref", "ref": "#main"}}}}`,
+}, {
+	testName: "RecursiveTightened",
+	old:      `{"type": "object", "required": ["text"], "properties": {"text": {"type": "string", "maxLength": 100}, "replies": {"type": "array", "items": {"type": "ref", "ref": "#main"}}}}`,
+	new:      `{"type": "object", "required": ["text"], "properties": {"text": {"type": "string", "maxLength": 50}, "replies": {"type": "array", "items": {"type": "ref", "ref": "#main"}}}}`,
+	want: []string{
+		"new subsumes old: com.example.test: replies.0.text: old has maxLength 100 but new has 50",
+		"new subsumes old: com.example.test: text: old has maxLength 100 but new has 50",
+	},
+}, {
+	testName: "DefaultChanged",
+	old:      `{"type": "object", "properties": {"n": {"type": "integer", "minimum": 1, "maximum": 10, "default": 3}}}`,
+	new:      `{"type": "object", "properties": {"n": {"type": "integer", "minimum": 1, "maximum": 10, "default": 5}}}`,
+}, {
+	testName: "DefaultRangeNarrowed",
+	old:      `{"type": "object", "properties": {"n": {"type": "integer", "minimum": 1, "maximum": 10, "default": 3}}}`,
+	new:      `{"type": "object", "properties": {"n": {"type": "integer", "minimum": 1, "maximum": 5, "default": 3}}}`,
+	want: []string{
+		"new subsumes old: com.example.test: n: old allows uint & >=1 & <=10 but new only allows uint & >=1 & <=5",
+	},
+}, {
+	testName: "DefaultRangeWidened",
+	old:      `{"type": "object", "properties": {"n": {"type": "integer", "minimum": 1, "maximum": 10, "default": 3}}}`,
+	new:      `{"type": "object", "properties": {"n": {"type": "integer", "minimum": 0, "maximum": 20, "default": 3}}}`,
+}, {
+	testName: "StringLimitsTightened",
+	old:      `{"type": "object", "properties": {"s": {"type": "string", "minLength": 1, "maxGraphemes": 10}}}`,
+	new:      `{"type": "object", "properties": {"s": {"type": "string", "minLength": 2, "maxGraphemes": 10}}}`,
+	want: []string{
+		"new subsumes old: com.example.test: s: old has minLength 1 but new has 2",
+	},
+}, {
+	testName: "StringLimitsLoosened",
+	old:      `{"type": "object", "properties": {"s": {"type": "string", "minLength": 2, "maxGraphemes": 10}}}`,
+	new:      `{"type": "object", "properties": {"s": {"type": "string", "maxGraphemes": 20}}}`,
+}, {
+	testName: "StringLimitAdded",
+	old:      `{"type": "object", "properties": {"s": {"type": "string"}}}`,
+	new:      `{"type": "object", "properties": {"s": {"type": "string", "maxGraphemes": 20}}}`,
+	want: []string{
+		"new subsumes old: com.example.test: s: new has maxGraphemes 20 but old has no limit",
+	},
+}, {
+	testName: "ListLimitsTightened",
+	old:      `{"type": "object", "properties": {"l": {"type": "array", "items": {"type": "string"}, "minLength": 1, "maxLength": 5}}}`,
+	new:      `{"type": "object", "properties": {"l": {"type": "array", "items": {"type": "string"}, "minLength": 1, "maxLength": 4}}}`,
+	want: []string{
+		"new subsumes old: com.example.test: l: new requires list.MaxItems(4)",
+	},
+}, {
+	testName: "ListLimitsLoosened",
+	old:      `{"type": "object", "properties": {"l": {"type": "array", "items": {"type": "string"}, "minLength": 2, "maxLength": 5}}}`,
+	new:      `{"type": "object", "properties": {"l": {"type": "array", "items": {"type": "string"}, "minLength": 1, "maxLength": 6}}}`,
+}, {
+	testName: "ListElementsTightened",
+	old:      `{"type": "object", "properties": {"l": {"type": "array", "items": {"type": "integer"}}}}`,
+	new:      `{"type": "object", "properties": {"l": {"type": "array", "items": {"type": "integer", "maximum": 9}}}}`,
+	want: []string{
+		"new subsumes old: com.example.test: l.0: old allows int but new only allows <=9 & int",
+	},
+}, {
+	testName: "ParamsLoosened",
+	old:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 50}}}}`,
+	new:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 100}}}}`,
+}, {
+	testName: "ParamsTightened",
+	old:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 100}}}}`,
+	new:      `{"type": "query", "parameters": {"type": "params", "properties": {"limit": {"type": "integer", "maximum": 50}}}}`,
+	want: []string{
+		"new subsumes old: com.example.test parameters: limit: old allows <=100 & int but new only allows <=50 & int",
+	},
+}, {
+	testName: "OutputTightened",
+	old:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"s": {"type": "string", "maxLength": 100}}}}}`,
+	new:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"s": {"type": "string", "maxLength": 50}}}}}`,
+}, {
+	testName: "OutputLoosened",
+	old:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"s": {"type": "string", "maxLength": 50}}}}}`,
+	new:      `{"type": "query", "output": {"encoding": "application/json", "schema": {"type": "object", "properties": {"s": {"type": "string", "maxLength": 100}}}}}`,
+	want: []string{
+		"old subsumes new: com.example.test output: s: new has maxLength 100 but old has 50",
+	},
+}, {
+	testName: "InputLoosened",
+	old:      `{"type": "procedure", "input": {"encoding": "application/json", "schema": {"type": "object", "properties": {"s": {"type": "string", "maxLength": 50}}}}}`,
+	new:      `{"type": "procedure", "input": {"encoding": "application/json", "schema": {"type": "object", "properties": {"s": {"type": "string", "maxLength": 100}}}}}`,
+	want: []string{
+		"old subsumes new: com.example.test input: s: new has maxLength 100 but old has 50",
+	},
+}}
+
+func TestSubsume(t *testing.T) {
+	for _, test := range subsumeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			ctx := cuecontext.New()
+			incs := compat.Subsume(
+				loadLexicons(t, ctx, testSchema(t, test.old)),
+				loadLexicons(t, ctx, testSchema(t, test.new)),
+			)
+			var got []string
+			for _, inc := range incs {
+				got = append(got, fmt.Sprintf("%s: %v", inc.Direction, inc))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("unexpected incompatibilities\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+// loadLexicons generates CUE for the given lexicons
+// using the single-package layout and loads the result.
+func loadLexicons(t *testing.T, ctx *cue.Context, schemas ...*gen.Schema) *gen.Lexicons {
+	t.Helper()
+	g, err := gen.New(gen.Config{
+		ModuleRoot: "lexicon.me/defs",
+		UseMap:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*gen.File
+	for _, schema := range schemas {
+		f, err := g.Generate(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	l, err := g.Load(ctx, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/compat"
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+// diffModes holds the checks made by each mode of the diff command.
+var diffModes = map[string]struct{ structural, cue bool }{
+	"structural": {true, false},
+	"cue":        {false, true},
+	"both":       {true, true},
+}
+
+// runDiff implements the diff command, which compares two
+// revisions of a set of lexicons and reports the changes
+// that break compatibility.
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	all := flags.Bool("a", false, "report non-breaking changes too")
+	mode := flags.String("mode", "structural", "compare the lexicon definitions (structural), check subsumption of the generated CUE (cue), or do both (both)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue diff [flags] old new\n")
+		fmt.Fprintf(os.Stderr, "\nIn cue mode, records and parameters are checked that every instance\n")
+		fmt.Fprintf(os.Stderr, "valid under the old revision is valid under the new one, and inputs,\n")
+		fmt.Fprintf(os.Stderr, "outputs and messages the other way around.\n")
+		fmt.Fprintf(os.Stderr, "\nThe exit status is 1 if any breaking changes are found.\n\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	checks, ok := diffModes[*mode]
+	if !ok || flags.NArg() != 2 {
+		flags.Usage()
+	}
+	oldDir, newDir := flags.Arg(0), flags.Arg(1)
+	exitStatus := 0
+	if checks.structural && !diffStructure(oldDir, newDir, *all) {
+		exitStatus = 1
+	}
+	if checks.cue && !diffCUE(oldDir, newDir) {
+		exitStatus = 1
+	}
+	return exitStatus
+}
+
+// diffStructure prints the changes between the lexicons in oldDir
+// and newDir, reporting whether there were no breaking changes.
+// Non-breaking changes are only printed if all is true.
+func diffStructure(oldDir, newDir string, all bool) bool {
+	oldSchemas, err := gen.ReadLexicons(oldDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return false
+	}
+	newSchemas, err := gen.ReadLexicons(newDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return false
+	}
+	ok := true
+	for _, c := range compat.Diff(oldSchemas, newSchemas) {
+		switch {
+		case c.Breaking:
+			fmt.Printf("breaking: %v\n", c)
+			ok = false
+		case all:
+			fmt.Printf("non-breaking: %v\n", c)
+		}
+	}
+	return ok
+}
+
+// diffCUE checks subsumption between the CUE generated for the
+// lexicons in oldDir and newDir, printing any incompatibilities
+// and reporting whether there were none.
+func diffCUE(oldDir, newDir string) bool {
+	// Both sets of lexicons must use the same context
+	// so that their values can be compared.
+	ctx := cuecontext.New()
+	oldLexicons, err := loadLexicons(ctx, oldDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return false
+	}
+	newLexicons, err := loadLexicons(ctx, newDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return false
+	}
+	incs := compat.Subsume(oldLexicons, newLexicons)
+	for _, inc := range incs {
+		fmt.Printf("%s: %v\n", inc.Direction, inc)
+	}
+	return len(incs) == 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+// runExport implements the export command, which converts CUE
+// written in the form produced by the generator back into lexicon JSON.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	outDir := flags.String("o", "", "write lexicon files to `dir` rather than as a txtar archive on stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue export [flags] cuemoduledir...\n")
+		fmt.Fprintf(os.Stderr, "\nThe CUE must be in the form produced by the generator;\n")
+		fmt.Fprintf(os.Stderr, "see the documentation for gen.ExportModule for details.\n\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+	}
+	lexiconSchema, err := gen.LexiconSchema(cuecontext.New())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	exitStatus := 0
+	for _, dir := range flags.Args() {
+		schemas, err := gen.ExportModule(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitStatus = 1
+		}
+		for _, schema := range schemas {
+			name := strings.ReplaceAll(schema.ID, ".", "/") + ".json"
+			data, err := json.MarshalIndent(schema, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", schema.ID, err)
+				exitStatus = 1
+				continue
+			}
+			data = append(data, '\n')
+			if err := gen.ValidateJSON(data, name, lexiconSchema); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: invalid lexicon: %v\n", schema.ID, errors.Details(err, nil))
+				exitStatus = 1
+				continue
+			}
+			if *outDir == "" {
+				fmt.Printf("-- %s --\n", name)
+				os.Stdout.Write(data)
+				continue
+			}
+			p := filepath.Join(*outDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			if err := os.WriteFile(p, data, 0o666); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+		}
+	}
+	return exitStatus
+}
@@ -0,0 +1,126 @@
+package gen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/ast/astutil"
+
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// BreakCycles removes any import cycles between the packages
+// generated so far and returns the files that replace or add to
+// those previously returned by Generate. It should be called
+// after all the lexicons in a set have been generated.
+//
+// The definitions from all the lexicons in a cycle are moved into a
+// single shared package, where they are generated as entries in
+// a #def map so that they can refer to one another without
+// any imports. The packages in the cycle are regenerated to
+// refer to the definitions in the shared package, so references
+// from elsewhere remain unchanged.
+func (g *Generator) BreakCycles() ([]*File, error) {
+	if g.cfg.UseMap {
+		// There's only one package, so there can be no cycles.
+		return nil, nil
+	}
+	var files []*File
+	for _, c := range g.deps.Cycles() {
+		cfiles, err := g.breakCycle(c)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, cfiles...)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+func (g *Generator) breakCycle(c Cycle) ([]*File, error) {
+	inCycle := make(map[string]bool)
+	for _, pkg := range c.Packages {
+		inCycle[pkg] = true
+	}
+	// Find the lexicons that were generated into the cycle's packages.
+	members := make(map[string]bool)
+	for _, id := range sorted.Keys(g.schemas) {
+		if pkg, err := g.lexiconPkg(id); err == nil && inCycle[pkg] {
+			members[id] = true
+		}
+	}
+	// The packages are about to be regenerated, so forget
+	// their existing dependencies.
+	for a := range g.deps.Arcs {
+		if inCycle[a.From] {
+			delete(g.deps.Arcs, a)
+		}
+	}
+	shared := g.cfg.ModuleRoot + "/shared/" + strings.TrimPrefix(c.Packages[0], g.cfg.ModuleRoot+"/")
+	var files []*File
+	for _, id := range sorted.Keys(members) {
+		schema := g.schemas[id]
+		sg := g.newGenerator(id, shared, true)
+		sg.shared = members
+		f, err := sg.generate(schema)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate shared package for %s: %v", id, err)
+		}
+		pkg, _ := g.lexiconPkg(id)
+		af, err := g.newGenerator(id, pkg, false).aliasFile(schema, shared)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f, af)
+	}
+	return files, nil
+}
+
+// aliasFile returns a file that defines each definition in
+// the given lexicon as the corresponding entry in the #def map
+// of the shared package.
+func (g *generator) aliasFile(schema *Schema, shared string) (*File, error) {
+	astf := &ast.File{
+		Decls: []ast.Decl{
+			&ast.Package{
+				Name: ast.NewIdent(impliedImportIdent(g.pkg)),
+			},
+		},
+	}
+	sharedDef := func(name string) ast.Expr {
+		g.currentDef = "#" + name
+		return &ast.IndexExpr{
+			X:     g.externalRef(shared, "#def"),
+			Index: stringLit(g.id + "#" + name),
+		}
+	}
+	if t := schema.Defs["main"]; t != nil {
+		setDescription(astf.Decls[0], t.Description)
+		astf.Decls = append(astf.Decls, &ast.EmbedDecl{
+			Expr: sharedDef("main"),
+		})
+	}
+	for _, name := range sorted.Keys(schema.Defs) {
+		if name == "main" {
+			continue
+		}
+		astf.Decls = append(astf.Decls, &ast.Field{
+			Label: ast.NewIdent("#" + name),
+			Value: sharedDef(name),
+		})
+		setDescription(astf.Decls[len(astf.Decls)-1], schema.Defs[name].Description)
+	}
+	if err := astutil.Sanitize(astf); err != nil {
+		return nil, fmt.Errorf("cannot sanitize %q: %v", g.id, err)
+	}
+	return &File{
+		Path:    g.filePath(),
+		Package: g.pkg,
+		Schema:  schema,
+		Syntax:  astf,
+	}, nil
+}
@@ -0,0 +1,203 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// Dependencies records the dependencies between generated packages.
+type Dependencies struct {
+	// Arcs maps from each package-level dependency to the
+	// set of identifier-level dependencies that cause it.
+	// For an identifier-level arc, From holds the referring
+	// definition and To holds the referenced identifier,
+	// or the empty string when the whole package is referenced.
+	Arcs map[Arc]map[Arc]bool
+}
+
+// Arc represents a dependency of From on To.
+type Arc struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func newDependencies() *Dependencies {
+	return &Dependencies{
+		Arcs: make(map[Arc]map[Arc]bool),
+	}
+}
+
+// Cycle describes a set of packages that import one another.
+type Cycle struct {
+	// Packages holds all the packages in the strongly
+	// connected component of the dependency graph, in sorted order.
+	Packages []string
+
+	// Path holds a shortest cycle through the component,
+	// starting and ending at Packages[0].
+	Path []string
+}
+
+// Cycles returns a Cycle for each set of packages in deps that
+// import one another, sorted by first package. CUE does not allow
+// import cycles, so any such packages will fail to load.
+func (deps *Dependencies) Cycles() []Cycle {
+	arcs := make(map[string][]string)
+	for _, d := range sortedArcs(deps.Arcs) {
+		arcs[d.From] = append(arcs[d.From], d.To)
+	}
+	var cycles []Cycle
+	for _, scc := range stronglyConnected(arcs) {
+		if len(scc) == 1 && !slices.Contains(arcs[scc[0]], scc[0]) {
+			continue
+		}
+		sort.Strings(scc)
+		cycles = append(cycles, Cycle{
+			Packages: scc,
+			Path:     shortestCycle(scc, arcs),
+		})
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].Packages[0] < cycles[j].Packages[0]
+	})
+	return cycles
+}
+
+// WriteCycles writes a description of any import cycles in deps to w,
+// along with the identifiers that cause them. Package paths
+// are written relative to moduleRoot.
+func (deps *Dependencies) WriteCycles(w io.Writer, moduleRoot string) {
+	rel := func(pkg string) string {
+		return strings.TrimPrefix(pkg, moduleRoot+"/")
+	}
+	for _, c := range deps.Cycles() {
+		pkgs := c.Path
+		for i, pkg := range pkgs {
+			fmt.Fprintf(w, "%s", rel(pkg))
+			if i < len(pkgs)-1 {
+				fmt.Fprintf(w, " ->\n")
+			} else {
+				fmt.Fprintln(w)
+				break
+			}
+			for _, ia := range sortedArcs(deps.Arcs[Arc{pkgs[i], pkgs[i+1]}]) {
+				to := ia.To
+				if to == "" {
+					to = "*"
+				}
+				fmt.Fprintf(w, "\t%s -> %s\n", ia.From, to)
+			}
+		}
+		if len(c.Packages) > len(c.Path)-1 {
+			// Mention the other packages in the component
+			// that aren't on the representative cycle.
+			var others []string
+			for _, pkg := range c.Packages {
+				if !slices.Contains(c.Path, pkg) {
+					others = append(others, rel(pkg))
+				}
+			}
+			fmt.Fprintf(w, "(also involving %s)\n", strings.Join(others, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// stronglyConnected returns the strongly connected components
+// of the graph with the given arcs, using Tarjan's algorithm.
+func stronglyConnected(arcs map[string][]string) [][]string {
+	var (
+		index   = make(map[string]int)
+		lowLink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		sccs    [][]string
+	)
+	var connect func(v string)
+	connect = func(v string) {
+		index[v] = len(index)
+		lowLink[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range arcs[v] {
+			if _, ok := index[w]; !ok {
+				connect(w)
+				lowLink[v] = min(lowLink[v], lowLink[w])
+			} else if onStack[w] {
+				lowLink[v] = min(lowLink[v], index[w])
+			}
+		}
+		if lowLink[v] != index[v] {
+			return
+		}
+		var scc []string
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			scc = append(scc, w)
+			if w == v {
+				break
+			}
+		}
+		sccs = append(sccs, scc)
+	}
+	for _, v := range sorted.Keys(arcs) {
+		if _, ok := index[v]; !ok {
+			connect(v)
+		}
+	}
+	return sccs
+}
+
+// shortestCycle returns a shortest path from scc[0] back to itself
+// that stays within the strongly connected component scc.
+func shortestCycle(scc []string, arcs map[string][]string) []string {
+	start := scc[0]
+	// prev holds the predecessor of each node on the
+	// shortest path from start.
+	prev := make(map[string]string)
+	queue := []string{start}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range arcs[v] {
+			if !slices.Contains(scc, w) {
+				continue
+			}
+			if w == start {
+				path := []string{start}
+				for p := v; p != start; p = prev[p] {
+					path = append(path, p)
+				}
+				path = append(path, start)
+				return rev(path)
+			}
+			if _, ok := prev[w]; !ok {
+				prev[w] = v
+				queue = append(queue, w)
+			}
+		}
+	}
+	panic("no cycle found in strongly connected component")
+}
+
+// sortedArcs returns the keys of m sorted by From then To.
+func sortedArcs[V any](m map[Arc]V) []Arc {
+	arcs := make([]Arc, 0, len(m))
+	for a := range m {
+		arcs = append(arcs, a)
+	}
+	sort.Slice(arcs, func(i, j int) bool {
+		if arcs[i].From != arcs[j].From {
+			return arcs[i].From < arcs[j].From
+		}
+		return arcs[i].To < arcs[j].To
+	})
+	return arcs
+}
@@ -0,0 +1,1027 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/literal"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+	"github.com/kr/fs"
+)
+
+// ExportModule converts all the CUE files in the module rooted at dir,
+// written in the form produced by a Generator, back into lexicon schemas.
+// Both the per-package layout and the layout produced
+// when Config.UseMap is set are understood.
+//
+// The CUE is converted by recognizing its syntax rather than by
+// evaluating it, so hand-written CUE must keep to the same form:
+//
+//   - Each lexicon is a package at the path given by PackagePath,
+//     with the main definition embedded at the top level and the other
+//     definitions as #name fields, or, in the map layout, a field of
+//     #def with a label such as "app.bsky.feed.post#replyRef".
+//     Descriptions are taken from doc comments, and that of the
+//     main definition from the package doc comment.
+//   - Records, queries, procedures, subscriptions, tokens, images,
+//     videos and audio are the lexicue definition of that name
+//     unified with a struct literal holding the lexicon fields,
+//     as in lexicue.record & {key: "tid", record!: {...}}.
+//   - Objects are struct literals. Required properties are marked
+//     with !, and nullable ones are a disjunction ending in null.
+//   - Other types are spelled string, int, number, bool, bytes, _,
+//     lexicue.blob or lexicue.cidLink, optionally unified with bounds
+//     (>=1 & <=5), list.MinItems or list.MaxItems, a string format
+//     such as lexicue.#datetime, or, for length limits and known
+//     values, a struct embedding lexicue.#string.
+//   - A literal is a const, and a disjunction of literals is an enum,
+//     which may mark its default with *.
+//   - A reference is the name of a definition, which may be
+//     qualified by an import of another lexicon's package.
+//   - A union is a disjunction of references, each unified with a
+//     struct holding its required $type, and, if the union is open,
+//     lexicue.#unknownObject constrained to the other types.
+//
+// Anything else, such as a comprehension, a let clause or a reference
+// to a regular field, results in an error.
+func ExportModule(dir string) ([]*Schema, error) {
+	moduleRoot, err := readModulePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	var schemas []*Schema
+	var errs []string
+	for w := fs.Walk(dir); w.Step(); {
+		if err := w.Err(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", w.Path(), err))
+			continue
+		}
+		if w.Stat().IsDir() {
+			if w.Stat().Name() == "cue.mod" {
+				w.SkipDir()
+			}
+			continue
+		}
+		if !strings.HasSuffix(w.Path(), ".cue") {
+			continue
+		}
+		rel, err := filepath.Rel(dir, w.Path())
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		fileSchemas, err := exportFile(w.Path(), filepath.ToSlash(rel), moduleRoot)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", w.Path(), err))
+			continue
+		}
+		schemas = append(schemas, fileSchemas...)
+	}
+	if len(errs) > 0 {
+		return schemas, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return schemas, nil
+}
+
+// readModulePath returns the module path declared in
+// the cue.mod/module.cue file inside dir.
+func readModulePath(dir string) (string, error) {
+	p := filepath.Join(dir, "cue.mod", "module.cue")
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	v := cuecontext.New().CompileBytes(data, cue.Filename(p))
+	module, err := v.LookupPath(cue.ParsePath("module")).String()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine module path: %v", errors.Details(err, nil))
+	}
+	return module, nil
+}
+
+// exportFile converts the CUE file at path p, with the slash-separated
+// name rel relative to the module root, into lexicon schemas.
+func exportFile(p, rel, moduleRoot string) ([]*Schema, error) {
+	f, err := parser.ParseFile(p, nil, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("%v", errors.Details(err, nil))
+	}
+	x := &exporter{
+		moduleRoot: moduleRoot,
+		imports:    make(map[string]string),
+	}
+	for _, spec := range f.Imports {
+		ipath, err := literal.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		name := impliedImportIdent(ipath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		x.imports[name] = ipath
+	}
+	if defs := lookupField(f.Decls, "#def"); defs != nil {
+		// Generated with -m: all definitions are in a single map.
+		return x.exportDefMap(defs.Value)
+	}
+	id, err := x.pkg2ID(moduleRoot + "/" + path.Dir(rel))
+	if err != nil {
+		return nil, err
+	}
+	x.id = id
+	schema := &Schema{
+		Lexicon: 1,
+		ID:      id,
+		Defs:    make(map[string]*TypeSchema),
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.Package, *ast.ImportDecl, *ast.CommentGroup, *ast.Attribute:
+		case *ast.EmbedDecl:
+			e := decl.Expr
+			if ident, ok := e.(*ast.Ident); ok && ident.Name == "_#def" {
+				// Main is an object closed by defining it as _#def.
+				field := lookupField(f.Decls, "_#def")
+				if field == nil {
+					return nil, fmt.Errorf("_#def embedded but not defined")
+				}
+				e = field.Value
+			}
+			t, err := x.definition(e)
+			if err != nil {
+				return nil, fmt.Errorf("bad main definition: %v", err)
+			}
+			t.Description = packageDescription(f)
+			schema.Defs["main"] = t
+		case *ast.Field:
+			name, _, _ := ast.LabelName(decl.Label)
+			if name == "_#def" {
+				continue
+			}
+			if !strings.HasPrefix(name, "#") {
+				return nil, fmt.Errorf("unexpected field %q", name)
+			}
+			t, err := x.definition(decl.Value)
+			if err != nil {
+				return nil, fmt.Errorf("bad definition %q: %v", name, err)
+			}
+			t.Description = description(decl)
+			schema.Defs[strings.TrimPrefix(name, "#")] = t
+		default:
+			return nil, fmt.Errorf("unexpected declaration %T", decl)
+		}
+	}
+	if len(schema.Defs) == 0 {
+		return nil, nil
+	}
+	return []*Schema{schema}, nil
+}
+
+// exportDefMap converts the value of the #def field produced by
+// the -m layout, which holds entries keyed by fully qualified definition
+// name, into lexicon schemas, one for each distinct NSID.
+func (x *exporter) exportDefMap(e ast.Expr) ([]*Schema, error) {
+	lit, ok := e.(*ast.StructLit)
+	if !ok {
+		return nil, fmt.Errorf("#def is not a struct")
+	}
+	var schemas []*Schema
+	byID := make(map[string]*Schema)
+	for _, decl := range lit.Elts {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			return nil, fmt.Errorf("unexpected declaration %T in #def", decl)
+		}
+		label, _, err := ast.LabelName(field.Label)
+		if err != nil {
+			return nil, err
+		}
+		id, name, ok := strings.Cut(label, "#")
+		if !ok {
+			name = "main"
+		}
+		schema := byID[id]
+		if schema == nil {
+			schema = &Schema{
+				Lexicon: 1,
+				ID:      id,
+				Defs:    make(map[string]*TypeSchema),
+			}
+			byID[id] = schema
+			schemas = append(schemas, schema)
+		}
+		x.id = id
+		t, err := x.definition(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad definition %q: %v", label, err)
+		}
+		t.Description = description(field)
+		schema.Defs[name] = t
+	}
+	return schemas, nil
+}
+
+// exporter holds the state for converting a CUE file
+// into lexicon schemas.
+type exporter struct {
+	moduleRoot string
+	// id holds the NSID of the lexicon being exported.
+	id string
+	// imports maps from import identifier to import path.
+	imports map[string]string
+}
+
+// definition returns the lexicon type for the given
+// generated top level definition.
+func (x *exporter) definition(e ast.Expr) (*TypeSchema, error) {
+	terms := conjuncts(e)
+	kind := x.lexicueName(terms[0])
+	var t *TypeSchema
+	switch kind {
+	case "query", "procedure", "record", "subscription", "image", "video", "audio":
+		t = &TypeSchema{
+			Type: kind,
+		}
+	case "token":
+		return &TypeSchema{
+			Type: "token",
+		}, nil
+	default:
+		return x.typeOf(e)
+	}
+	for _, term := range terms[1:] {
+		lit, ok := unparen(term).(*ast.StructLit)
+		if !ok {
+			return nil, fmt.Errorf("unexpected constraint %s on %s", source(term), kind)
+		}
+		if err := x.addDefinitionFields(t, lit); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// addDefinitionFields fills out t from the fields in the struct
+// that's unified with the lexicue definition for its type.
+func (x *exporter) addDefinitionFields(t *TypeSchema, lit *ast.StructLit) error {
+	for _, decl := range lit.Elts {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			return fmt.Errorf("unexpected declaration %T in %s", decl, t.Type)
+		}
+		name, _, err := ast.LabelName(field.Label)
+		if err != nil {
+			return err
+		}
+		var err1 error
+		switch name {
+		case "key":
+			t.Key, err1 = stringValue(field.Value)
+		case "record":
+			t.Record, err1 = x.typeOf(field.Value)
+			if t.Record != nil {
+				t.Record.Description = description(field)
+			}
+		case "parameters":
+			t.Parameters, err1 = x.typeOf(field.Value)
+			if t.Parameters != nil {
+				t.Parameters.Type = "params"
+				t.Parameters.Description = description(field)
+			}
+		case "input":
+			t.Input, err1 = x.xrpcBody(field.Value)
+		case "output":
+			t.Output, err1 = x.xrpcBody(field.Value)
+		case "message":
+			t.Message, err1 = x.subscriptionMessage(field)
+		case "errors":
+			t.Errors, err1 = xrpcErrors(field.Value)
+		case "width":
+			t.MaxWidth, err1 = maxConstraint(field.Value)
+		case "height":
+			t.MaxHeight, err1 = maxConstraint(field.Value)
+		case "length":
+			t.MaxLength, err1 = maxConstraint(field.Value)
+		case "size":
+			t.MaxSize, err1 = maxConstraint(field.Value)
+		case "mimeType":
+			t.Accept, err1 = acceptValue(field.Value)
+		default:
+			return fmt.Errorf("unexpected field %q in %s", name, t.Type)
+		}
+		if err1 != nil {
+			return fmt.Errorf("bad %s field: %v", name, err1)
+		}
+	}
+	return nil
+}
+
+func (x *exporter) xrpcBody(e ast.Expr) (*BodyType, error) {
+	lit, ok := unparen(e).(*ast.StructLit)
+	if !ok {
+		return nil, fmt.Errorf("body is not a struct")
+	}
+	body := &BodyType{}
+	for _, decl := range lit.Elts {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			return nil, fmt.Errorf("unexpected declaration %T in body", decl)
+		}
+		name, _, _ := ast.LabelName(field.Label)
+		switch name {
+		case "encoding":
+			enc, err := stringValue(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			body.Encoding = enc
+		case "schema":
+			t, err := x.typeOf(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			t.Description = description(field)
+			body.Schema = t
+		default:
+			return nil, fmt.Errorf("unexpected field %q in body", name)
+		}
+	}
+	return body, nil
+}
+
+func (x *exporter) subscriptionMessage(field *ast.Field) (*SubscriptionMessage, error) {
+	schemaField := lookupField(structElts(field.Value), "schema")
+	if schemaField == nil {
+		return nil, fmt.Errorf("no schema in subscription message")
+	}
+	t, err := x.typeOf(schemaField.Value)
+	if err != nil {
+		return nil, err
+	}
+	t.Description = description(field)
+	return &SubscriptionMessage{
+		Schema: t,
+	}, nil
+}
+
+func xrpcErrors(e ast.Expr) ([]Error, error) {
+	list, ok := unparen(e).(*ast.ListLit)
+	if !ok {
+		return nil, fmt.Errorf("errors is not a list")
+	}
+	var errs []Error
+	for _, elt := range list.Elts {
+		var xerr Error
+		for _, decl := range structElts(elt) {
+			field, ok := decl.(*ast.Field)
+			if !ok {
+				continue
+			}
+			name, _, _ := ast.LabelName(field.Label)
+			s, err := stringValue(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			switch name {
+			case "name":
+				xerr.Name = s
+			case "description":
+				xerr.Description = s
+			}
+		}
+		if xerr.Name == "" {
+			return nil, fmt.Errorf("error without a name")
+		}
+		errs = append(errs, xerr)
+	}
+	return errs, nil
+}
+
+// typeOf returns the lexicon type corresponding to the CUE type
+// expression e.
+func (x *exporter) typeOf(e ast.Expr) (*TypeSchema, error) {
+	terms := disjuncts(e)
+	var dflt ast.Expr
+	if u, ok := terms[0].(*ast.UnaryExpr); ok && u.Op == token.MUL {
+		dflt = u.X
+		terms = terms[1:]
+	}
+	var t *TypeSchema
+	var err error
+	switch {
+	case len(terms) == 0:
+		return nil, fmt.Errorf("no type in %s", source(e))
+	case x.isUnionMember(terms[0]):
+		t, err = x.union(terms)
+	case len(terms) > 1 && x.isRef(terms[0]):
+		// An untagged union, as used for subscription messages.
+		t, err = x.union(terms)
+	case len(terms) == 1:
+		t, err = x.typeOfConjunction(conjuncts(terms[0]))
+	default:
+		t, err = enumType(terms)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dflt != nil {
+		v, _, err := literalValue(dflt)
+		if err != nil {
+			return nil, fmt.Errorf("bad default: %v", err)
+		}
+		t.Default = v
+		// Hand-written CUE usually marks the default of an enum
+		// within the enum itself, as in *"a" | "b", rather than
+		// unifying the default with the whole enum as the
+		// generator does.
+		switch {
+		case t.Enum != nil && !slices.Contains(t.Enum, v):
+			t.Enum = append([]any{v}, t.Enum...)
+		case t.Const != nil && t.Const != v && t.Type != "boolean" && len(terms) == 1:
+			t.Enum = []any{v, t.Const}
+			t.Const = nil
+		}
+	}
+	return t, nil
+}
+
+// typeOfConjunction returns the type represented by the
+// unification of the given terms. The first term determines
+// the kind of type; any other terms add constraints to it.
+func (x *exporter) typeOfConjunction(terms []ast.Expr) (*TypeSchema, error) {
+	t, err := x.typeOfTerm(terms[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range terms[1:] {
+		if err := x.addConstraint(t, unparen(term)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (x *exporter) typeOfTerm(e ast.Expr) (*TypeSchema, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return x.typeOf(e.X)
+	case *ast.Ident:
+		switch e.Name {
+		case "string", "bool", "bytes":
+			return &TypeSchema{Type: map[string]string{
+				"string": "string",
+				"bool":   "boolean",
+				"bytes":  "bytes",
+			}[e.Name]}, nil
+		case "int":
+			return &TypeSchema{Type: "integer"}, nil
+		case "number":
+			return &TypeSchema{Type: "number"}, nil
+		case "_":
+			return &TypeSchema{Type: "unknown"}, nil
+		case "true", "false":
+			return &TypeSchema{
+				Type:  "boolean",
+				Const: e.Name == "true",
+			}, nil
+		}
+	case *ast.BasicLit:
+		v, kind, err := literalValue(e)
+		if err != nil {
+			return nil, err
+		}
+		return &TypeSchema{
+			Type:  kind,
+			Const: v,
+		}, nil
+	case *ast.ListLit:
+		if len(e.Elts) != 1 {
+			return nil, fmt.Errorf("unexpected list %s", source(e))
+		}
+		ellipsis, ok := e.Elts[0].(*ast.Ellipsis)
+		if !ok || ellipsis.Type == nil {
+			return nil, fmt.Errorf("unexpected list %s", source(e))
+		}
+		items, err := x.typeOf(ellipsis.Type)
+		if err != nil {
+			return nil, err
+		}
+		return &TypeSchema{
+			Type:  "array",
+			Items: items,
+		}, nil
+	case *ast.StructLit:
+		if len(e.Elts) > 0 {
+			if embed, ok := e.Elts[0].(*ast.EmbedDecl); ok && x.lexicueName(embed.Expr) == "#string" {
+				t := &TypeSchema{Type: "string"}
+				if err := x.addStringConstraints(t, e); err != nil {
+					return nil, err
+				}
+				return t, nil
+			}
+		}
+		return x.object(e)
+	}
+	switch name := x.lexicueName(e); name {
+	case "":
+	case "blob":
+		return &TypeSchema{Type: "blob"}, nil
+	case "cidLink":
+		return &TypeSchema{Type: "cid-link"}, nil
+	default:
+		if format := formatForDef(name); format != "" {
+			return &TypeSchema{
+				Type:   "string",
+				Format: format,
+			}, nil
+		}
+		return nil, fmt.Errorf("unexpected use of lexicue.%s", name)
+	}
+	if ref, ok := x.refName(e); ok {
+		return &TypeSchema{
+			Type: "ref",
+			Ref:  ref,
+		}, nil
+	}
+	return nil, fmt.Errorf("unrecognized type %s", source(e))
+}
+
+// addConstraint adds the constraint in e to t.
+func (x *exporter) addConstraint(t *TypeSchema, e ast.Expr) error {
+	switch e := e.(type) {
+	case *ast.UnaryExpr:
+		v, _, err := literalValue(e.X)
+		if err != nil {
+			return err
+		}
+		switch e.Op {
+		case token.GEQ:
+			t.Minimum = v
+			return nil
+		case token.LEQ:
+			t.Maximum = v
+			return nil
+		}
+	case *ast.CallExpr:
+		if len(e.Args) != 1 {
+			break
+		}
+		n, err := intValue(e.Args[0])
+		if err != nil {
+			return err
+		}
+		switch source(e.Fun) {
+		case "list.MinItems":
+			t.MinLength = &n
+			return nil
+		case "list.MaxItems":
+			t.MaxLength = &n
+			return nil
+		}
+	case *ast.StructLit:
+		switch t.Type {
+		case "string":
+			return x.addStringConstraints(t, e)
+		case "blob":
+			return x.addDefinitionFields(t, e)
+		}
+	default:
+		if format := formatForDef(x.lexicueName(e)); format != "" && t.Type == "string" {
+			t.Format = format
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected constraint %s on %s", source(e), t.Type)
+}
+
+// addStringConstraints adds the constraints from a struct
+// embedding lexicue.#string.
+func (x *exporter) addStringConstraints(t *TypeSchema, lit *ast.StructLit) error {
+	for _, decl := range lit.Elts {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			continue
+		}
+		name, _, _ := ast.LabelName(field.Label)
+		if name == "#knownValues" {
+			list, ok := unparen(field.Value).(*ast.ListLit)
+			if !ok {
+				return fmt.Errorf("#knownValues is not a list")
+			}
+			for _, elt := range list.Elts {
+				v, err := x.knownValue(elt)
+				if err != nil {
+					return err
+				}
+				t.KnownValues = append(t.KnownValues, v)
+			}
+			continue
+		}
+		n, err := intValue(field.Value)
+		if err != nil {
+			return fmt.Errorf("bad %s: %v", name, err)
+		}
+		switch name {
+		case "#minLength":
+			t.MinLength = &n
+		case "#maxLength":
+			t.MaxLength = &n
+		case "#maxGraphemes":
+			t.MaxGraphemes = &n
+		default:
+			return fmt.Errorf("unexpected string constraint %q", name)
+		}
+	}
+	return nil
+}
+
+// knownValue returns the string value of an element of #knownValues.
+// The generator emits string literals, but a reference
+// to a token is accepted too.
+func (x *exporter) knownValue(e ast.Expr) (string, error) {
+	if s, err := stringValue(e); err == nil {
+		return s, nil
+	}
+	ref, ok := x.refName(e)
+	if !ok {
+		return "", fmt.Errorf("unexpected known value %s", source(e))
+	}
+	if strings.HasPrefix(ref, "#") {
+		ref = x.id + ref
+	}
+	return ref, nil
+}
+
+// object returns the object type represented by the given struct.
+func (x *exporter) object(lit *ast.StructLit) (*TypeSchema, error) {
+	t := &TypeSchema{
+		Type:       "object",
+		Properties: make(map[string]*TypeSchema),
+	}
+	for _, decl := range lit.Elts {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			return nil, fmt.Errorf("unexpected declaration %T in object", decl)
+		}
+		name, _, err := ast.LabelName(field.Label)
+		if err != nil {
+			return nil, err
+		}
+		if name == "$type" {
+			// Implied by the definition's name.
+			continue
+		}
+		value := field.Value
+		terms := disjuncts(value)
+		if len(terms) > 1 && isNull(terms[len(terms)-1]) {
+			t.Nullable = append(t.Nullable, name)
+			value = withoutLastDisjunct(value)
+		}
+		pt, err := x.typeOf(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad property %q: %v", name, err)
+		}
+		pt.Description = description(field)
+		if field.Constraint == token.NOT {
+			t.Required = append(t.Required, name)
+		}
+		t.Properties[name] = pt
+	}
+	return t, nil
+}
+
+// isUnionMember reports whether e looks like a member of a union:
+// a reference tagged with its $type, or an unknown object.
+func (x *exporter) isUnionMember(e ast.Expr) bool {
+	terms := conjuncts(e)
+	if x.lexicueName(terms[0]) == "#unknownObject" {
+		return true
+	}
+	if len(terms) != 2 {
+		return false
+	}
+	if !x.isRef(terms[0]) {
+		return false
+	}
+	elts := structElts(terms[1])
+	return len(elts) == 1 && lookupField(elts, "$type") != nil
+}
+
+func (x *exporter) isRef(e ast.Expr) bool {
+	_, ok := x.refName(e)
+	return ok
+}
+
+// union returns the union type represented by the given disjuncts.
+func (x *exporter) union(terms []ast.Expr) (*TypeSchema, error) {
+	t := &TypeSchema{
+		Type:   "union",
+		Closed: true,
+	}
+	for _, term := range terms {
+		conj := conjuncts(term)
+		if x.lexicueName(conj[0]) == "#unknownObject" {
+			t.Closed = false
+			continue
+		}
+		ref, ok := x.refName(conj[0])
+		if !ok {
+			return nil, fmt.Errorf("unexpected union member %s", source(term))
+		}
+		if len(conj) == 1 {
+			// Untagged unions don't record whether they're closed.
+			t.Closed = false
+		}
+		t.Refs = append(t.Refs, ref)
+	}
+	return t, nil
+}
+
+// enumType returns the type of an enumeration of literal values.
+func enumType(terms []ast.Expr) (*TypeSchema, error) {
+	t := &TypeSchema{}
+	for _, term := range terms {
+		v, kind, err := literalValue(term)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected disjunct %s", source(term))
+		}
+		if t.Type != "" && t.Type != kind {
+			if t.Type == "integer" && kind == "number" {
+				t.Type = kind
+			} else if !(t.Type == "number" && kind == "integer") {
+				return nil, fmt.Errorf("mixed types in enum")
+			}
+		} else {
+			t.Type = kind
+		}
+		t.Enum = append(t.Enum, v)
+	}
+	return t, nil
+}
+
+// refName returns the lexicon reference corresponding to e,
+// which refers to a definition in this or another package.
+func (x *exporter) refName(e ast.Expr) (string, bool) {
+	switch e := unparen(e).(type) {
+	case *ast.Ident:
+		if strings.HasPrefix(e.Name, "#") {
+			return e.Name, true
+		}
+		if ipath, ok := x.imports[e.Name]; ok {
+			id, err := x.pkg2ID(ipath)
+			return id, err == nil
+		}
+	case *ast.SelectorExpr:
+		pkg, ok := e.X.(*ast.Ident)
+		if !ok {
+			return "", false
+		}
+		ipath, ok := x.imports[pkg.Name]
+		if !ok || ipath == LexicuePkg {
+			return "", false
+		}
+		id, err := x.pkg2ID(ipath)
+		if err != nil {
+			return "", false
+		}
+		sel, _, _ := ast.LabelName(e.Sel)
+		return id + sel, true
+	case *ast.IndexExpr:
+		if ident, ok := e.X.(*ast.Ident); !ok || ident.Name != "#def" {
+			return "", false
+		}
+		ref, err := stringValue(e.Index)
+		if err != nil {
+			return "", false
+		}
+		ref = strings.TrimSuffix(ref, "#main")
+		if id, def, ok := strings.Cut(ref, "#"); ok && id == x.id {
+			ref = "#" + def
+		}
+		return ref, true
+	}
+	return "", false
+}
+
+// lexicueName returns the name selected from the lexicue
+// package by e, or the empty string if e isn't of that form.
+func (x *exporter) lexicueName(e ast.Expr) string {
+	sel, ok := unparen(e).(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok || x.imports[pkg.Name] != LexicuePkg {
+		return ""
+	}
+	name, _, _ := ast.LabelName(sel.Sel)
+	return name
+}
+
+// pkg2ID is the inverse of Generator.id2Pkg.
+func (x *exporter) pkg2ID(pkg string) (string, error) {
+	rel, ok := strings.CutPrefix(pkg, x.moduleRoot+"/")
+	if !ok {
+		return "", fmt.Errorf("package %q is outside module %q", pkg, x.moduleRoot)
+	}
+	authority, name, ok := strings.Cut(rel, "/")
+	if !ok || strings.Contains(name, "/") {
+		return "", fmt.Errorf("package %q does not correspond to a lexicon", pkg)
+	}
+	return strings.Join(rev(strings.Split(authority, ".")), ".") + "." + name, nil
+}
+
+// formatForDef returns the string format checked by the lexicue
+// definition with the given name, or the empty string if there is none.
+func formatForDef(def string) string {
+	for format, fdef := range StringFormats {
+		if fdef == def {
+			return format
+		}
+	}
+	return ""
+}
+
+func maxConstraint(e ast.Expr) (*int, error) {
+	u, ok := unparen(e).(*ast.UnaryExpr)
+	if !ok || u.Op != token.LEQ {
+		return nil, fmt.Errorf("unexpected constraint %s", source(e))
+	}
+	n, err := intValue(u.X)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// acceptValue is the inverse of addMimeType.
+func acceptValue(e ast.Expr) ([]string, error) {
+	var accept []string
+	for _, term := range disjuncts(e) {
+		if u, ok := term.(*ast.UnaryExpr); ok && u.Op == token.MAT {
+			pat, err := stringValue(u.X)
+			if err != nil {
+				return nil, err
+			}
+			accept = append(accept, strings.ReplaceAll(strings.TrimPrefix(pat, "^"), `\`, "")+"*")
+			continue
+		}
+		s, err := stringValue(term)
+		if err != nil {
+			return nil, err
+		}
+		accept = append(accept, s)
+	}
+	return accept, nil
+}
+
+// literalValue returns the Go value of the literal e along with its
+// lexicon type.
+func literalValue(e ast.Expr) (any, string, error) {
+	lit, ok := unparen(e).(*ast.BasicLit)
+	if !ok {
+		if ident, ok := unparen(e).(*ast.Ident); ok && (ident.Name == "true" || ident.Name == "false") {
+			return ident.Name == "true", "boolean", nil
+		}
+		return nil, "", fmt.Errorf("%s is not a literal", source(e))
+	}
+	switch lit.Kind {
+	case token.STRING:
+		s, err := literal.Unquote(lit.Value)
+		return s, "string", err
+	case token.TRUE, token.FALSE:
+		return lit.Kind == token.TRUE, "boolean", nil
+	case token.INT:
+		n, err := strconv.ParseInt(lit.Value, 0, 64)
+		return n, "integer", err
+	case token.FLOAT:
+		f, err := strconv.ParseFloat(lit.Value, 64)
+		return f, "number", err
+	}
+	return nil, "", fmt.Errorf("unexpected literal %s", lit.Value)
+}
+
+func stringValue(e ast.Expr) (string, error) {
+	v, kind, err := literalValue(e)
+	if err != nil {
+		return "", err
+	}
+	if kind != "string" {
+		return "", fmt.Errorf("%s is not a string", source(e))
+	}
+	return v.(string), nil
+}
+
+func intValue(e ast.Expr) (int, error) {
+	v, kind, err := literalValue(e)
+	if err != nil {
+		return 0, err
+	}
+	if kind != "integer" {
+		return 0, fmt.Errorf("%s is not an integer", source(e))
+	}
+	return int(v.(int64)), nil
+}
+
+// disjuncts returns the terms of the disjunction e.
+func disjuncts(e ast.Expr) []ast.Expr {
+	return binaryTerms(e, token.OR)
+}
+
+// conjuncts returns the terms of the unification e.
+func conjuncts(e ast.Expr) []ast.Expr {
+	return binaryTerms(e, token.AND)
+}
+
+func binaryTerms(e ast.Expr, op token.Token) []ast.Expr {
+	if b, ok := e.(*ast.BinaryExpr); ok && b.Op == op {
+		return append(binaryTerms(b.X, op), binaryTerms(b.Y, op)...)
+	}
+	return []ast.Expr{e}
+}
+
+// withoutLastDisjunct returns e without its final disjunct.
+func withoutLastDisjunct(e ast.Expr) ast.Expr {
+	return e.(*ast.BinaryExpr).X
+}
+
+func isNull(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		return e.Kind == token.NULL
+	case *ast.Ident:
+		return e.Name == "null"
+	}
+	return false
+}
+
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
+
+func structElts(e ast.Expr) []ast.Decl {
+	lit, ok := unparen(e).(*ast.StructLit)
+	if !ok {
+		return nil
+	}
+	return lit.Elts
+}
+
+func lookupField(decls []ast.Decl, name string) *ast.Field {
+	for _, decl := range decls {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			continue
+		}
+		if fieldName, _, _ := ast.LabelName(field.Label); fieldName == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// description is the inverse of setDescription.
+func description(n ast.Node) string {
+	var lines []string
+	for _, cg := range ast.Comments(n) {
+		if !cg.Doc {
+			continue
+		}
+		for _, c := range cg.List {
+			lines = append(lines, strings.TrimPrefix(strings.TrimPrefix(c.Text, "//"), " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// packageDescription returns the package doc comment of f,
+// which holds the description of the main definition.
+func packageDescription(f *ast.File) string {
+	for _, decl := range f.Decls {
+		if pkg, ok := decl.(*ast.Package); ok {
+			if desc := description(pkg); desc != "" {
+				return desc
+			}
+			break
+		}
+	}
+	// Comments before the package clause may also be attached to the file.
+	return description(f)
+}
@@ -0,0 +1,153 @@
+package gen_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	schemas, err := gen.ReadLexicons("testdata/lexicons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []struct {
+		testName string
+		cfg      gen.Config
+	}{{
+		testName: "PerPackage",
+		cfg: gen.Config{
+			ModuleRoot: "lexicon.me",
+		},
+	}, {
+		testName: "Map",
+		cfg: gen.Config{
+			ModuleRoot: "lexicon.me/defs",
+			UseMap:     true,
+		},
+	}} {
+		t.Run(layout.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			g, err := gen.New(layout.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, dir, "cue.mod/module.cue", g.ModuleFile())
+			for _, schema := range schemas {
+				f, err := g.Generate(schema)
+				if err != nil {
+					t.Fatal(err)
+				}
+				src, err := f.Source()
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, dir, f.Path, src)
+			}
+			exported, err := gen.ExportModule(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]any)
+			for _, schema := range exported {
+				got[schema.ID] = sortFieldLists(jsonValue(t, schema))
+			}
+			if len(got) != len(schemas) {
+				t.Errorf("got %d lexicons, want %d", len(got), len(schemas))
+			}
+			for _, schema := range schemas {
+				want := sortFieldLists(jsonValue(t, schema))
+				if !reflect.DeepEqual(got[schema.ID], want) {
+					t.Errorf("%s did not round trip\ngot  %s\nwant %s", schema.ID, jsonString(t, got[schema.ID]), jsonString(t, want))
+				}
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// jsonValue returns x as it would be decoded from JSON,
+// so that values can be compared regardless of their Go types.
+func jsonValue(t *testing.T, x any) any {
+	t.Helper()
+	data, err := json.Marshal(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// sortFieldLists sorts the required and nullable lists within
+// the JSON value x. CUE has no way of recording the order
+// of those lists, so export lists fields in property order.
+func sortFieldLists(x any) any {
+	switch x := x.(type) {
+	case map[string]any:
+		for k, v := range x {
+			if list, ok := v.([]any); ok && (k == "required" || k == "nullable") {
+				sort.Slice(list, func(i, j int) bool {
+					return list[i].(string) < list[j].(string)
+				})
+				continue
+			}
+			sortFieldLists(v)
+		}
+	case []any:
+		for _, v := range x {
+			sortFieldLists(v)
+		}
+	}
+	return x
+}
+
+func jsonString(t *testing.T, x any) string {
+	t.Helper()
+	data, err := json.MarshalIndent(x, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExportHandWritten(t *testing.T) {
+	// The CUE in testdata/export is written by hand
+	// in the form described by ExportModule.
+	schemas, err := gen.ExportModule("testdata/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 1 {
+		t.Fatalf("got %d lexicons, want 1", len(schemas))
+	}
+	data, err := os.ReadFile("testdata/export/note.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want any
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	got := jsonValue(t, schemas[0])
+	if !reflect.DeepEqual(sortFieldLists(got), sortFieldLists(want)) {
+		t.Errorf("unexpected result\ngot  %s\nwant %s", jsonString(t, got), jsonString(t, want))
+	}
+}
@@ -0,0 +1,1118 @@
+// Package gen generates CUE from atproto lexicon schemas.
+package gen
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "embed"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/ast/astutil"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/token"
+
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// TODO comments
+// TODO imports/references
+
+//go:embed lexicon.cue
+var lexiconSchemaSource string
+
+// LexicueSource holds the source of the CUE package
+// imported as LexicuePkg by the generated CUE.
+//
+//go:embed lexicue.cue
+var LexicueSource string
+
+// LexicuePkg holds the import path of the CUE package
+// holding the definitions shared by all generated lexicons.
+const LexicuePkg = "cueschemas.org/lexicue"
+
+// LexiconSchema returns the CUE schema that
+// all lexicon documents must conform to.
+func LexiconSchema(ctx *cue.Context) (cue.Value, error) {
+	lexiconTypes := ctx.CompileString(lexiconSchemaSource, cue.Filename("lexicon.cue"))
+	if err := lexiconTypes.Err(); err != nil {
+		return cue.Value{}, fmt.Errorf("cannot compile lexicon schema: %v", err)
+	}
+	lexiconSchema := lexiconTypes.LookupPath(cue.MakePath(cue.Def("#LexiconDoc")))
+	if err := lexiconSchema.Err(); err != nil {
+		return cue.Value{}, err
+	}
+	return lexiconSchema, nil
+}
+
+// Config holds configuration for a Generator.
+type Config struct {
+	// ModuleRoot holds the CUE module path that
+	// generated packages are placed under.
+	ModuleRoot string
+
+	// UseMap causes definitions to be generated as entries in
+	// a #def map in a single package rather than as top level
+	// definitions in a package per lexicon.
+	UseMap bool
+}
+
+// Generator generates CUE from lexicons. Lexicons refer to
+// one another, so the same Generator should be used for
+// all the lexicons in a set.
+type Generator struct {
+	cfg           Config
+	lexiconSchema cue.Value
+	deps          *Dependencies
+
+	// schemas holds all the lexicons generated so far, keyed by ID.
+	schemas map[string]*Schema
+}
+
+// File holds the CUE generated from a single lexicon.
+type File struct {
+	// Path holds the slash-separated path of the file
+	// relative to the module root directory.
+	Path string
+
+	// Package holds the import path of the file's package.
+	Package string
+
+	// Schema holds the lexicon that the file was generated from.
+	Schema *Schema
+
+	// Syntax holds the generated CUE.
+	Syntax *ast.File
+}
+
+// Source returns the formatted CUE source for f.
+func (f *File) Source() ([]byte, error) {
+	data, err := format.Node(f.Syntax)
+	if err != nil {
+		return nil, fmt.Errorf("cannot format source: %v (%v)", err, errors.Details(err, nil))
+	}
+	return data, nil
+}
+
+// New returns a new Generator that uses the given configuration.
+func New(cfg Config) (*Generator, error) {
+	if cfg.ModuleRoot == "" {
+		return nil, fmt.Errorf("no module root specified")
+	}
+	lexiconSchema, err := LexiconSchema(cuecontext.New())
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{
+		cfg:           cfg,
+		lexiconSchema: lexiconSchema,
+		deps:          newDependencies(),
+		schemas:       make(map[string]*Schema),
+	}, nil
+}
+
+// Deps returns the dependencies between the packages
+// generated so far.
+func (g *Generator) Deps() *Dependencies {
+	return g.deps
+}
+
+// GenerateJSON generates CUE from the lexicon JSON in data,
+// checking first that it's a valid lexicon document.
+// The filename is used for error messages.
+func (g *Generator) GenerateJSON(data []byte, filename string) (*File, error) {
+	schema, err := g.ParseJSON(data, filename)
+	if err != nil {
+		return nil, err
+	}
+	return g.Generate(schema)
+}
+
+// ParseJSON parses the lexicon JSON in data, checking
+// that it's a valid lexicon document. The filename is
+// used for error messages.
+func (g *Generator) ParseJSON(data []byte, filename string) (*Schema, error) {
+	return parseJSON(data, filename, g.lexiconSchema)
+}
+
+// Generate generates CUE from the given lexicon schema,
+// which is assumed to be valid.
+func (g *Generator) Generate(schema *Schema) (*File, error) {
+	pkg, err := g.lexiconPkg(schema.ID)
+	if err != nil {
+		return nil, err
+	}
+	g.schemas[schema.ID] = schema
+	return g.newGenerator(schema.ID, pkg, g.cfg.UseMap).generate(schema)
+}
+
+func (g *Generator) newGenerator(id, pkg string, useMap bool) *generator {
+	return &generator{
+		Generator:    g,
+		id:           id,
+		pkg:          pkg,
+		useMap:       useMap,
+		importsByPkg: make(map[string]*ast.Ident),
+	}
+}
+
+// lexiconPkg returns the import path of the package
+// generated for the lexicon with the given ID.
+func (g *Generator) lexiconPkg(id string) (string, error) {
+	if g.cfg.UseMap {
+		return g.cfg.ModuleRoot, nil
+	}
+	return g.id2Pkg(id)
+}
+
+func (g *generator) generate(schema *Schema) (*File, error) {
+	astf := &ast.File{
+		Decls: []ast.Decl{
+			&ast.Package{
+				Name: ast.NewIdent(impliedImportIdent(g.pkg)),
+			},
+		},
+	}
+	defs := &ast.StructLit{}
+
+	// Process main first, so it appears at the top.
+	for name, t := range schema.Defs {
+		if name != "main" {
+			continue
+		}
+		g.currentDef = "#main"
+		e, err := g.cueForDefinition(t, name)
+		if err != nil {
+			return nil, schemaError(schema, atPath(err, "defs", name))
+		}
+		if g.useMap {
+			addField(defs, g.id+g.currentDef, regular, e, t.Description)
+			continue
+		}
+		// Main description becomes package doc comment.
+		setDescription(astf.Decls[0], t.Description)
+
+		if _, ok := e.(*ast.StructLit); ok {
+			// It's a definition, so close it up by defining it in _#def first,
+			// and then embedding that.
+			astf.Decls = append(astf.Decls,
+				&ast.Field{
+					Label: ast.NewIdent("_#def"),
+					Value: e,
+				},
+				&ast.EmbedDecl{
+					Expr: ast.NewIdent("_#def"),
+				},
+			)
+		} else {
+			astf.Decls = append(astf.Decls, &ast.EmbedDecl{
+				Expr: e,
+			})
+		}
+	}
+
+	for _, name := range sorted.Keys(schema.Defs) {
+		if name == "main" {
+			// Already processed.
+			continue
+		}
+		t := schema.Defs[name]
+		g.currentDef = "#" + name
+		e, err := g.cueForType(t, true)
+		if err != nil {
+			return nil, schemaError(schema, atPath(err, "defs", name))
+		}
+		if g.useMap {
+			addField(defs, g.id+"#"+name, regular, e, t.Description)
+		} else {
+			astf.Decls = append(astf.Decls, &ast.Field{
+				Label: ast.NewIdent(g.currentDef),
+				Value: e,
+			})
+			setDescription(astf.Decls[len(astf.Decls)-1], t.Description)
+		}
+	}
+	if g.useMap && len(defs.Elts) > 0 {
+		astf.Decls = append(astf.Decls, &ast.Field{
+			Label: ast.NewIdent("#def"),
+			Value: defs,
+		})
+	}
+	if err := astutil.Sanitize(astf); err != nil {
+		return nil, fmt.Errorf("cannot sanitize %q: %v", g.id, err)
+	}
+	return &File{
+		Path:    g.filePath(),
+		Package: g.pkg,
+		Schema:  schema,
+		Syntax:  astf,
+	}, nil
+}
+
+// filePath returns the path of the generated file
+// relative to the module root directory.
+func (g *generator) filePath() string {
+	dir := strings.TrimPrefix(g.pkg, g.cfg.ModuleRoot+"/")
+	if g.useMap {
+		return fmt.Sprintf("%s/%s.cue", dir, g.id)
+	}
+	return fmt.Sprintf("%s/defs.cue", dir)
+}
+
+// generator holds the state for generating
+// the CUE for a single lexicon.
+type generator struct {
+	*Generator
+	pkg          string
+	currentDef   string
+	id           string
+	importsByPkg map[string]*ast.Ident
+
+	// useMap holds whether definitions are generated as
+	// entries in a #def map.
+	useMap bool
+
+	// shared holds the IDs of the other lexicons whose definitions
+	// are generated into the same #def map as this one,
+	// when generating a shared package for an import cycle.
+	shared map[string]bool
+}
+
+func (g *generator) cueForDefinition(t *TypeSchema, defName string) (ast.Expr, error) {
+	switch t.Type {
+	case "query":
+		e := &ast.StructLit{}
+		if err := g.addXRPCBodyField(e, "output", t.Output); err != nil {
+			return nil, err
+		}
+		if t.Parameters != nil {
+			parametersExpr, err := g.cueForType(t.Parameters, false)
+			if err != nil {
+				return nil, atPath(err, "parameters")
+			}
+			addField(e, "parameters", required, parametersExpr, t.Parameters.Description)
+		}
+		addErrorsField(e, t.Errors)
+		return g.lexiconValue("query", e), nil
+	case "procedure":
+		e := &ast.StructLit{}
+		if err := g.addXRPCBodyField(e, "input", t.Input); err != nil {
+			return nil, err
+		}
+		if err := g.addXRPCBodyField(e, "output", t.Output); err != nil {
+			return nil, err
+		}
+		addErrorsField(e, t.Errors)
+		return g.lexiconValue("procedure", e), nil
+	case "record":
+		e := &ast.StructLit{}
+		if t.Key != "" {
+			addField(e, "key", regular, stringLit(t.Key), "")
+		}
+		record, err := g.cueForType(t.Record, false)
+		if err != nil {
+			return nil, atPath(err, "record")
+		}
+		if lit, ok := record.(*ast.StructLit); ok {
+			// Records are always tagged with their type.
+			typeField := &ast.Field{
+				Label:      ast.NewIdent("$type"),
+				Constraint: required,
+				Value:      stringLit(g.id),
+			}
+			lit.Elts = append([]ast.Decl{typeField}, lit.Elts...)
+		}
+		addField(e, "record", required, record, t.Record.Description)
+		return g.lexiconValue("record", e), nil
+	case "subscription":
+		e := &ast.StructLit{}
+		params, err := g.cueForType(t.Parameters, false)
+		if err != nil {
+			return nil, atPath(err, "parameters")
+		}
+		addField(e, "parameters", required, params, t.Parameters.Description)
+		if t.Message != nil {
+			var schema ast.Expr
+			if t.Message.Schema.Type == "union" {
+				// Subscription messages carry their type in the
+				// frame header rather than in a $type field,
+				// so there's nothing to discriminate on.
+				schema, err = g.unionExpr(t.Message.Schema, false)
+			} else {
+				schema, err = g.cueForType(t.Message.Schema, false)
+			}
+			if err != nil {
+				return nil, atPath(err, "message", "schema")
+			}
+			addField(e, "message", required, &ast.StructLit{
+				Elts: []ast.Decl{
+					&ast.Field{
+						Label: ast.NewIdent("schema"),
+						Value: schema,
+					},
+				},
+			}, t.Message.Schema.Description)
+		}
+		addErrorsField(e, t.Errors)
+		return g.lexiconValue("subscription", e), nil
+	case "image":
+		e := &ast.StructLit{}
+		addMaxConstraint(e, t.MaxWidth, "width")
+		addMaxConstraint(e, t.MaxHeight, "height")
+		addMaxConstraint(e, t.MaxSize, "size")
+		return g.lexiconValue("image", e), nil
+	case "video":
+		e := &ast.StructLit{}
+		addMaxConstraint(e, t.MaxWidth, "width")
+		addMaxConstraint(e, t.MaxHeight, "height")
+		addMaxConstraint(e, t.MaxLength, "length")
+		addMaxConstraint(e, t.MaxSize, "size")
+		return g.lexiconValue("video", e), nil
+	case "audio":
+		e := &ast.StructLit{}
+		addMaxConstraint(e, t.MaxLength, "length")
+		addMaxConstraint(e, t.MaxSize, "size")
+		return g.lexiconValue("audio", e), nil
+	default:
+		e, err := g.cueForType(t, true)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+}
+
+func (g *generator) addXRPCBodyField(lit *ast.StructLit, fieldName string, body *BodyType) error {
+	if body == nil {
+		return nil
+	}
+	e, err := g.cueForXRPCBody(body)
+	if err != nil {
+		return atPath(err, fieldName)
+	}
+	addField(lit, fieldName, regular, e, "")
+	return nil
+}
+
+// addErrorsField adds the errors declared by an XRPC endpoint.
+func addErrorsField(lit *ast.StructLit, errs []Error) {
+	if len(errs) == 0 {
+		return
+	}
+	list := &ast.ListLit{}
+	for _, xerr := range errs {
+		e := &ast.StructLit{}
+		addField(e, "name", regular, stringLit(xerr.Name), "")
+		if xerr.Description != "" {
+			addField(e, "description", regular, stringLit(xerr.Description), "")
+		}
+		list.Elts = append(list.Elts, e)
+	}
+	addField(lit, "errors", regular, list, "")
+}
+
+func (g *generator) cueForXRPCBody(body *BodyType) (ast.Expr, error) {
+	e := &ast.StructLit{
+		Elts: []ast.Decl{
+			&ast.Field{
+				Label: ast.NewIdent("encoding"),
+				Value: stringLit(body.Encoding),
+			},
+		},
+	}
+	if body.Schema != nil {
+		schemaExpr, err := g.cueForType(body.Schema, false)
+		if err != nil {
+			return nil, atPath(err, "schema")
+		}
+		addField(e, "schema", regular, schemaExpr, body.Schema.Description)
+	}
+	return e, nil
+}
+
+func (g *generator) cueForType(t *TypeSchema, topLevel bool) (ast.Expr, error) {
+	switch t.Type {
+	case "token":
+		if !topLevel {
+			return nil, atPath(fmt.Errorf("token not defined at top level"), "type")
+		}
+		return g.lexiconValue("token", stringLit(g.typeName(g.currentDef))), nil
+	case "ref":
+		e, err := g.refExpr(t.Ref)
+		if err != nil {
+			return nil, atPath(err, "ref")
+		}
+		return e, nil
+	case "union":
+		return g.unionExpr(t, true)
+	case "object", "params":
+		// TODO what's the difference between params and object?
+		lit := &ast.StructLit{}
+		if t.Type == "object" && topLevel {
+			// Any object may be tagged with its type, and
+			// it must be when it's a member of a union.
+			addField(lit, "$type", optional, stringLit(g.typeName(g.currentDef)), "")
+		}
+		required := make(map[string]bool)
+		for _, field := range t.Required {
+			required[field] = true
+		}
+		nullable := make(map[string]bool)
+		for _, field := range t.Nullable {
+			nullable[field] = true
+		}
+		for _, name := range sorted.Keys(t.Properties) {
+			pt := t.Properties[name]
+			e, err := g.cueForType(pt, false)
+			if err != nil {
+				return nil, atPath(err, "properties", name)
+			}
+			if nullable[name] {
+				e = &ast.BinaryExpr{
+					X:  e,
+					Op: token.OR,
+					Y:  ast.NewIdent("null"),
+				}
+			}
+			f := &ast.Field{
+				Label: ast.NewIdent(name),
+				Value: e,
+			}
+			if required[name] {
+				f.Constraint = token.NOT
+			} else {
+				f.Constraint = token.OPTION
+			}
+			setDescription(f, pt.Description)
+			lit.Elts = append(lit.Elts, f)
+		}
+		return lit, nil
+	case "blob":
+		e := &ast.StructLit{}
+		addMaxConstraint(e, t.MaxSize, "size")
+		addMimeType(e, t.Accept)
+		return g.lexiconValue("blob", e), nil
+	case "cid-link":
+		return g.lexiconValue("cidLink", nil), nil
+	case "array":
+		itemType, err := g.cueForType(t.Items, false)
+		if err != nil {
+			return nil, atPath(err, "items")
+		}
+		var e ast.Expr = &ast.ListLit{
+			Elts: []ast.Expr{
+				&ast.Ellipsis{
+					Type: itemType,
+				},
+			},
+		}
+		if t.MinLength != nil {
+			e = and(e, &ast.CallExpr{
+				Fun: g.externalRef("list", "MinItems"),
+				Args: []ast.Expr{
+					&ast.BasicLit{
+						Kind:  token.INT,
+						Value: fmt.Sprint(*t.MinLength),
+					},
+				},
+			})
+		}
+		if t.MaxLength != nil {
+			e = and(e, &ast.CallExpr{
+				Fun: g.externalRef("list", "MaxItems"),
+				Args: []ast.Expr{
+					&ast.BasicLit{
+						Kind:  token.INT,
+						Value: fmt.Sprint(*t.MaxLength),
+					},
+				},
+			})
+		}
+		return e, nil
+	case "boolean":
+		if constVal, ok := t.Const.(bool); ok {
+			return ast.NewIdent(fmt.Sprint(constVal)), nil
+		}
+		var e ast.Expr = ast.NewIdent("bool")
+		if defaultVal, ok := t.Default.(bool); ok {
+			e = withDefault(e, ast.NewIdent(fmt.Sprint(defaultVal)))
+		}
+		return e, nil
+	case "number", "integer":
+		isInt := t.Type == "integer"
+		if t.Const != nil {
+			return numericLit(t.Const, isInt), nil
+		}
+		var e ast.Expr
+		if t.Enum != nil {
+			if len(t.Enum) == 0 {
+				return nil, atPath(fmt.Errorf("empty enum"), "enum")
+			}
+			e = numericLit(t.Enum[0], isInt)
+			for _, v := range t.Enum[1:] {
+				e = or(e, numericLit(v, isInt))
+			}
+		} else {
+			if isInt {
+				e = ast.NewIdent("int")
+			} else {
+				e = ast.NewIdent("number")
+			}
+		}
+		if t.Minimum != nil {
+			e = and(e, &ast.UnaryExpr{
+				Op: token.GEQ,
+				X:  numericLit(t.Minimum, isInt),
+			})
+		}
+		if t.Maximum != nil {
+			e = and(e, &ast.UnaryExpr{
+				Op: token.LEQ,
+				X:  numericLit(t.Maximum, isInt),
+			})
+		}
+		if t.Default != nil {
+			e = withDefault(e, numericLit(t.Default, isInt))
+		}
+		return e, nil
+	case "string":
+		if t.Const != nil {
+			return stringLit(t.Const.(string)), nil
+		}
+		var e ast.Expr
+		if t.Enum != nil {
+			if len(t.Enum) == 0 {
+				return nil, atPath(fmt.Errorf("empty enum"), "enum")
+			}
+			e = stringLit(t.Enum[0].(string))
+			for _, v := range t.Enum[1:] {
+				e = or(e, stringLit(v.(string)))
+			}
+		} else {
+			e = ast.NewIdent("string")
+		}
+		if t.Format != "" {
+			def, ok := StringFormats[t.Format]
+			if !ok {
+				return nil, atPath(fmt.Errorf("unknown string format %q", t.Format), "format")
+			}
+			formatExpr := g.externalRef(LexicuePkg, def)
+			if t.Enum != nil {
+				e = and(e, formatExpr)
+			} else {
+				e = formatExpr
+			}
+		}
+		// The lexicon lengths are in bytes and graphemes, neither of which
+		// CUE has a builtin validator for, and known values don't constrain
+		// the string at all, so we use the lexicue #string definition
+		// for those.
+		lit := &ast.StructLit{}
+		addLengthConstraint(lit, t.MinLength, "#minLength")
+		addLengthConstraint(lit, t.MaxLength, "#maxLength")
+		addLengthConstraint(lit, t.MaxGraphemes, "#maxGraphemes")
+		if len(t.KnownValues) > 0 {
+			addField(lit, "#knownValues", regular, g.knownValuesExpr(t.KnownValues), "")
+		}
+		if len(lit.Elts) > 0 {
+			lit.Elts = append([]ast.Decl{
+				&ast.EmbedDecl{
+					Expr: g.externalRef(LexicuePkg, "#string"),
+				},
+			}, lit.Elts...)
+			if ident, ok := e.(*ast.Ident); ok && ident.Name == "string" {
+				e = lit
+			} else {
+				e = and(e, lit)
+			}
+		}
+		if t.Default != nil {
+			e = withDefault(e, stringLit(t.Default.(string)))
+		}
+		return e, nil
+	case "bytes":
+		// TODO MaxLength
+		return ast.NewIdent("bytes"), nil
+	case "unknown":
+		return ast.NewIdent("_"), nil
+	default:
+		return nil, atPath(fmt.Errorf("unknown type %q", t.Type), "type")
+	}
+}
+
+// knownValuesExpr returns a list of the given known values.
+// Values that name a token (for example "app.bsky.feed.defs#requestLess")
+// are emitted as string literals like any other: known values don't
+// restrict the string, so there's no need to import the lexicon
+// defining the token. A token in the same lexicon (for example "#requestLess")
+// is qualified with the lexicon's ID, because that's the token's value.
+func (g *generator) knownValuesExpr(values []string) ast.Expr {
+	lit := &ast.ListLit{}
+	for _, v := range values {
+		if strings.HasPrefix(v, "#") && isTokenRef(v) {
+			v = g.id + v
+		}
+		lit.Elts = append(lit.Elts, stringLit(v))
+	}
+	return lit
+}
+
+// StringFormats maps from lexicon string format to the
+// name of the definition in the lexicue package that checks it.
+var StringFormats = map[string]string{
+	"at-identifier": "#atIdentifier",
+	"at-uri":        "#atURI",
+	"cid":           "#cid",
+	"datetime":      "#datetime",
+	"did":           "#did",
+	"handle":        "#handle",
+	"language":      "#language",
+	"nsid":          "#nsid",
+	"record-key":    "#recordKey",
+	"tid":           "#tid",
+	"uri":           "#uri",
+}
+
+// unionExpr returns the disjunction of the members of the
+// given union. If tagged is true, each member is required to have
+// a $type field holding its type name, which allows CUE
+// to discriminate between structurally similar members,
+// and unless the union is closed, objects of any other type are allowed too.
+func (g *generator) unionExpr(t *TypeSchema, tagged bool) (ast.Expr, error) {
+	if len(t.Refs) == 0 {
+		return nil, atPath(fmt.Errorf("no elements in union"), "refs")
+	}
+	var e ast.Expr
+	for i, r := range t.Refs {
+		e1, err := g.refExpr(r)
+		if err != nil {
+			return nil, atPath(err, "refs", fmt.Sprint(i))
+		}
+		if tagged {
+			e1 = and(e1, &ast.StructLit{
+				Elts: []ast.Decl{
+					&ast.Field{
+						Label:      ast.NewIdent("$type"),
+						Constraint: required,
+						Value:      stringLit(g.refTypeName(r)),
+					},
+				},
+			})
+		}
+		if e == nil {
+			e = e1
+			continue
+		}
+		e = or(e, e1)
+	}
+	if tagged && !t.Closed {
+		// Unions are open by default, so allow any object
+		// with a type that isn't one of those listed.
+		var notListed ast.Expr
+		for _, r := range t.Refs {
+			ne := &ast.UnaryExpr{
+				Op: token.NEQ,
+				X:  stringLit(g.refTypeName(r)),
+			}
+			if notListed == nil {
+				notListed = ne
+			} else {
+				notListed = and(notListed, ne)
+			}
+		}
+		e = or(e, and(g.externalRef(LexicuePkg, "#unknownObject"), &ast.StructLit{
+			Elts: []ast.Decl{
+				&ast.Field{
+					Label:      ast.NewIdent("$type"),
+					Constraint: required,
+					Value:      notListed,
+				},
+			},
+		}))
+	}
+	return e, nil
+}
+
+// typeName returns the fully qualified name of the given
+// definition (for example "#foo") in the current lexicon,
+// as used for $type fields and tokens.
+func (g *generator) typeName(def string) string {
+	if def == "#main" {
+		return g.id
+	}
+	return g.id + def
+}
+
+// refTypeName returns the fully qualified type name
+// of the target of the given reference.
+func (g *generator) refTypeName(ref string) string {
+	return TypeName(SplitRef(g.id, ref))
+}
+
+// SplitRef returns the lexicon ID and definition name that ref refers to
+// when it appears in the lexicon with ID fromID. The definition name
+// is "main" when ref does not specify one.
+func SplitRef(fromID, ref string) (id, def string) {
+	id, def, _ = strings.Cut(ref, "#")
+	if id == "" {
+		id = fromID
+	}
+	if def == "" {
+		def = "main"
+	}
+	return id, def
+}
+
+// ResolveRef returns the lexicon ID, definition name and
+// definition that ref refers to from the lexicon with the
+// given ID. The schemas map is keyed by lexicon ID.
+func ResolveRef(schemas map[string]*Schema, fromID, ref string) (id, def string, t *TypeSchema, err error) {
+	id, def = SplitRef(fromID, ref)
+	schema := schemas[id]
+	if schema == nil {
+		return "", "", nil, fmt.Errorf("reference to unknown lexicon %q", id)
+	}
+	t = schema.Defs[def]
+	if t == nil {
+		return "", "", nil, fmt.Errorf("reference to unknown definition %q", ref)
+	}
+	return id, def, t, nil
+}
+
+// TypeName returns the fully qualified name of the given definition
+// in the lexicon with the given ID, as used in $type fields.
+// Main definitions are named by the lexicon ID alone.
+func TypeName(id, def string) string {
+	if def == "main" {
+		return id
+	}
+	return id + "#" + def
+}
+
+func (g *generator) lexiconValue(kind string, of ast.Expr) ast.Expr {
+	def := g.externalRef(LexicuePkg, kind)
+	if of == nil {
+		return def
+	}
+	if slit, ok := of.(*ast.StructLit); ok && len(slit.Elts) == 0 {
+		return def
+	}
+	return &ast.BinaryExpr{
+		X:  def,
+		Op: token.AND,
+		Y:  of,
+	}
+}
+
+func (g *generator) refExpr(name string) (ast.Expr, error) {
+	if g.useMap && g.inDefMap(name) {
+		if strings.HasPrefix(name, "#") {
+			name = g.id + name
+		} else if !strings.Contains(name, "#") {
+			// The map is keyed by the full name, including #main.
+			name += "#main"
+		}
+		return &ast.IndexExpr{
+			X:     ast.NewIdent("#def"),
+			Index: stringLit(name),
+		}, nil
+	}
+	path, def, ok := strings.Cut(name, "#")
+	if ok && path == "" {
+		// Local reference.
+		return ast.NewIdent("#" + def), nil
+	}
+	pkg, err := g.id2Pkg(path)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == g.pkg {
+		return ast.NewIdent("#" + def), nil
+	}
+	ref := ""
+	if ok {
+		ref = "#" + def
+	}
+	return g.externalRef(pkg, ref), nil
+}
+
+// inDefMap reports whether the definition with the given name
+// is an entry in the same #def map as the definitions being generated.
+func (g *generator) inDefMap(name string) bool {
+	id, _, _ := strings.Cut(name, "#")
+	return g.cfg.UseMap || id == "" || id == g.id || g.shared[id]
+}
+
+func (g *generator) externalRef(pkg string, ident string) ast.Expr {
+	a := Arc{g.pkg, pkg}
+	m := g.deps.Arcs[a]
+	if m == nil {
+		m = make(map[Arc]bool)
+		g.deps.Arcs[a] = m
+	}
+	m[Arc{g.currentDef, ident}] = true
+	if ident == "" {
+		return g.addImport(pkg)
+	}
+	return &ast.SelectorExpr{
+		X:   g.addImport(pkg),
+		Sel: ast.NewIdent(ident),
+	}
+}
+
+func (g *generator) addImport(pkg string) *ast.Ident {
+	if ident := g.importsByPkg[pkg]; ident != nil {
+		return ident
+	}
+	id := impliedImportIdent(pkg)
+	ispec := &ast.ImportSpec{
+		Path: stringLit(pkg),
+	}
+	if id == "defs" {
+		// defs is commonly used and meaningless, so try
+		// for a more informative identifier.
+		id1 := path.Base(path.Dir(pkg))
+		id1 = strings.ReplaceAll(id1, ".", "_")
+		if id1 != "" && ast.IsValidIdent(id1) {
+			ispec.Name = ast.NewIdent(id1)
+			id = id1
+		}
+	}
+	ident := &ast.Ident{
+		Name: id,
+		Node: ispec,
+	}
+	g.importsByPkg[pkg] = ident
+	return ident
+}
+
+func addMimeType(e *ast.StructLit, accept []string) {
+	if len(accept) == 0 {
+		return
+	}
+	var v ast.Expr
+	for _, s := range accept {
+		var elt ast.Expr
+
+		if strings.HasSuffix(s, "/*") {
+			// TODO what's the general matching pattern syntax here?
+			elt = &ast.UnaryExpr{
+				Op: token.MAT,
+				X:  stringLit("^" + regexp.QuoteMeta(strings.TrimSuffix(s, "*"))),
+			}
+		} else {
+			elt = stringLit(s)
+		}
+		if v == nil {
+			v = elt
+		} else {
+			v = or(v, elt)
+		}
+	}
+	addField(e, "mimeType", required, v, "")
+}
+
+func addMaxConstraint(e *ast.StructLit, n *int, fieldName string) {
+	if n == nil {
+		return
+	}
+	addField(e, fieldName, required, &ast.UnaryExpr{
+		Op: token.LEQ,
+		X:  numericLit(*n, false),
+	}, "")
+}
+
+func addLengthConstraint(e *ast.StructLit, n *int, fieldName string) {
+	if n == nil {
+		return
+	}
+	addField(e, fieldName, regular, numericLit(*n, true), "")
+}
+
+func impliedImportIdent(pkgPath string) string {
+	return path.Base(pkgPath)
+}
+
+func withDefault(e ast.Expr, defaultVal ast.Expr) ast.Expr {
+	return or(
+		&ast.UnaryExpr{
+			Op: token.MUL,
+			X:  defaultVal,
+		},
+		e,
+	)
+}
+
+func numericLit(val any, isInt bool) ast.Expr {
+	if isInt {
+		return &ast.BasicLit{
+			Kind:  token.INT,
+			Value: fmt.Sprint(val),
+		}
+	}
+	return &ast.BasicLit{
+		Kind: token.FLOAT,
+		// TODO is this right?
+		Value: fmt.Sprint(val),
+	}
+}
+
+func stringLit(s string) *ast.BasicLit {
+	// TODO choose appropriate kind of string literal depending on content.
+	return &ast.BasicLit{
+		Kind:  token.STRING,
+		Value: strconv.Quote(s),
+	}
+}
+
+func setDescription(n ast.Node, desc string) {
+	if desc == "" {
+		return
+	}
+	ast.SetComments(n, []*ast.CommentGroup{{
+		Doc: true,
+		List: []*ast.Comment{{
+			Text: "// " + desc,
+		}},
+	}})
+}
+
+func (g *Generator) id2Pkg(p string) (string, error) {
+	return PackagePath(g.cfg.ModuleRoot, p)
+}
+
+// PackagePath returns the path of the package generated for
+// the lexicon with the given ID, under the given root,
+// which may be empty.
+// For example, with root "lexicon.me", the package
+// for "app.bsky.feed.post" is "lexicon.me/feed.bsky.app/post".
+func PackagePath(root, id string) (string, error) {
+	parts := strings.Split(id, ".")
+	if len(parts) < 3 {
+		return "", fmt.Errorf("not enough elements in path %q", id)
+	}
+	var buf strings.Builder
+	if root != "" {
+		buf.WriteString(root + "/")
+	}
+	for i := len(parts) - 2; i >= 0; i-- {
+		if i < len(parts)-2 {
+			buf.WriteByte('.')
+		}
+		buf.WriteString(parts[i])
+	}
+	fmt.Fprintf(&buf, "/%s", parts[len(parts)-1])
+	return buf.String(), nil
+}
+
+func and(x, y ast.Expr) ast.Expr {
+	return &ast.BinaryExpr{
+		X:  x,
+		Op: token.AND,
+		Y:  y,
+	}
+}
+
+func or(x, y ast.Expr) ast.Expr {
+	return &ast.BinaryExpr{
+		X:  x,
+		Op: token.OR,
+		Y:  y,
+	}
+}
+
+type constraint = token.Token
+
+const (
+	regular  = token.ILLEGAL
+	optional = token.OPTION
+	required = token.NOT
+)
+
+func addField(lit *ast.StructLit, fieldName string, kind constraint, e ast.Expr, description string) {
+	var label ast.Label
+	if ast.IsValidIdent(fieldName) {
+		label = ast.NewIdent(fieldName)
+	} else {
+		label = stringLit(fieldName)
+	}
+	f := &ast.Field{
+		Label:      label,
+		Constraint: kind,
+		Value:      e,
+	}
+	setDescription(f, description)
+	lit.Elts = append(lit.Elts, f)
+}
+
+// pathError is an error found at a path
+// within a lexicon definition.
+type pathError struct {
+	path []string
+	err  error
+}
+
+func (e *pathError) Error() string {
+	return strings.Join(e.path, ".") + ": " + e.err.Error()
+}
+
+// atPath returns err annotated with the path of the value it
+// was found in, prepending elems to any path it already has.
+func atPath(err error, elems ...string) error {
+	if e, ok := err.(*pathError); ok {
+		return &pathError{
+			path: append(elems[:len(elems):len(elems)], e.path...),
+			err:  e.err,
+		}
+	}
+	return &pathError{
+		path: elems,
+		err:  err,
+	}
+}
+
+// schemaError returns err, which was found in the given
+// lexicon, as a SchemaError.
+func schemaError(schema *Schema, err error) *SchemaError {
+	e := &SchemaError{
+		Msg: err.Error(),
+	}
+	if pe, ok := err.(*pathError); ok {
+		e.Path = pe.path
+		e.Msg = pe.err.Error()
+	}
+	e.Pos = schema.Pos(e.Path...)
+	return e
+}
+
+// ValidateJSON checks that the JSON in data conforms to schema.
+// The filename is used for error messages.
+//
+// We'd use cuelang.org/go/encoding/json except for https://github.com/cue-lang/cue/issues/2395
+func ValidateJSON(data []byte, filename string, schema cue.Value) error {
+	v := schema.Context().CompileBytes(data, cue.Filename(filename))
+	v = v.Unify(schema)
+	return v.Validate(cue.Concrete(true))
+}
+
+func rev[T any](xs []T) []T {
+	r := make([]T, 0, len(xs))
+	for i := len(xs) - 1; i >= 0; i-- {
+		r = append(r, xs[i])
+	}
+	return r
+}
+
+func source(n ast.Node) string {
+	data, err := format.Node(n)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
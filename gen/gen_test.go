@@ -0,0 +1,253 @@
+package gen_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+// loadLexicons generates CUE for the given lexicon JSON documents
+// using the single-package layout and loads the result.
+func loadLexicons(t *testing.T, ctx *cue.Context, lexicons ...string) *gen.Lexicons {
+	t.Helper()
+	g, err := gen.New(gen.Config{
+		ModuleRoot: "lexicon.me/defs",
+		UseMap:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*gen.File
+	for i, lex := range lexicons {
+		f, err := g.GenerateJSON([]byte(lex), fmt.Sprintf("lexicon%d.json", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	l, err := g.Load(ctx, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// checkInstance checks whether the JSON instance is valid
+// against the given definition or definition part.
+func checkInstance(t *testing.T, ctx *cue.Context, l *gen.Lexicons, name, part, instance string, wantValid bool) {
+	t.Helper()
+	var schema cue.Value
+	var err error
+	if part == "" {
+		schema, err = l.Def(name)
+	} else {
+		schema, err = l.Part(name, part)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gen.ValidateInstance(ctx, schema, []byte(instance), "instance.json")
+	switch {
+	case wantValid && err != nil:
+		t.Errorf("%s: unexpected error for %s: %v", name, instance, err)
+	case !wantValid && err == nil:
+		t.Errorf("%s: unexpected success for %s", name, instance)
+	}
+}
+
+var graphemeTests = []struct {
+	testName  string
+	s         string
+	graphemes int
+}{
+	{"ASCII", "abc", 3},
+	{"CRLF", "a\r\nb", 3},
+	{"CombiningMarks", "e\u0301e\u0323\u0301", 2},
+	{"Flags", "\U0001F1EC\U0001F1E7\U0001F1EB\U0001F1F7", 2},
+	{"SkinTone", "\U0001F44D\U0001F3FD", 1},
+	{"ZWJFamily", "\U0001F468\u200D\U0001F469\u200D\U0001F467\u200D\U0001F466", 1},
+	{"ZWJWithVariationSelector", "\u2764\uFE0F\u200D\U0001F525x", 2},
+	{"TagSequence", "\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", 1},
+	{"HangulPrecomposed", "\uD55C\uAD6D\uC5B4", 3},
+	{"HangulJamo", "\u1112\u1161\u11AB\u1100\u116E\u11A8", 2},
+	{"HangulLVPlusT", "\uAC00\u11A8", 1},
+	{"HangulLVTPlusT", "\uAC01\u11A8\u11A8", 1},
+	{"HangulLeadingOnly", "\u1100\u1100", 1},
+	{"HangulLPlusLV", "\u1100\uAC00\u1161", 1},
+	{"HangulTrailingOnly", "\u11A8\u11A8a", 2},
+	{"Prepend", "\u0600\u0661\u0662", 2},
+	{"PrependBeforeControl", "\u0600\n", 2},
+}
+
+func TestMaxGraphemes(t *testing.T) {
+	// Each property allows one more grapheme than the last.
+	props := make(map[string]any)
+	for i := 0; i <= 4; i++ {
+		props[fmt.Sprint("max", i)] = map[string]any{
+			"type":         "string",
+			"maxGraphemes": i,
+		}
+	}
+	lex, err := json.Marshal(map[string]any{
+		"lexicon": 1,
+		"id":      "app.test.graphemes",
+		"defs": map[string]any{
+			"main": map[string]any{
+				"type":       "object",
+				"properties": props,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := cuecontext.New()
+	l := loadLexicons(t, ctx, string(lex))
+	for _, test := range graphemeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			instance := func(max int) string {
+				data, _ := json.Marshal(map[string]string{fmt.Sprint("max", max): test.s})
+				return string(data)
+			}
+			checkInstance(t, ctx, l, "app.test.graphemes", "", instance(test.graphemes), true)
+			checkInstance(t, ctx, l, "app.test.graphemes", "", instance(test.graphemes-1), false)
+		})
+	}
+}
+
+func TestKnownValuesAreStrings(t *testing.T) {
+	ctx := cuecontext.New()
+	// The known value names a token in a lexicon that isn't
+	// loaded, which must not stop the lexicon from loading.
+	l := loadLexicons(t, ctx, `{
+		"lexicon": 1,
+		"id": "app.test.known",
+		"defs": {
+			"main": {
+				"type": "object",
+				"properties": {
+					"s": {
+						"type": "string",
+						"knownValues": ["app.test.other#tok", "#local", "plain"]
+					}
+				}
+			}
+		}
+	}`)
+	for _, s := range []string{"app.test.other#tok", "plain", "something else"} {
+		checkInstance(t, ctx, l, "app.test.known", "", fmt.Sprintf(`{"s": %q}`, s), true)
+	}
+}
+
+func TestKnownValuesQualifyLocalTokens(t *testing.T) {
+	ctx := cuecontext.New()
+	l := loadLexicons(t, ctx, `{
+		"lexicon": 1,
+		"id": "app.test.known",
+		"defs": {
+			"main": {
+				"type": "object",
+				"properties": {
+					"s": {
+						"type": "string",
+						"knownValues": ["#local", "app.test.other#tok", "plain"]
+					}
+				}
+			},
+			"local": {"type": "token"}
+		}
+	}`)
+	v, err := l.Def("app.test.known")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := v.LookupPath(cue.MakePath(cue.Str("s").Optional(), cue.Def("#knownValues"))).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	// The value of a token is its fully qualified name.
+	want := []string{"app.test.known#local", "app.test.other#tok", "plain"}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected known values\ngot  %q\nwant %q", got, want)
+	}
+}
+
+const unionLexicon = `{
+	"lexicon": 1,
+	"id": "app.test.union",
+	"defs": {
+		"main": {
+			"type": "object",
+			"properties": {
+				"open": {
+					"type": "union",
+					"refs": ["#image", "#link"]
+				},
+				"closed": {
+					"type": "union",
+					"refs": ["#image", "#link"],
+					"closed": true
+				}
+			}
+		},
+		"image": {
+			"type": "object",
+			"required": ["alt"],
+			"properties": {
+				"alt": {"type": "string"}
+			}
+		},
+		"link": {
+			"type": "object",
+			"required": ["uri"],
+			"properties": {
+				"uri": {"type": "string"}
+			}
+		}
+	}
+}`
+
+var unionTests = []struct {
+	testName    string
+	member      string
+	openValid   bool
+	closedValid bool
+}{{
+	testName:    "MemberWithType",
+	member:      `{"$type": "app.test.union#image", "alt": "a cat"}`,
+	openValid:   true,
+	closedValid: true,
+}, {
+	testName: "MemberWithoutType",
+	member:   `{"alt": "a cat"}`,
+}, {
+	testName: "MemberWithWrongType",
+	member:   `{"$type": "app.test.union#link", "alt": "a cat"}`,
+}, {
+	testName: "InvalidMember",
+	member:   `{"$type": "app.test.union#image", "alt": 1}`,
+}, {
+	testName:  "UnknownType",
+	member:    `{"$type": "app.test.other#thing", "anything": true}`,
+	openValid: true,
+}, {
+	testName: "NotAnObject",
+	member:   `"app.test.union#image"`,
+}}
+
+func TestUnions(t *testing.T) {
+	ctx := cuecontext.New()
+	l := loadLexicons(t, ctx, unionLexicon)
+	for _, test := range unionTests {
+		t.Run(test.testName, func(t *testing.T) {
+			checkInstance(t, ctx, l, "app.test.union", "", `{"open": `+test.member+`}`, test.openValid)
+			checkInstance(t, ctx, l, "app.test.union", "", `{"closed": `+test.member+`}`, test.closedValid)
+		})
+	}
+}
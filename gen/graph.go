@@ -0,0 +1,171 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// GraphLevel specifies the granularity of a dependency graph.
+type GraphLevel int
+
+const (
+	// PackageLevel graphs have a node for each package.
+	PackageLevel GraphLevel = iota
+
+	// DefinitionLevel graphs have a node for each definition
+	// that refers to or is referred to from another package.
+	DefinitionLevel
+)
+
+// Graph holds a dependency graph derived from Dependencies.
+type Graph struct {
+	Level GraphLevel `json:"-"`
+	Nodes []Node     `json:"nodes"`
+
+	// Edges holds the dependencies between nodes,
+	// each holding the IDs of the nodes it connects.
+	Edges []Arc `json:"edges"`
+}
+
+// Node represents a node in a Graph.
+type Node struct {
+	// ID holds the name of the node, which is the package
+	// path relative to the module root, followed by the definition
+	// name for definition-level graphs, for example "feed.bsky.app/post#main".
+	ID string `json:"id"`
+
+	// Package holds the package path relative to the module root.
+	Package string `json:"package"`
+
+	// Def holds the definition name for definition-level graphs.
+	Def string `json:"def,omitempty"`
+}
+
+// Graph returns the dependency graph at the given level,
+// with package paths relative to moduleRoot.
+// Dependencies on the lexicue package and on builtin
+// packages such as list are omitted.
+//
+// Only references between packages are recorded, so a definition
+// level graph does not include dependencies between definitions
+// within the same package.
+func (deps *Dependencies) Graph(level GraphLevel, moduleRoot string) *Graph {
+	g := &Graph{
+		Level: level,
+	}
+	nodes := make(map[string]Node)
+	addNode := func(pkg, def string) string {
+		pkg = strings.TrimPrefix(pkg, moduleRoot+"/")
+		n := Node{
+			ID:      pkg,
+			Package: pkg,
+		}
+		if level == DefinitionLevel {
+			n.Def = def
+			n.ID += def
+		}
+		nodes[n.ID] = n
+		return n.ID
+	}
+	edges := make(map[Arc]bool)
+	for _, d := range sortedArcs(deps.Arcs) {
+		if d.To == LexicuePkg || isBuiltinPkg(d.To) {
+			continue
+		}
+		if level == PackageLevel {
+			edges[Arc{addNode(d.From, ""), addNode(d.To, "")}] = true
+			continue
+		}
+		for _, ia := range sortedArcs(deps.Arcs[d]) {
+			to := ia.To
+			if to == "" {
+				// The package itself is its main definition.
+				to = "#main"
+			}
+			edges[Arc{addNode(d.From, ia.From), addNode(d.To, to)}] = true
+		}
+	}
+	g.Nodes = make([]Node, 0, len(nodes))
+	for _, id := range sorted.Keys(nodes) {
+		g.Nodes = append(g.Nodes, nodes[id])
+	}
+	g.Edges = sortedArcs(edges)
+	return g
+}
+
+// isBuiltinPkg reports whether pkg is a builtin CUE package
+// such as list. Builtin package paths have no domain name
+// in their first element.
+func isBuiltinPkg(pkg string) bool {
+	first, _, _ := strings.Cut(pkg, "/")
+	return !strings.Contains(first, ".")
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart to w.
+func (g *Graph) WriteMermaid(w io.Writer) {
+	fmt.Fprintf(w, "flowchart LR\n")
+	// Number the nodes in sorted order so that the IDs are
+	// stable regardless of the order that arcs were added.
+	ids := make(map[string]string)
+	for _, n := range g.Nodes {
+		id := fmt.Sprintf("id%d", len(ids))
+		ids[n.ID] = id
+		if g.Level == PackageLevel {
+			fmt.Fprintf(w, "\t%s[%s]\n", id, n.ID)
+		} else {
+			fmt.Fprintf(w, "\t%s[%q]\n", id, n.ID)
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "\t%s --> %s\n", ids[e.From], ids[e.To])
+	}
+}
+
+// WriteDOT writes the graph in Graphviz DOT format to w.
+// Definition-level graphs group the definitions in each
+// package into a cluster.
+func (g *Graph) WriteDOT(w io.Writer) {
+	fmt.Fprintf(w, "digraph deps {\n")
+	fmt.Fprintf(w, "\trankdir=LR;\n")
+	if g.Level == PackageLevel {
+		for _, n := range g.Nodes {
+			fmt.Fprintf(w, "\t%s;\n", strconv.Quote(n.ID))
+		}
+	} else {
+		var pkgs []string
+		byPkg := make(map[string][]Node)
+		for _, n := range g.Nodes {
+			if byPkg[n.Package] == nil {
+				pkgs = append(pkgs, n.Package)
+			}
+			byPkg[n.Package] = append(byPkg[n.Package], n)
+		}
+		for i, pkg := range pkgs {
+			fmt.Fprintf(w, "\tsubgraph cluster_%d {\n", i)
+			fmt.Fprintf(w, "\t\tlabel=%s;\n", strconv.Quote(pkg))
+			for _, n := range byPkg[pkg] {
+				fmt.Fprintf(w, "\t\t%s [label=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Def))
+			}
+			fmt.Fprintf(w, "\t}\n")
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "\t%s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// WriteJSON writes the graph as JSON to w.
+func (g *Graph) WriteJSON(w io.Writer) {
+	data, err := json.MarshalIndent(g, "", "\t")
+	if err != nil {
+		// Can't happen: the graph holds only strings.
+		panic(err)
+	}
+	w.Write(append(data, '\n'))
+}
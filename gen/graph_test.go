@@ -0,0 +1,118 @@
+package gen_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// graphLexicons holds lexicons that refer to one another,
+// to the lexicue package (through the did format) and to
+// the builtin list package (through maxLength on an array).
+var graphLexicons = []string{`{
+	"lexicon": 1,
+	"id": "com.example.a",
+	"defs": {
+		"main": {
+			"type": "record",
+			"key": "tid",
+			"record": {
+				"type": "object",
+				"properties": {
+					"b": {"type": "ref", "ref": "com.example.b"},
+					"thing": {"type": "ref", "ref": "com.example.b#thing"},
+					"dids": {"type": "array", "maxLength": 3, "items": {"type": "string", "format": "did"}}
+				}
+			}
+		},
+		"view": {
+			"type": "object",
+			"properties": {
+				"c": {"type": "ref", "ref": "com.example.c"}
+			}
+		}
+	}
+}`, `{
+	"lexicon": 1,
+	"id": "com.example.b",
+	"defs": {
+		"main": {
+			"type": "object",
+			"properties": {
+				"items": {"type": "array", "minLength": 1, "items": {"type": "ref", "ref": "com.example.c"}}
+			}
+		},
+		"thing": {"type": "token"}
+	}
+}`, `{
+	"lexicon": 1,
+	"id": "com.example.c",
+	"defs": {
+		"main": {
+			"type": "object",
+			"properties": {
+				"s": {"type": "string"}
+			}
+		}
+	}
+}`}
+
+var graphWriters = []struct {
+	name  string
+	write func(*gen.Graph, *bytes.Buffer)
+}{{
+	name:  "json",
+	write: func(g *gen.Graph, w *bytes.Buffer) { g.WriteJSON(w) },
+}, {
+	name:  "mermaid",
+	write: func(g *gen.Graph, w *bytes.Buffer) { g.WriteMermaid(w) },
+}, {
+	name:  "dot",
+	write: func(g *gen.Graph, w *bytes.Buffer) { g.WriteDOT(w) },
+}}
+
+func TestGraph(t *testing.T) {
+	const moduleRoot = "lexicon.me/defs"
+	g, err := gen.New(gen.Config{
+		ModuleRoot: moduleRoot,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, lex := range graphLexicons {
+		if _, err := g.GenerateJSON([]byte(lex), fmt.Sprintf("lexicon%d.json", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	for _, level := range []struct {
+		name  string
+		level gen.GraphLevel
+	}{{"package", gen.PackageLevel}, {"definition", gen.DefinitionLevel}} {
+		graph := g.Deps().Graph(level.level, moduleRoot)
+		for _, w := range graphWriters {
+			fmt.Fprintf(&buf, "-- %s.%s --\n", level.name, w.name)
+			w.write(graph, &buf)
+		}
+	}
+	const golden = "testdata/graph.txtar"
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("output differs from %s; run with -update to update it\n%s", golden, buf.Bytes())
+	}
+}
@@ -215,7 +215,7 @@ package lexicon
 	#Common
 	type!:         "string"
 	ref?: string		// TODO what's this?
-	format?:       string // TODO rog
+	format?:       #LexStringFormat
 	default?:      string
 	minLength?:    int
 	maxGraphemes?: int
@@ -225,6 +225,18 @@ package lexicon
 	knownValues?: [... string]
 }
 
+#LexStringFormat: "at-identifier" |
+	"at-uri" |
+	"cid" |
+	"datetime" |
+	"did" |
+	"handle" |
+	"language" |
+	"nsid" |
+	"record-key" |
+	"tid" |
+	"uri"
+
 #LexBytes: {
 	#Common
 	type!:      "bytes"
@@ -0,0 +1,147 @@
+package gen
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type Schema struct {
+	Lexicon int                    `json:"lexicon"`
+	ID      string                 `json:"id"`
+	Defs    map[string]*TypeSchema `json:"defs"`
+
+	// positions holds the positions of the values in the JSON
+	// the schema was parsed from, or nil if it wasn't parsed.
+	positions *JSONPositions
+}
+
+// Pos returns the position of the value at the given path
+// in the JSON that s was parsed from (see JSONPositions.Pos),
+// or the zero Position if s wasn't parsed from JSON.
+func (s *Schema) Pos(path ...string) Position {
+	if s.positions == nil {
+		return Position{}
+	}
+	return s.positions.Pos(path...)
+}
+
+type TypeSchema struct {
+	// all
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+
+	// record
+	Key    string      `json:"key,omitempty"`
+	Record *TypeSchema `json:"record,omitempty"`
+
+	// subscription, query
+	Parameters *TypeSchema          `json:"parameters,omitempty"`
+	Message    *SubscriptionMessage `json:"message,omitempty"`
+
+	// procedure
+	Input *BodyType `json:"input,omitempty"`
+
+	// query, procedure
+	Output *BodyType `json:"output,omitempty"`
+
+	// ref
+	Ref string `json:"ref,omitempty"`
+
+	// union
+	Refs []string `json:"refs,omitempty"`
+
+	// object, params
+	Required []string `json:"required,omitempty"`
+
+	// object
+	Nullable []string `json:"nullable,omitempty"`
+
+	// object, params
+	Properties map[string]*TypeSchema `json:"properties,omitempty"`
+
+	// array, string
+	MinLength *int `json:"minLength,omitempty"`
+
+	// video, audio, array, string, bytes
+	// Note: int might not be OK for video, audio
+	MaxLength *int `json:"maxLength,omitempty"`
+
+	// array
+	Items *TypeSchema `json:"items,omitempty"`
+
+	// bool, number, integer, string
+	Const any `json:"const,omitempty"`
+
+	// number, integer, string,
+	Enum []any `json:"enum,omitempty"`
+
+	// union
+	Closed bool `json:"closed,omitempty"`
+
+	// bool, number, integer, string
+	Default any `json:"default,omitempty"`
+
+	// number, integer
+	Minimum any `json:"minimum,omitempty"`
+	Maximum any `json:"maximum,omitempty"`
+
+	// string
+	Format       string   `json:"format,omitempty"`
+	MaxGraphemes *int     `json:"maxGraphemes,omitempty"`
+	KnownValues  []string `json:"knownValues,omitempty"`
+
+	// blob, audio, image, video
+	MaxSize *int     `json:"maxSize,omitempty"`
+	Accept  []string `json:"accept,omitempty"`
+
+	// image, video
+	MaxWidth  *int `json:"maxWidth,omitempty"`
+	MaxHeight *int `json:"maxHeight,omitempty"`
+
+	// procedure, query, subscription
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. It's needed
+// because the properties field is mandatory for objects and params
+// even when there are no properties.
+func (t *TypeSchema) MarshalJSON() ([]byte, error) {
+	type plainTypeSchema TypeSchema
+	if t.Type != "object" && t.Type != "params" {
+		return json.Marshal((*plainTypeSchema)(t))
+	}
+	props := t.Properties
+	if props == nil {
+		props = make(map[string]*TypeSchema)
+	}
+	return json.Marshal(struct {
+		*plainTypeSchema
+		Properties map[string]*TypeSchema `json:"properties"`
+	}{(*plainTypeSchema)(t), props})
+}
+
+type SubscriptionMessage struct {
+	Schema *TypeSchema `json:"schema"`
+}
+
+type Error struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// TODO(bnewbold): suspect this param needs updating for lex refactors
+type Param struct {
+	Type     string `json:"type"`
+	Maximum  int    `json:"maximum"`
+	Required bool   `json:"required"`
+}
+
+type BodyType struct {
+	Encoding string      `json:"encoding"`
+	Schema   *TypeSchema `json:"schema,omitempty"`
+}
+
+func (s *Schema) Name() string {
+	p := strings.Split(s.ID, ".")
+	return p[len(p)-2] + p[len(p)-1]
+}
@@ -0,0 +1,266 @@
+package lexicue
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+#Doc: {
+	lexicon!: 1
+	defs:     or([
+			for def in _#defs {
+			def
+		},
+	])
+}
+
+_#defs: {
+	procedure: {
+		_lexicon!: "procedure"
+		input?:    #xrpcBody
+		output?:   #xrpcBody
+		#xrpcErrors
+	}
+
+	query: {
+		_lexicon!: "query"
+		parameters?: {...}
+		output?: #xrpcBody
+		#xrpcErrors
+	}
+
+	cidLink: {
+		_lexicon!: "cidLink"
+		#cidLink
+	}
+
+	blob: {
+		_lexicon!: "blob"
+		#blob
+	}
+
+	image: {
+		_lexicon!: "image"
+		#image
+	}
+
+	video: {
+		_lexicon!: "video"
+		#video
+	}
+
+	audio: {
+		_lexicon!: "audio"
+		#audio
+	}
+
+	token: {
+		_lexicon!: "token"
+		string
+	}
+
+	record: {
+		_lexicon!: "record"
+		key?:     string
+		record!: {...}
+	}
+
+	subscription: {
+		_lexicon!: "subscription"
+		parameters!: {...}
+		// TODO should we just fold the schema directly into the message field
+		// instead of using the #subscriptionMessage indirection?
+		message?: #subscriptionMessage
+		#xrpcErrors
+	}
+}
+
+for name, def in _#defs {
+	(name): def & {
+		_
+		_lexicon: _
+	}
+}
+
+#xrpcBody: {
+	description?: string
+	// The original seemed to allow an array of string for encoding:
+	// encoding!:    string | [... string]
+	// but in practice that doesn't seem to happen.
+	encoding!: string
+	schema?:   _
+}
+
+#xrpcError: {
+	name!:        string
+	description?: string
+}
+
+// #xrpcErrors holds the errors declared by an XRPC endpoint.
+#xrpcErrors: {
+	errors: *[] | [... #xrpcError]
+
+	// #errorBody validates the body of an error response from the endpoint:
+	// the error must be one of those declared by the endpoint
+	// or one of the generic XRPC errors.
+	#errorBody: {
+		error!:   or([#xrpcGenericError, for e in errors {e.name}])
+		message?: string
+	}
+}
+
+// #xrpcGenericError holds the errors that any XRPC
+// endpoint may return without declaring them.
+#xrpcGenericError: "InvalidRequest" |
+	"ExpiredToken" |
+	"InvalidToken" |
+	"AuthenticationRequired" |
+	"Forbidden" |
+	"PayloadTooLarge" |
+	"RateLimitExceeded" |
+	"InternalServerError" |
+	"MethodNotImplemented" |
+	"UpstreamFailure" |
+	"NotEnoughResources" |
+	"UpstreamTimeout"
+
+#cidLink: {
+	$link!: =~"^Qm[1-9A-HJ-NP-Za-km-z]{44}|[\u00000fFbBcCvVtTkKzZmuMU]$"
+}
+
+// #unknownObject represents an object of a type that's
+// not known to the lexicon, as allowed in open unions.
+#unknownObject: {
+	$type!: string
+	...
+}
+
+#subscriptionMessage: {
+	schema!: _
+}
+
+#blob: {
+	$type!:    "blob"
+	ref!:      #cidLink
+	mimeType!: string
+	size!:     uint
+} | #legacyBlob
+
+#legacyBlob: {
+	$type?:    !="blob"
+	cid!:      string
+	mimeType!: string
+}
+
+#image: {
+	mimeType!: string
+	size!: int
+	width!: number
+	height!: number
+}
+
+#video: {
+	mimeType!: string
+	size!: int
+	width!: number
+	height!: number
+	length!: number
+}
+
+#audio: {
+	mimeType!: string
+	size!: int
+	length!: number
+}
+
+// #string represents a lexicon string with length limits.
+// The limits are specified by unifying with definitions
+// for the respective fields, for example:
+//
+//	{lexicue.#string, #maxLength: 3000, #maxGraphemes: 300}
+//
+// #minLength and #maxLength are measured in UTF-8 bytes;
+// #maxGraphemes is measured in grapheme clusters.
+//
+// #knownValues holds values that have a known meaning;
+// unlike an enum, it does not restrict the string to those values.
+#string: X={
+	string
+	#minLength?:    int
+	#maxLength?:    int
+	#maxGraphemes?: int
+	#knownValues?: [...string]
+
+	if #minLength != _|_ {
+		_byteLength: len(X) & >=#minLength
+	}
+	if #maxLength != _|_ {
+		_byteLength: len(X) & <=#maxLength
+	}
+	if #maxGraphemes != _|_ {
+		// Replace each grapheme cluster by a single byte
+		// so that we can count them.
+		_graphemeLength: len(regexp.ReplaceAll(#graphemeCluster, X, ".")) & <=#maxGraphemes
+	}
+}
+
+// #graphemeCluster matches a single grapheme cluster.
+// RE2 doesn't support \X, so this approximates the extended grapheme
+// cluster rules of Unicode Standard Annex #29 with regular expressions:
+// it treats CRLF, Hangul syllables, regional indicator pairs (flags),
+// characters preceded by prepended concatenation marks, and characters
+// followed by combining marks, variation selectors, emoji modifiers,
+// tags and zero-width-joiner sequences as single clusters.
+//
+// It diverges from the standard in a few rare cases: any character
+// after a zero-width joiner is joined, not just pictographs; a
+// precomposed LVT syllable followed by a V jamo is counted as one
+// cluster rather than two; and the properties of characters added
+// after Unicode 15 are not known.
+#graphemeCluster: #"(?s)\r\n|\#(_#prepend)*(?:\#(_#hangulSyllable)|[\x{1F1E6}-\x{1F1FF}]{2}|[^\p{M}\p{Cc}\x{200D}])(?:[\p{M}\x{FE00}-\x{FE0F}\x{1F3FB}-\x{1F3FF}\x{E0020}-\x{E007F}]|\x{200D}[^\p{M}\x{200D}])*|."#
+
+// _#prepend matches the characters with the Grapheme_Cluster_Break
+// property Prepend, which join the character that follows them.
+_#prepend: #"[\x{0600}-\x{0605}\x{06DD}\x{070F}\x{0890}-\x{0891}\x{08E2}\x{0D4E}\x{110BD}\x{110CD}\x{111C2}-\x{111C3}\x{1193F}\x{11941}\x{11A3A}\x{11A84}-\x{11A89}\x{11D46}\x{11F02}]"#
+
+// _#hangulSyllable matches a Hangul syllable made of leading consonant
+// (L), vowel (V) and trailing consonant (T) jamo, with or without a
+// precomposed syllable (LV or LVT), following rules GB6 to GB8.
+_#hangulSyllable: #"\#(_#hangulL)*(?:\#(_#hangulV)+|\#(_#hangulLV)\#(_#hangulV)*)\#(_#hangulT)*|\#(_#hangulL)+|\#(_#hangulT)+"#
+
+_#hangulL:  #"[\x{1100}-\x{115F}\x{A960}-\x{A97C}]"#
+_#hangulV:  #"[\x{1160}-\x{11A7}\x{D7B0}-\x{D7C6}]"#
+_#hangulT:  #"[\x{11A8}-\x{11FF}\x{D7CB}-\x{D7FB}]"#
+_#hangulLV: #"[\x{AC00}-\x{D7A3}]"#
+
+// String formats.
+// See https://atproto.com/specs/lexicon#string-formats
+// All the patterns below only admit ASCII characters, so counting
+// runes is the same as counting bytes.
+
+#atIdentifier: #did | #handle
+
+#atURI: =~#"^at://[a-zA-Z0-9._:%-]+(/[a-zA-Z0-9.-]+(/[a-zA-Z0-9._~:@!$&%')(*+,;=-]+)?)?(#/[a-zA-Z0-9._~:@!$&%')(*+,;=\-\[\]/\\]*)?$"# & strings.MaxRunes(8192)
+
+#cid: =~#"^[a-zA-Z0-9+=]{8,256}$"#
+
+// #datetime is an RFC 3339 timestamp with the restrictions
+// imposed by atproto: an upper case T separator and
+// a mandatory timezone which isn't -00:00.
+#datetime: time.Time & =~#"^[0-9]{4}-[01][0-9]-[0-3][0-9]T[0-2][0-9]:[0-6][0-9]:[0-6][0-9](\.[0-9]{1,20})?(Z|[+-][0-2][0-9]:[0-5][0-9])$"# & !~#"-00:00$"#
+
+#did: =~#"^did:[a-z]+:[a-zA-Z0-9._:%-]*[a-zA-Z0-9._-]$"# & strings.MaxRunes(2048)
+
+#handle: =~#"^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$"# & strings.MaxRunes(253)
+
+// #language is a loosely checked BCP 47 language tag.
+#language: =~#"^(i|[a-z]{2,3})(-[a-zA-Z0-9]+)*$"#
+
+#nsid: =~#"^[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+(\.[a-zA-Z]([a-zA-Z0-9]{0,62})?)$"# & strings.MaxRunes(317)
+
+#recordKey: =~#"^[a-zA-Z0-9_~.:-]{1,512}$"# & !="." & !=".."
+
+#tid: =~#"^[234567abcdefghij][234567abcdefghijklmnopqrstuvwxyz]{12}$"#
+
+#uri: =~#"^[a-zA-Z][a-zA-Z0-9+.-]*:[!-~]+$"# & strings.MaxRunes(8192)
@@ -0,0 +1,281 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// ModuleFile returns the contents of the cue.mod/module.cue
+// file for the module holding the generated packages.
+func (g *Generator) ModuleFile() []byte {
+	return []byte(fmt.Sprintf("module: %q\nlanguage: version: %q\n", g.cfg.ModuleRoot, "v0.10.0"))
+}
+
+// Lexicons holds the CUE values for a set of generated lexicons.
+type Lexicons struct {
+	useMap bool
+
+	// byID holds each lexicon keyed by its ID.
+	byID map[string]loadedLexicon
+}
+
+type loadedLexicon struct {
+	schema *Schema
+
+	// value holds the value of the package holding the lexicon.
+	value cue.Value
+}
+
+// Load builds the given files, as returned by Generate,
+// into CUE values without writing anything to disk.
+// The files should include all the lexicons that they refer to.
+//
+// Note that packages in the per-lexicon layout may import one
+// another cyclically, which CUE does not allow, so
+// Config.UseMap is usually the better choice when generating
+// files for loading.
+func (g *Generator) Load(ctx *cue.Context, files []*File) (*Lexicons, error) {
+	// The overlay file paths must be absolute but
+	// nothing is read from the directory itself.
+	root := filepath.Join(os.TempDir(), "lexicue-load")
+	overlay := map[string]load.Source{
+		filepath.Join(root, "cue.mod", "module.cue"):                                         load.FromBytes(g.ModuleFile()),
+		filepath.Join(root, "cue.mod", "pkg", filepath.FromSlash(LexicuePkg), "lexicue.cue"): load.FromString(LexicueSource),
+	}
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		src, err := f.Source()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Schema.ID, err)
+		}
+		overlay[filepath.Join(root, filepath.FromSlash(f.Path))] = load.FromBytes(src)
+		dirs[path.Dir(f.Path)] = true
+	}
+	args := make([]string, 0, len(dirs))
+	for _, dir := range sorted.Keys(dirs) {
+		args = append(args, "./"+dir)
+	}
+	insts := load.Instances(args, &load.Config{
+		Dir:     root,
+		Overlay: overlay,
+	})
+	values := make(map[string]cue.Value)
+	var errs []string
+	for _, inst := range insts {
+		if inst.Err != nil {
+			errs = append(errs, errors.Details(inst.Err, nil))
+			continue
+		}
+		v := ctx.BuildInstance(inst)
+		if err := v.Err(); err != nil {
+			errs = append(errs, errors.Details(err, nil))
+			continue
+		}
+		values[instanceDir(inst, root)] = v
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("cannot load generated CUE: %s", strings.Join(errs, "\n"))
+	}
+	l := &Lexicons{
+		useMap: g.cfg.UseMap,
+		byID:   make(map[string]loadedLexicon),
+	}
+	for _, f := range files {
+		if pkg, _ := g.lexiconPkg(f.Schema.ID); pkg != f.Package {
+			// It's a shared package created by BreakCycles.
+			continue
+		}
+		l.byID[f.Schema.ID] = loadedLexicon{
+			schema: f.Schema,
+			value:  values[path.Dir(f.Path)],
+		}
+	}
+	return l, nil
+}
+
+// instanceDir returns the slash-separated directory of inst
+// relative to root.
+func instanceDir(inst *build.Instance, root string) string {
+	rel, err := filepath.Rel(root, inst.Dir)
+	if err != nil {
+		return inst.Dir
+	}
+	return filepath.ToSlash(rel)
+}
+
+// IDs returns the IDs of all the lexicons in l in sorted order.
+func (l *Lexicons) IDs() []string {
+	ids := make([]string, 0, len(l.byID))
+	for id := range l.byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Schema returns the lexicon with the given ID,
+// or nil if there is none.
+func (l *Lexicons) Schema(id string) *Schema {
+	return l.byID[id].schema
+}
+
+// Def returns the CUE value for the lexicon definition with
+// the given fully qualified name, for example "app.bsky.feed.post"
+// or "app.bsky.feed.defs#postView".
+func (l *Lexicons) Def(name string) (cue.Value, error) {
+	id, def, _ := strings.Cut(name, "#")
+	if def == "" {
+		def = "main"
+	}
+	lex, ok := l.byID[id]
+	if !ok {
+		return cue.Value{}, fmt.Errorf("unknown lexicon %q", id)
+	}
+	v := lex.value
+	switch {
+	case l.useMap:
+		v = v.LookupPath(cue.MakePath(cue.Def("#def"), cue.Str(id+"#"+def)))
+	case def != "main":
+		v = v.LookupPath(cue.MakePath(cue.Def("#" + def)))
+	}
+	if !v.Exists() {
+		return cue.Value{}, fmt.Errorf("lexicon %q has no definition %q", id, def)
+	}
+	return v, nil
+}
+
+// defParts maps from the name of each part of a lexicon definition
+// that can be validated to its path within the definition.
+var defParts = map[string]cue.Path{
+	"record":     fieldPath("record"),
+	"input":      fieldPath("input", "schema"),
+	"output":     fieldPath("output", "schema"),
+	"parameters": fieldPath("parameters"),
+	"message":    fieldPath("message", "schema"),
+	"error":      cue.MakePath(cue.Def("#errorBody")),
+}
+
+// PartNames returns the names of the definition parts
+// accepted by Lexicons.Part, in sorted order.
+func PartNames() []string {
+	return sorted.Keys(defParts)
+}
+
+// fieldPath returns a path that selects the given fields
+// whether they're regular, optional or required.
+func fieldPath(names ...string) cue.Path {
+	sels := make([]cue.Selector, len(names))
+	for i, name := range names {
+		sels[i] = cue.Str(name).Optional()
+	}
+	return cue.MakePath(sels...)
+}
+
+// Part returns the CUE value for a part of the lexicon definition with
+// the given name: the record of a record definition, the parameters,
+// input, output or message schema of an XRPC definition, or the body
+// of an error response from an XRPC endpoint.
+func (l *Lexicons) Part(name, part string) (cue.Value, error) {
+	p, ok := defParts[part]
+	if !ok {
+		return cue.Value{}, fmt.Errorf("unknown definition part %q", part)
+	}
+	def, err := l.Def(name)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	v := def.LookupPath(p)
+	if !v.Exists() {
+		return cue.Value{}, fmt.Errorf("definition %q has no %s", name, part)
+	}
+	return v, nil
+}
+
+// InstanceError describes a problem found by ValidateInstance.
+type InstanceError struct {
+	// Path holds the path to the problem within the instance.
+	Path []string
+
+	// Msg holds a description of the problem.
+	Msg string
+}
+
+// PathString returns the path as a dot-separated string,
+// or "." for the root.
+func (e *InstanceError) PathString() string {
+	if len(e.Path) == 0 {
+		return "."
+	}
+	return strings.Join(e.Path, ".")
+}
+
+func (e *InstanceError) Error() string {
+	return e.PathString() + ": " + e.Msg
+}
+
+// InstanceErrors holds all the problems found by ValidateInstance.
+type InstanceErrors []*InstanceError
+
+func (errs InstanceErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateInstance checks the JSON instance in data against schema,
+// which would usually be obtained from Lexicons.Def or Lexicons.Part.
+// If the instance is valid JSON but does not conform to the schema,
+// the returned error is of type InstanceErrors, with paths relative
+// to the instance.
+func ValidateInstance(ctx *cue.Context, schema cue.Value, data []byte, filename string) error {
+	inst := ctx.CompileBytes(data, cue.Filename(filename))
+	if err := inst.Err(); err != nil {
+		return fmt.Errorf("%s", errors.Details(err, nil))
+	}
+	err := schema.Unify(inst).Validate(cue.Concrete(true))
+	if err == nil {
+		return nil
+	}
+	// Report paths relative to the instance rather than the schema.
+	schemaPathLen := len(schema.Path().Selectors())
+	var errs InstanceErrors
+	for _, e := range errors.Errors(err) {
+		p := e.Path()
+		if len(p) >= schemaPathLen {
+			p = p[schemaPathLen:]
+		}
+		// Hidden fields such as _graphemeLength hold values
+		// computed from the instance, so report the problem
+		// at the value they're computed from.
+		if i := slices.IndexFunc(p, isHiddenLabel); i >= 0 {
+			p = p[:i]
+		}
+		format, args := e.Msg()
+		errs = append(errs, &InstanceError{
+			Path: p,
+			Msg:  fmt.Sprintf(format, args...),
+		})
+	}
+	return errs
+}
+
+// isHiddenLabel reports whether the path element s refers to a
+// hidden field. Regular fields with names starting with an
+// underscore are quoted, so they aren't mistaken for hidden ones.
+func isHiddenLabel(s string) bool {
+	return strings.HasPrefix(s, "_")
+}
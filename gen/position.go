@@ -0,0 +1,139 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Position describes a position in a source file.
+type Position struct {
+	Filename string
+	Line     int // 1-based
+	Column   int // 1-based, in bytes
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// JSONPositions records the positions of the values in a JSON document
+// so that problems found in the decoded values can be reported
+// against the source.
+type JSONPositions struct {
+	filename string
+
+	// offsets maps from each path, with its elements joined
+	// by pathSep, to the offset of the value at that path,
+	// or of its key if it's an object member.
+	offsets map[string]int
+
+	// lineStarts holds the offset of the start of each line.
+	lineStarts []int
+}
+
+const pathSep = "\x00"
+
+// NewJSONPositions returns the positions of the values in the JSON
+// document in data, which was read from the given file. If the JSON
+// is malformed, positions are recorded up to the point of the error.
+func NewJSONPositions(filename string, data []byte) *JSONPositions {
+	p := &JSONPositions{
+		filename:   filename,
+		offsets:    make(map[string]int),
+		lineStarts: []int{0},
+	}
+	for i, c := range data {
+		if c == '\n' {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	p.value(dec, data, nil, p.nextOffset(data, 0))
+	return p
+}
+
+// value records the positions within the JSON value at the given path,
+// whose first token is about to be read from dec and starts at offset.
+func (p *JSONPositions) value(dec *json.Decoder, data []byte, path []string, offset int) error {
+	if _, ok := p.offsets[strings.Join(path, pathSep)]; !ok {
+		p.offsets[strings.Join(path, pathSep)] = offset
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	for i := 0; dec.More(); i++ {
+		elemOffset := p.nextOffset(data, int(dec.InputOffset()))
+		elem := strconv.Itoa(i)
+		if delim == '{' {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			elem = tok.(string)
+			// Members are located by their key rather
+			// than their value, which is usually more helpful.
+			p.offsets[strings.Join(append(path, elem), pathSep)] = elemOffset
+			elemOffset = p.nextOffset(data, int(dec.InputOffset()))
+		}
+		if err := p.value(dec, data, append(path[:len(path):len(path)], elem), elemOffset); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// nextOffset returns the offset of the first token
+// in data at or after offset.
+func (p *JSONPositions) nextOffset(data []byte, offset int) int {
+	for offset < len(data) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// Pos returns the position of the value at the given path, such as
+// ["defs", "main", "record", "key"]. Array elements are selected by
+// their decimal index. If there's no value at the path, the position
+// of the closest enclosing value is returned.
+func (p *JSONPositions) Pos(path ...string) Position {
+	offset := 0
+	for n := len(path); n >= 0; n-- {
+		if off, ok := p.offsets[strings.Join(path[:n], pathSep)]; ok {
+			offset = off
+			break
+		}
+	}
+	return p.offsetPos(offset)
+}
+
+// offsetPos returns the position of the given byte offset.
+func (p *JSONPositions) offsetPos(offset int) Position {
+	line := sort.Search(len(p.lineStarts), func(i int) bool {
+		return p.lineStarts[i] > offset
+	})
+	return Position{
+		Filename: p.filename,
+		Line:     line,
+		Column:   offset - p.lineStarts[line-1] + 1,
+	}
+}
@@ -0,0 +1,347 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"github.com/kr/fs"
+)
+
+// ReadLexicons reads all the lexicon files found by walking
+// each of the given paths, checking that each one is valid.
+// Only files with a .json suffix are read when walking directories.
+//
+// All the errors encountered are returned together, along with the
+// lexicons that were read successfully.
+func ReadLexicons(paths ...string) ([]*Schema, error) {
+	lexiconSchema, err := LexiconSchema(cuecontext.New())
+	if err != nil {
+		return nil, err
+	}
+	var schemas []*Schema
+	var errs []string
+	for _, p := range paths {
+		for w := fs.Walk(p); w.Step(); {
+			if err := w.Err(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", w.Path(), err))
+				continue
+			}
+			if w.Stat().IsDir() || (w.Path() != p && !strings.HasSuffix(w.Path(), ".json")) {
+				continue
+			}
+			data, err := os.ReadFile(w.Path())
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			schema, err := parseJSON(data, w.Path(), lexiconSchema)
+			if err != nil {
+				// The error includes the position.
+				errs = append(errs, err.Error())
+				continue
+			}
+			schemas = append(schemas, schema)
+		}
+	}
+	if len(errs) > 0 {
+		return schemas, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return schemas, nil
+}
+
+// SchemaError describes a problem with a lexicon document.
+type SchemaError struct {
+	// Pos holds the position of the problem in the lexicon
+	// source, or the zero Position if the source isn't known.
+	Pos Position
+
+	// Path holds the path to the problem within the lexicon
+	// document, for example ["defs", "main", "record",
+	// "properties", "text", "maxLength"].
+	Path []string
+
+	// Msg describes the problem.
+	Msg string
+}
+
+func (e *SchemaError) Error() string {
+	var buf strings.Builder
+	if e.Pos.Filename != "" {
+		fmt.Fprintf(&buf, "%v: ", e.Pos)
+	}
+	if len(e.Path) > 0 {
+		fmt.Fprintf(&buf, "%s: ", strings.Join(e.Path, "."))
+	}
+	buf.WriteString(e.Msg)
+	return buf.String()
+}
+
+// SchemaErrors holds several problems with a lexicon document.
+type SchemaErrors []*SchemaError
+
+func (errs SchemaErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// parseJSON parses the lexicon JSON in data, checking
+// that it conforms to lexiconSchema.
+func parseJSON(data []byte, filename string, lexiconSchema cue.Value) (*Schema, error) {
+	positions := NewJSONPositions(filename, data)
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, jsonError(err, positions)
+	}
+	if err := validateLexicon(data, positions, lexiconSchema); err != nil {
+		return nil, err
+	}
+	schema.positions = positions
+	return &schema, nil
+}
+
+// jsonError returns err, as returned by json.Unmarshal,
+// as a SchemaError positioned within the source.
+func jsonError(err error, positions *JSONPositions) error {
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		// The offset is just after the problem.
+		return &SchemaError{
+			Pos: positions.offsetPos(max(int(err.Offset)-1, 0)),
+			Msg: err.Error(),
+		}
+	case *json.UnmarshalTypeError:
+		e := &SchemaError{
+			Pos: positions.offsetPos(int(err.Offset)),
+			Msg: fmt.Sprintf("cannot use %s as %v", err.Value, err.Type),
+		}
+		if err.Field != "" {
+			e.Path = strings.Split(err.Field, ".")
+			e.Pos = positions.Pos(e.Path...)
+		}
+		return e
+	}
+	return &SchemaError{
+		Pos: positions.Pos(),
+		Msg: err.Error(),
+	}
+}
+
+// ValidateLexicon checks that the lexicon JSON in data, read from the
+// named file, conforms to lexiconSchema, as returned by LexiconSchema.
+// If it doesn't, the returned error is of type SchemaErrors.
+func ValidateLexicon(data []byte, filename string, lexiconSchema cue.Value) error {
+	return validateLexicon(data, NewJSONPositions(filename, data), lexiconSchema)
+}
+
+// validateLexicon is like ValidateLexicon but uses
+// the already computed positions for the JSON.
+func validateLexicon(data []byte, positions *JSONPositions, lexiconSchema cue.Value) error {
+	v := lexiconSchema.Context().CompileBytes(data, cue.Filename(positions.filename))
+	if err := v.Err(); err != nil {
+		return SchemaErrors{{
+			Pos: positions.Pos(),
+			Msg: errors.Details(err, nil),
+		}}
+	}
+	lv := &lexiconValidator{
+		positions: positions,
+	}
+	lv.check(lexiconSchema, v)
+	// A value with an error inside it can also fail to match
+	// any alternative, resulting in a misleading error on its
+	// type field, so drop those.
+	errs := slices.Clone(lv.errs)
+	lv.errs = slices.DeleteFunc(lv.errs, func(e *SchemaError) bool {
+		n := len(e.Path) - 1
+		if n < 0 || e.Path[n] != "type" {
+			return false
+		}
+		return slices.ContainsFunc(errs, func(other *SchemaError) bool {
+			return len(other.Path) > n && other.Path[n] != "type" && slices.Equal(other.Path[:n], e.Path[:n])
+		})
+	})
+	if len(lv.errs) > 0 {
+		return lv.errs
+	}
+	return nil
+}
+
+// lexiconValidator checks a lexicon document against
+// the lexicon schema.
+type lexiconValidator struct {
+	positions *JSONPositions
+	errs      SchemaErrors
+}
+
+// check checks the JSON value v, which may be part of
+// the lexicon document, against schema.
+//
+// A value that fails to match a disjunction in the schema results
+// in an error from CUE for each alternative, none of which go into
+// detail about the alternative that was intended. When that happens,
+// the alternative with the same type field as the value is checked
+// on its own to find the real problem. Otherwise only one error
+// is reported for each path, and none for paths that enclose other
+// paths with errors.
+func (lv *lexiconValidator) check(schema, v cue.Value) {
+	err := v.Unify(schema).Validate(cue.Concrete(true))
+	if err == nil {
+		return
+	}
+	var paths [][]string
+	msgs := make(map[string][]string)
+	for _, e := range errors.Errors(err) {
+		key := strings.Join(e.Path(), ".")
+		if msgs[key] == nil {
+			paths = append(paths, e.Path())
+		}
+		format, args := e.Msg()
+		msgs[key] = append(msgs[key], fmt.Sprintf(format, args...))
+	}
+outer:
+	for _, path := range paths {
+		key := strings.Join(path, ".")
+		for other := range msgs {
+			if strings.HasPrefix(other, key+".") || key == "" && other != "" {
+				continue outer
+			}
+		}
+		msg := msgs[key][0]
+		if n := len(msgs[key]); n > 1 {
+			// The conflicts are usually with the type field
+			// of the value that failed to match.
+			altPath := path
+			if len(path) > 0 && path[len(path)-1] == "type" {
+				altPath = path[:len(path)-1]
+			}
+			altMsg, ok := lv.checkAlternative(schema, v, altPath)
+			if ok {
+				continue
+			}
+			msg = altMsg
+			if msg == "" {
+				msg = fmt.Sprintf("value matches none of the %d alternatives allowed by the lexicon schema", n)
+			}
+		}
+		lv.add(path, msg)
+	}
+}
+
+// add adds an error unless it's already been found.
+func (lv *lexiconValidator) add(path []string, msg string) {
+	for _, e := range lv.errs {
+		if e.Msg == msg && slices.Equal(e.Path, path) {
+			return
+		}
+	}
+	lv.errs = append(lv.errs, &SchemaError{
+		Pos:  lv.positions.Pos(path...),
+		Path: path,
+		Msg:  msg,
+	})
+}
+
+// checkAlternative checks the value at the given path within v
+// against the alternative in the corresponding schema that has
+// the same type field as the value, selecting alternatives in the
+// same way for any disjunctions on the way there. It reports
+// whether there was such an alternative; if not, it may also
+// return a message describing the values that were allowed.
+func (lv *lexiconValidator) checkAlternative(schema, v cue.Value, path []string) (string, bool) {
+	var msg string
+	var ok bool
+	for _, elem := range path[len(v.Path().Selectors()):] {
+		if schema, msg, ok = selectAlternative(schema, v); !ok {
+			return msg, false
+		}
+		if v.IncompleteKind() == cue.ListKind {
+			i, err := strconv.Atoi(elem)
+			if err != nil {
+				return "", false
+			}
+			v = v.LookupPath(cue.MakePath(cue.Index(i)))
+			schema = schema.LookupPath(cue.MakePath(cue.AnyIndex))
+			continue
+		}
+		v = v.LookupPath(cue.MakePath(cue.Str(elem)))
+		if s := schema.LookupPath(cue.MakePath(cue.Str(elem).Optional())); s.Exists() {
+			schema = s
+		} else {
+			schema = schema.LookupPath(cue.MakePath(cue.AnyString))
+		}
+	}
+	if schema, msg, ok = selectAlternative(schema, v); !ok {
+		return msg, false
+	}
+	lv.check(schema, v)
+	return "", true
+}
+
+// selectAlternative returns the disjunct of schema with the same
+// type field as v, or schema itself if it isn't a disjunction.
+// If there's no such disjunct, it returns a message naming the
+// allowed types, or the allowed values when they're all concrete.
+func selectAlternative(schema, v cue.Value) (cue.Value, string, bool) {
+	alts := schemaDisjuncts(schema)
+	if len(alts) == 1 {
+		return schema, "", true
+	}
+	typePath := cue.MakePath(cue.Str("type").Optional())
+	typ, err := v.LookupPath(typePath).String()
+	if err != nil {
+		var allowed []string
+		for _, alt := range alts {
+			if !alt.IsConcrete() {
+				return cue.Value{}, "", false
+			}
+			allowed = append(allowed, fmt.Sprint(alt))
+		}
+		return cue.Value{}, fmt.Sprintf("value %v is not one of the allowed values (%s)", v, strings.Join(allowed, ", ")), false
+	}
+	var allowed []string
+	for _, alt := range alts {
+		t, err := alt.LookupPath(typePath).String()
+		if err != nil {
+			continue
+		}
+		if t == typ {
+			return alt, "", true
+		}
+		if !slices.Contains(allowed, t) {
+			allowed = append(allowed, t)
+		}
+	}
+	sort.Strings(allowed)
+	return cue.Value{}, fmt.Sprintf("unknown type %q (allowed types are %s)", typ, strings.Join(allowed, ", ")), false
+}
+
+// schemaDisjuncts returns the disjuncts of v, including
+// those of any disjunctions that it refers to.
+// References are followed rather than evaluated, because
+// evaluation drops alternatives that refer back to
+// the disjunction, such as #LexArray within #LexType.
+func schemaDisjuncts(v cue.Value) []cue.Value {
+	if root, path := v.ReferencePath(); len(path.Selectors()) > 0 {
+		v = root.LookupPath(path)
+	}
+	op, args := v.Expr()
+	if op != cue.OrOp {
+		return []cue.Value{v}
+	}
+	var ds []cue.Value
+	for _, arg := range args {
+		ds = append(ds, schemaDisjuncts(arg)...)
+	}
+	return ds
+}
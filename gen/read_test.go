@@ -0,0 +1,75 @@
+package gen_test
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+var validateLexiconTests = []struct {
+	testName string
+	defs     string
+	wantErr  string
+}{{
+	testName: "Valid",
+	defs: `{
+		"main": {"type": "object", "properties": {
+			"a": {"type": "array", "items": {"type": "string", "format": "did"}},
+			"b": {"type": "ref", "ref": "#thing"}
+		}},
+		"thing": {"type": "token"}
+	}`,
+}, {
+	testName: "UnknownType",
+	defs:     `{"main": {"type": "foo"}}`,
+	wantErr:  `defs.main.type: unknown type "foo" (allowed types are array, audio, blob, boolean, bytes, cid-link, image, integer, number, object, procedure, query, record, ref, string, subscription, token, union, unknown, video)`,
+}, {
+	testName: "UnknownPropertyType",
+	defs:     `{"main": {"type": "object", "properties": {"a": {"type": "query"}}}}`,
+	wantErr:  `defs.main.properties.a.type: unknown type "query" (allowed types are array, blob, boolean, bytes, cid-link, integer, number, object, ref, string, union, unknown)`,
+}, {
+	testName: "BadNestedFormat",
+	defs: `{"main": {"type": "object", "properties": {
+		"a": {"type": "array", "items": {"type": "string", "format": "bogus"}}
+	}}}`,
+	wantErr: `defs.main.properties.a.items.format: value "bogus" is not one of the allowed values ("at-identifier", "at-uri", "cid", "datetime", "did", "handle", "language", "nsid", "record-key", "tid", "uri")`,
+}, {
+	testName: "BadNestedField",
+	defs:     `{"main": {"type": "object", "properties": {"a": {"type": "string", "maxLength": -1.5}}}}`,
+	wantErr:  `defs.main.properties.a.maxLength: conflicting values -1.5 and int (mismatched types float and int)`,
+}}
+
+func TestValidateLexicon(t *testing.T) {
+	lexiconSchema, err := gen.LexiconSchema(cuecontext.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range validateLexiconTests {
+		t.Run(test.testName, func(t *testing.T) {
+			data := `{"lexicon": 1, "id": "com.example.test", "defs": ` + test.defs + `}`
+			err := gen.ValidateLexicon([]byte(data), "test.json", lexiconSchema)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(gen.SchemaErrors)
+			if !ok {
+				t.Fatalf("got error %#v, want SchemaErrors", err)
+			}
+			// Leave out the positions so that the
+			// tests aren't sensitive to the layout.
+			var got []string
+			for _, e := range errs {
+				got = append(got, strings.Join(e.Path, ".")+": "+e.Msg)
+			}
+			if got := strings.Join(got, "\n"); got != test.wantErr {
+				t.Errorf("unexpected error\ngot  %s\nwant %s", got, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package gen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// RefError describes a reference in a lexicon
+// that does not resolve to a suitable definition.
+type RefError struct {
+	// ID holds the ID of the lexicon holding the reference.
+	ID string
+
+	// Pos holds the position of the reference in the lexicon
+	// source, or the zero Position if the source isn't known.
+	Pos Position
+
+	// Path holds the path to the reference within the lexicon
+	// document, for example ["defs", "main", "record", "properties",
+	// "embed", "refs", "0"].
+	Path []string
+
+	// Ref holds the reference itself.
+	Ref string
+
+	// Msg describes the problem.
+	Msg string
+}
+
+func (e *RefError) Error() string {
+	where := e.ID
+	if e.Pos.Filename != "" {
+		where = e.Pos.String()
+	}
+	return fmt.Sprintf("%s: %s: bad reference %q: %s", where, strings.Join(e.Path, "."), e.Ref, e.Msg)
+}
+
+// RefErrors holds all the problems found by ResolveRefs.
+type RefErrors []*RefError
+
+func (errs RefErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ResolveRefs checks that every reference in the given lexicons
+// resolves to a definition in one of them, that the definition is
+// of a type that can be referred to (not a query, procedure or
+// subscription) and that union members refer to objects or records.
+// Known values of strings that look like references to tokens,
+// such as "app.bsky.feed.defs#requestMore" or "#local", must
+// refer to tokens.
+//
+// Generate doesn't check references, so problems would otherwise
+// only be found when the generated CUE is loaded, or not at all for
+// known values, which are generated as plain strings. If any are
+// found, the returned error is of type RefErrors.
+func ResolveRefs(schemas []*Schema) error {
+	byID := make(map[string]*Schema)
+	for _, schema := range schemas {
+		byID[schema.ID] = schema
+	}
+	var errs RefErrors
+	for _, id := range sorted.Keys(byID) {
+		r := &resolver{
+			id:   id,
+			byID: byID,
+		}
+		for _, name := range sorted.Keys(byID[id].Defs) {
+			r.resolveType(byID[id].Defs[name], []string{"defs", name})
+		}
+		errs = append(errs, r.errs...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// resolver resolves the references in a single lexicon.
+type resolver struct {
+	id   string
+	byID map[string]*Schema
+	errs RefErrors
+}
+
+func (r *resolver) resolveType(t *TypeSchema, path []string) {
+	if t == nil {
+		return
+	}
+	elem := func(elems ...string) []string {
+		return append(path[:len(path):len(path)], elems...)
+	}
+	switch t.Type {
+	case "record":
+		r.resolveType(t.Record, elem("record"))
+	case "query", "procedure", "subscription":
+		r.resolveType(t.Parameters, elem("parameters"))
+		if t.Input != nil {
+			r.resolveType(t.Input.Schema, elem("input", "schema"))
+		}
+		if t.Output != nil {
+			r.resolveType(t.Output.Schema, elem("output", "schema"))
+		}
+		if t.Message != nil {
+			r.resolveType(t.Message.Schema, elem("message", "schema"))
+		}
+	case "object", "params":
+		for _, name := range sorted.Keys(t.Properties) {
+			r.resolveType(t.Properties[name], elem("properties", name))
+		}
+	case "array":
+		r.resolveType(t.Items, elem("items"))
+	case "string":
+		for i, v := range t.KnownValues {
+			if !isTokenRef(v) {
+				continue
+			}
+			target := r.resolve(v, elem("knownValues", strconv.Itoa(i)))
+			if target != nil && target.Type != "token" {
+				r.errorf(v, elem("knownValues", strconv.Itoa(i)), "known value refers to %s definition, not token", target.Type)
+			}
+		}
+	case "union":
+		for i, ref := range t.Refs {
+			target := r.resolve(ref, elem("refs", strconv.Itoa(i)))
+			if target != nil && target.Type != "object" && target.Type != "record" {
+				r.errorf(ref, elem("refs", strconv.Itoa(i)), "union member has type %s, not object", target.Type)
+			}
+		}
+	case "ref":
+		r.resolve(t.Ref, elem("ref"))
+	}
+}
+
+// resolve returns the definition that ref refers to, or
+// nil if it doesn't refer to a definition that can be referred to.
+func (r *resolver) resolve(ref string, path []string) *TypeSchema {
+	id, def := SplitRef(r.id, ref)
+	schema := r.byID[id]
+	if schema == nil {
+		r.errorf(ref, path, "unknown lexicon %q", id)
+		return nil
+	}
+	t := schema.Defs[def]
+	if t == nil {
+		r.errorf(ref, path, "lexicon %q has no definition %q", id, def)
+		return nil
+	}
+	switch t.Type {
+	case "query", "procedure", "subscription":
+		r.errorf(ref, path, "cannot refer to %s definition", t.Type)
+		return nil
+	}
+	return t
+}
+
+// isTokenRef reports whether the known value v looks like a
+// reference to a token, either in another lexicon, as in
+// "app.bsky.feed.defs#requestMore", or in the same one, as in "#local".
+func isTokenRef(v string) bool {
+	nsid, def, ok := strings.Cut(v, "#")
+	if !ok || def == "" || !ast.IsValidIdent("#"+def) {
+		return false
+	}
+	return nsid == "" || len(strings.Split(nsid, ".")) >= 3
+}
+
+func (r *resolver) errorf(ref string, path []string, msg string, args ...any) {
+	r.errs = append(r.errs, &RefError{
+		ID:   r.id,
+		Pos:  r.byID[r.id].Pos(path...),
+		Path: path,
+		Ref:  ref,
+		Msg:  fmt.Sprintf(msg, args...),
+	})
+}
@@ -0,0 +1,80 @@
+package gen_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+var resolveRefsTests = []struct {
+	testName string
+	defs     string
+	wantErr  string
+}{{
+	testName: "Valid",
+	defs: `{
+		"main": {"type": "object", "properties": {
+			"a": {"type": "ref", "ref": "#thing"},
+			"b": {"type": "union", "refs": ["#thing", "com.example.other#obj"]},
+			"c": {"type": "string", "knownValues": ["plain", "#tok", "com.example.other#tok", "not#a ref", "x.y#z"]}
+		}},
+		"thing": {"type": "object"},
+		"tok": {"type": "token"}
+	}`,
+}, {
+	testName: "UnknownRef",
+	defs:     `{"main": {"type": "ref", "ref": "#missing"}}`,
+	wantErr:  `com.example.test: defs.main.ref: bad reference "#missing": lexicon "com.example.test" has no definition "missing"`,
+}, {
+	testName: "UnionMemberNotObject",
+	defs:     `{"main": {"type": "union", "refs": ["#tok"]}, "tok": {"type": "token"}}`,
+	wantErr:  `com.example.test: defs.main.refs.0: bad reference "#tok": union member has type token, not object`,
+}, {
+	testName: "KnownValueUnknownLexicon",
+	defs:     `{"main": {"type": "string", "knownValues": ["ok", "com.example.missing#tok"]}}`,
+	wantErr:  `com.example.test: defs.main.knownValues.1: bad reference "com.example.missing#tok": unknown lexicon "com.example.missing"`,
+}, {
+	testName: "KnownValueUnknownDefinition",
+	defs:     `{"main": {"type": "string", "knownValues": ["#missing"]}}`,
+	wantErr:  `com.example.test: defs.main.knownValues.0: bad reference "#missing": lexicon "com.example.test" has no definition "missing"`,
+}, {
+	testName: "KnownValueNotToken",
+	defs:     `{"main": {"type": "string", "knownValues": ["com.example.other#obj"]}}`,
+	wantErr:  `com.example.test: defs.main.knownValues.0: bad reference "com.example.other#obj": known value refers to object definition, not token`,
+}}
+
+func TestResolveRefs(t *testing.T) {
+	other := testSchema(t, "com.example.other", `{"obj": {"type": "object"}, "tok": {"type": "token"}}`)
+	for _, test := range resolveRefsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			err := gen.ResolveRefs([]*gen.Schema{
+				testSchema(t, "com.example.test", test.defs),
+				other,
+			})
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if _, ok := err.(gen.RefErrors); !ok {
+				t.Fatalf("got error %#v, want RefErrors", err)
+			}
+			if got := err.Error(); got != test.wantErr {
+				t.Errorf("unexpected error\ngot  %s\nwant %s", got, test.wantErr)
+			}
+		})
+	}
+}
+
+// testSchema returns a lexicon with the given ID and definitions.
+func testSchema(t *testing.T, id, defs string) *gen.Schema {
+	t.Helper()
+	var schema gen.Schema
+	data := `{"lexicon": 1, "id": "` + id + `", "defs": ` + defs + `}`
+	if err := json.Unmarshal([]byte(data), &schema); err != nil {
+		t.Fatal(err)
+	}
+	return &schema
+}
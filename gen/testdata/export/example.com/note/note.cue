@@ -0,0 +1,42 @@
+// A short note.
+package note
+
+import (
+	"list"
+
+	"cueschemas.org/lexicue"
+)
+
+lexicue.record & {
+	key: "tid"
+	record!: {
+		// The text of the note.
+		text!: {
+			lexicue.#string
+			#maxLength:    3000
+			#maxGraphemes: 300
+		}
+		tags?:       [...string] & list.MaxItems(8)
+		createdAt!:  lexicue.#datetime
+		visibility?: *"public" | "followers" | "private"
+		priority?:   int & >=1 & <=5
+		embed?:      #link & {
+			$type!: "com.example.note#link"
+		} | lexicue.#unknownObject & {
+			$type!: !="com.example.note#link"
+		}
+		replyTo?: #ref | null
+	}
+}
+
+// A link to a web page.
+#link: {
+	uri!:   lexicue.#uri
+	title?: string
+}
+
+// A reference to another note.
+#ref: {
+	uri!: lexicue.#atURI
+	cid!: lexicue.#cid
+}
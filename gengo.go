@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/gogen"
+)
+
+// runGenGo implements the gen-go command, which
+// generates Go types from lexicons.
+func runGenGo(args []string) int {
+	flags := flag.NewFlagSet("gen-go", flag.ExitOnError)
+	outDir := flags.String("o", "", "write generated files to `dir` rather than as a txtar archive on stdout")
+	pkgRoot := flags.String("pkg", "lexicon.me", "generate packages under the Go import path `root`")
+	cleanOut := flags.Bool("clean", false, "remove files generated by a previous run that are no longer generated (requires -o)")
+	forceOut := flags.Bool("f", false, "overwrite files even when they have been modified since they were generated (requires -o)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue gen-go [flags] [lexiconfile.json | directory]...\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+	}
+	if *outDir == "" && (*cleanOut || *forceOut) {
+		flags.Usage()
+	}
+	exitStatus := 0
+	schemas, err := gen.ReadLexicons(flags.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exitStatus = 1
+	}
+	files, err := gogen.Generate(gogen.Config{
+		PackageRoot: *pkgRoot,
+	}, schemas)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exitStatus = 1
+	}
+	var out output = &txtarOutput{w: os.Stdout}
+	if *outDir != "" {
+		// Don't remove files as stale when they may only be
+		// missing because some lexicons couldn't be read.
+		dout, err := newDirOutput(*outDir, ".lexicue.sum", *cleanOut && exitStatus == 0, *forceOut)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		out = dout
+	}
+	for _, f := range files {
+		if err := out.WriteFile(f.Path, f.Source); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitStatus = 1
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exitStatus = 1
+	}
+	return exitStatus
+}
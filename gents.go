@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/tsgen"
+)
+
+// runGenTS implements the gen-ts command, which
+// generates TypeScript types from lexicons.
+func runGenTS(args []string) int {
+	flags := flag.NewFlagSet("gen-ts", flag.ExitOnError)
+	outDir := flags.String("o", "", "write generated files to `dir` rather than as a txtar archive on stdout")
+	cleanOut := flags.Bool("clean", false, "remove files generated by a previous run that are no longer generated (requires -o)")
+	forceOut := flags.Bool("f", false, "overwrite files even when they have been modified since they were generated (requires -o)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue gen-ts [flags] [lexiconfile.json | directory]...\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+	}
+	if *outDir == "" && (*cleanOut || *forceOut) {
+		flags.Usage()
+	}
+	exitStatus := 0
+	schemas, err := gen.ReadLexicons(flags.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exitStatus = 1
+	}
+	files, err := tsgen.Generate(schemas)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exitStatus = 1
+	}
+	var out output = &txtarOutput{w: os.Stdout}
+	if *outDir != "" {
+		// Don't remove files as stale when they may only be
+		// missing because some lexicons couldn't be read.
+		dout, err := newDirOutput(*outDir, ".lexicue.sum", *cleanOut && exitStatus == 0, *forceOut)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		out = dout
+	}
+	for _, f := range files {
+		if err := out.WriteFile(f.Path, f.Source); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitStatus = 1
+		}
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exitStatus = 1
+	}
+	return exitStatus
+}
@@ -5,13 +5,18 @@ go 1.21
 require (
 	cuelang.org/go v0.6.0-alpha.1.0.20230507153935-6c926983a43e
 	github.com/kr/fs v0.1.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 )
 
 require (
 	github.com/cockroachdb/apd/v2 v2.0.2 // indirect
+	github.com/emicklei/proto v1.10.0 // indirect
 	github.com/google/uuid v1.2.0 // indirect
+	github.com/mitchellh/go-wordwrap v1.0.1 // indirect
 	github.com/mpvl/unique v0.0.0-20150818121801-cbe035fff7de // indirect
 	github.com/pkg/errors v0.8.1 // indirect
+	github.com/protocolbuffers/txtpbfmt v0.0.0-20230328191034-3462fbc510c0 // indirect
+	golang.org/x/mod v0.9.0 // indirect
 	golang.org/x/net v0.8.0 // indirect
 	golang.org/x/text v0.8.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,798 @@
+// Package gogen generates Go types from atproto lexicon schemas.
+//
+// Each lexicon is generated into its own package, laid out in the
+// same way as the generated CUE (see gen.PackagePath), except
+// that lexicons that refer to one another cyclically share a package.
+// The generated code depends on the lexgo package.
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/naming"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// LexgoPkg holds the import path of the package
+// that the generated code depends on.
+const LexgoPkg = "github.com/rogpeppe/lexicue/gogen/lexgo"
+
+// Config holds configuration for Generate.
+type Config struct {
+	// PackageRoot holds the Go import path that
+	// generated packages are placed under.
+	PackageRoot string
+}
+
+// File holds a generated Go source file.
+type File struct {
+	// Path holds the slash-separated path of the file
+	// relative to the directory corresponding to PackageRoot.
+	Path string
+
+	// Package holds the import path of the file's package.
+	Package string
+
+	// Source holds the formatted Go source.
+	Source []byte
+}
+
+// Generate generates a Go package for each of the given lexicons.
+// All the lexicons referred to must be included.
+//
+// Go does not allow import cycles, so lexicons that refer to one
+// another cyclically are generated together into a single package
+// under PackageRoot/shared, with one file for each lexicon.
+// The names declared in such a package are qualified by
+// lexicon, as in FeedDefsPostView for app.bsky.feed.defs#postView.
+func Generate(cfg Config, schemas []*gen.Schema) ([]*File, error) {
+	if cfg.PackageRoot == "" {
+		return nil, fmt.Errorf("no package root specified")
+	}
+	g := &generator{
+		cfg:     cfg,
+		schemas: make(map[string]*gen.Schema),
+		shared:  make(map[string]*sharedPkg),
+	}
+	for _, schema := range schemas {
+		g.schemas[schema.ID] = schema
+	}
+	files, err := g.generateAll()
+	if err != nil {
+		return files, err
+	}
+	cycles := g.deps.Cycles()
+	if len(cycles) == 0 {
+		return files, nil
+	}
+	// Now that we know which packages import one another,
+	// generate again with each cycle merged into one package.
+	for _, c := range cycles {
+		g.addShared(c)
+	}
+	return g.generateAll()
+}
+
+type generator struct {
+	cfg     Config
+	schemas map[string]*gen.Schema
+
+	// shared maps from the ID of each lexicon in an import
+	// cycle to the package that the cycle is generated into.
+	shared map[string]*sharedPkg
+
+	// deps records the imports between the generated packages.
+	deps *gen.Dependencies
+}
+
+// sharedPkg describes a package that holds all the
+// lexicons in an import cycle.
+type sharedPkg struct {
+	path string
+
+	// members holds the IDs of the lexicons in the package, in sorted order.
+	members []string
+
+	// names maps from lexicon ID to definition name to
+	// the Go name for the definition.
+	names map[string]map[string]string
+
+	// nsids maps from lexicon ID to the name of the
+	// constant holding the ID.
+	nsids map[string]string
+
+	// declared holds the names declared in the package.
+	declared map[string]bool
+}
+
+// generateAll generates a file for each lexicon
+// and records the imports between them in g.deps.
+func (g *generator) generateAll() ([]*File, error) {
+	g.deps = &gen.Dependencies{
+		Arcs: make(map[gen.Arc]map[gen.Arc]bool),
+	}
+	for _, s := range g.shared {
+		s.resetDeclared()
+	}
+	var files []*File
+	var errs []string
+	for _, id := range sorted.Keys(g.schemas) {
+		f, err := g.generate(g.schemas[id])
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", id, err))
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	if len(errs) > 0 {
+		return files, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return files, nil
+}
+
+// addShared arranges for the lexicons in the packages of
+// the import cycle c to be generated into a single package.
+func (g *generator) addShared(c gen.Cycle) {
+	inCycle := make(map[string]bool)
+	for _, pkg := range c.Packages {
+		inCycle[pkg] = true
+	}
+	s := &sharedPkg{
+		path:  g.cfg.PackageRoot + "/shared/" + strings.TrimPrefix(c.Packages[0], g.cfg.PackageRoot+"/"),
+		names: make(map[string]map[string]string),
+		nsids: make(map[string]string),
+	}
+	for _, id := range sorted.Keys(g.schemas) {
+		if pkg, err := gen.PackagePath(g.cfg.PackageRoot, id); err == nil && inCycle[pkg] {
+			s.members = append(s.members, id)
+			g.shared[id] = s
+		}
+	}
+	used := make(map[string]bool)
+	for _, id := range s.members {
+		prefix := memberName("", id)
+		s.nsids[id] = naming.Unique(prefix+"NSID", used)
+		names := make(map[string]string)
+		for _, def := range sorted.Keys(g.schemas[id].Defs) {
+			name := prefix
+			if def != "main" {
+				name += naming.Exported(def)
+			}
+			names[def] = naming.Unique(name, used)
+		}
+		s.names[id] = names
+	}
+}
+
+// resetDeclared resets the declared names to
+// those of the lexicon definitions.
+func (s *sharedPkg) resetDeclared() {
+	s.declared = make(map[string]bool)
+	for _, id := range s.members {
+		s.declared[s.nsids[id]] = true
+		for _, name := range s.names[id] {
+			s.declared[name] = true
+		}
+	}
+}
+
+// pkgPath returns the import path of the package
+// that the lexicon with the given ID is generated into.
+func (g *generator) pkgPath(id string) (string, error) {
+	if s := g.shared[id]; s != nil {
+		return s.path, nil
+	}
+	return gen.PackagePath(g.cfg.PackageRoot, id)
+}
+
+// typeName returns the Go name for the given definition
+// in the lexicon with the given ID.
+func (g *generator) typeName(id, def string) string {
+	if s := g.shared[id]; s != nil {
+		return s.names[id][def]
+	}
+	return naming.TypeNames(g.schemas[id], "NSID")[def]
+}
+
+// fileGen holds the state for generating the Go
+// package for a single lexicon.
+type fileGen struct {
+	*generator
+	schema *gen.Schema
+	pkg    string
+
+	// buf holds the generated declarations.
+	buf bytes.Buffer
+
+	// imports maps from import path to the name used for it.
+	imports map[string]string
+
+	// importNames holds the names used for imports.
+	importNames map[string]bool
+
+	// declared holds the names of all the declared types and constants.
+	declared map[string]bool
+
+	// currentDef holds the $type name of the
+	// definition being generated.
+	currentDef string
+}
+
+func (g *generator) generate(schema *gen.Schema) (*File, error) {
+	pkg, err := g.pkgPath(schema.ID)
+	if err != nil {
+		return nil, err
+	}
+	f := &fileGen{
+		generator:   g,
+		schema:      schema,
+		pkg:         pkg,
+		imports:     make(map[string]string),
+		importNames: make(map[string]bool),
+	}
+	nsid := "NSID"
+	shared := g.shared[schema.ID]
+	if shared != nil {
+		nsid = shared.nsids[schema.ID]
+		f.declared = shared.declared
+	} else {
+		f.declared = map[string]bool{nsid: true}
+		for _, name := range naming.TypeNames(schema, "NSID") {
+			f.declared[name] = true
+		}
+	}
+	f.printf("// %s holds the ID of the lexicon.\n", nsid)
+	f.printf("const %s = %q\n\n", nsid, schema.ID)
+	for _, name := range sorted.Keys(schema.Defs) {
+		f.currentDef = gen.TypeName(schema.ID, name)
+		if err := f.def(g.typeName(schema.ID, name), name, schema.Defs[name]); err != nil {
+			return nil, fmt.Errorf("bad definition %q: %v", name, err)
+		}
+	}
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by lexicue gen-go; DO NOT EDIT.\n\n")
+	filePath := strings.TrimPrefix(pkg, g.cfg.PackageRoot+"/") + "/lexicon.go"
+	if shared != nil {
+		filePath = strings.TrimPrefix(pkg, g.cfg.PackageRoot+"/") + "/" + schema.ID + ".lexicon.go"
+		if schema.ID == shared.members[0] {
+			writeComment(&src, "", "Package "+packageName(pkg)+" implements the "+strings.Join(shared.members, ", ")+" lexicons.\n\n"+
+				"They refer to one another cyclically, so they are generated into a single package.")
+		}
+	} else if desc := mainDescription(schema); desc != "" {
+		writeComment(&src, "", "Package "+packageName(pkg)+" implements the "+schema.ID+" lexicon.\n\n"+desc)
+	}
+	fmt.Fprintf(&src, "package %s\n\n", packageName(pkg))
+	if len(f.imports) > 0 {
+		fmt.Fprintf(&src, "import (\n")
+		// Standard library packages go first, in their own group.
+		for _, std := range []bool{true, false} {
+			for _, ipath := range sorted.Keys(f.imports) {
+				if isStdPkg(ipath) != std {
+					continue
+				}
+				name := f.imports[ipath]
+				if name == path.Base(ipath) {
+					fmt.Fprintf(&src, "\t%q\n", ipath)
+				} else {
+					fmt.Fprintf(&src, "\t%s %q\n", name, ipath)
+				}
+			}
+			fmt.Fprintf(&src, "\n")
+		}
+		fmt.Fprintf(&src, ")\n\n")
+	}
+	src.Write(f.buf.Bytes())
+	data, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot format generated code: %v\n%s", err, src.Bytes())
+	}
+	return &File{
+		Path:    filePath,
+		Package: pkg,
+		Source:  data,
+	}, nil
+}
+
+func (f *fileGen) printf(format string, args ...any) {
+	fmt.Fprintf(&f.buf, format, args...)
+}
+
+// def generates the Go declarations for the lexicon
+// definition with the given name.
+func (f *fileGen) def(goName, name string, t *gen.TypeSchema) error {
+	typeID := gen.TypeName(f.schema.ID, name)
+	comment := goName + " is generated from the " + typeID + " lexicon definition."
+	if t.Description != "" {
+		comment += "\n\n" + t.Description
+	}
+	switch t.Type {
+	case "token":
+		writeComment(&f.buf, "", comment)
+		f.printf("const %s = %q\n\n", goName, typeID)
+		return nil
+	case "record":
+		return f.structType(goName, comment, t.Record, typeID, true)
+	case "object":
+		return f.structType(goName, comment, t, typeID, false)
+	case "query", "procedure", "subscription":
+		return f.xrpcTypes(goName, t)
+	case "image", "video", "audio":
+		writeComment(&f.buf, "", comment)
+		f.printf("type %s = %s\n\n", goName, f.lexgo("Blob"))
+		return nil
+	case "union":
+		return f.unionType(goName, comment, t)
+	}
+	typ, err := f.goType(t, goName)
+	if err != nil {
+		return err
+	}
+	writeComment(&f.buf, "", comment)
+	f.printf("type %s %s\n\n", goName, typ.expr)
+	return nil
+}
+
+// xrpcTypes generates types for the parameters and
+// bodies of an XRPC endpoint.
+func (f *fileGen) xrpcTypes(goName string, t *gen.TypeSchema) error {
+	if t.Parameters != nil && len(t.Parameters.Properties) > 0 {
+		name := naming.Unique(goName+"Params", f.declared)
+		comment := name + " holds the parameters for " + f.schema.ID + "."
+		if err := f.structType(name, comment, t.Parameters, "", false); err != nil {
+			return fmt.Errorf("bad parameters: %v", err)
+		}
+	}
+	for _, body := range []struct {
+		name string
+		body *gen.BodyType
+	}{
+		{"Input", t.Input},
+		{"Output", t.Output},
+	} {
+		if body.body == nil || body.body.Schema == nil {
+			continue
+		}
+		if err := f.bodyType(naming.Unique(goName+body.name, f.declared), strings.ToLower(body.name), body.body.Schema); err != nil {
+			return fmt.Errorf("bad %s: %v", strings.ToLower(body.name), err)
+		}
+	}
+	if t.Message != nil && t.Message.Schema != nil {
+		if err := f.bodyType(naming.Unique(goName+"Message", f.declared), "message", t.Message.Schema); err != nil {
+			return fmt.Errorf("bad message: %v", err)
+		}
+	}
+	return nil
+}
+
+// bodyType generates a type with the given name for
+// the schema of an XRPC body or message.
+func (f *fileGen) bodyType(goName, what string, t *gen.TypeSchema) error {
+	comment := goName + " holds the " + what + " for " + f.schema.ID + "."
+	switch t.Type {
+	case "object":
+		return f.structType(goName, comment, t, "", false)
+	case "union":
+		if what == "message" {
+			return f.messageUnionType(goName, comment, t)
+		}
+		return f.unionType(goName, comment, t)
+	}
+	typ, err := f.goType(t, goName)
+	if err != nil {
+		return err
+	}
+	writeComment(&f.buf, "", comment)
+	f.printf("type %s = %s\n\n", goName, typ.expr)
+	return nil
+}
+
+// structType generates a struct type for the object t.
+// If typeID is non-empty, the struct includes a $type field,
+// which is required if isRecord is true.
+func (f *fileGen) structType(goName, comment string, t *gen.TypeSchema, typeID string, isRecord bool) error {
+	// Generate the struct into its own buffer because
+	// field types might require other types to be declared.
+	var buf bytes.Buffer
+	writeComment(&buf, "", comment)
+	fmt.Fprintf(&buf, "type %s struct {\n", goName)
+	if typeID != "" {
+		omitEmpty := ",omitempty"
+		if isRecord {
+			omitEmpty = ""
+		}
+		fmt.Fprintf(&buf, "\tLexiconTypeID string `json:\"$type%s\"`\n", omitEmpty)
+	}
+	fieldNames := make(map[string]bool)
+	for _, name := range sorted.Keys(t.Properties) {
+		pt := t.Properties[name]
+		fieldName := naming.Unique(naming.Exported(name), fieldNames)
+		typ, err := f.goType(pt, goName+fieldName)
+		if err != nil {
+			return fmt.Errorf("bad property %q: %v", name, err)
+		}
+		required := slices.Contains(t.Required, name)
+		nullable := slices.Contains(t.Nullable, name)
+		expr := typ.expr
+		if (!required || nullable) && typ.pointer {
+			expr = "*" + expr
+		}
+		tag := name
+		if !required {
+			tag += ",omitempty"
+		}
+		writeComment(&buf, "\t", pt.Description)
+		fmt.Fprintf(&buf, "\t%s %s `json:%q`\n", fieldName, expr, tag)
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+	if isRecord {
+		// A record's $type is always its own ID, so
+		// don't rely on callers to set it.
+		fmt.Fprintf(&buf, "// MarshalJSON implements json.Marshaler\n")
+		fmt.Fprintf(&buf, "// by setting LexiconTypeID to %q.\n", typeID)
+		fmt.Fprintf(&buf, "func (r %s) MarshalJSON() ([]byte, error) {\n", goName)
+		fmt.Fprintf(&buf, "\ttype plain %s\n", goName)
+		fmt.Fprintf(&buf, "\tr.LexiconTypeID = %q\n", typeID)
+		fmt.Fprintf(&buf, "\treturn %s.Marshal(plain(r))\n", f.importName("encoding/json"))
+		fmt.Fprintf(&buf, "}\n\n")
+	}
+	f.buf.Write(buf.Bytes())
+	return nil
+}
+
+// goTypeInfo describes a Go type.
+type goTypeInfo struct {
+	// expr holds the Go type expression.
+	expr string
+
+	// pointer holds whether the type should be
+	// used as a pointer when the value is optional.
+	pointer bool
+}
+
+// goType returns the Go type for t. Any types that need
+// to be declared for t are named after the provided name.
+func (f *fileGen) goType(t *gen.TypeSchema, name string) (goTypeInfo, error) {
+	switch t.Type {
+	case "string":
+		return goTypeInfo{"string", true}, nil
+	case "integer":
+		return goTypeInfo{"int64", true}, nil
+	case "number":
+		return goTypeInfo{"float64", true}, nil
+	case "boolean":
+		return goTypeInfo{"bool", true}, nil
+	case "bytes":
+		return goTypeInfo{f.lexgo("Bytes"), false}, nil
+	case "cid-link":
+		return goTypeInfo{f.lexgo("CIDLink"), true}, nil
+	case "blob":
+		return goTypeInfo{f.lexgo("Blob"), true}, nil
+	case "unknown":
+		return goTypeInfo{f.importName("encoding/json") + ".RawMessage", false}, nil
+	case "array":
+		if t.Items == nil {
+			return goTypeInfo{}, fmt.Errorf("array has no items")
+		}
+		elem, err := f.goType(t.Items, name+"Elem")
+		if err != nil {
+			return goTypeInfo{}, err
+		}
+		return goTypeInfo{"[]" + elem.expr, false}, nil
+	case "object", "params":
+		name = naming.Unique(name, f.declared)
+		comment := name + " is generated from an inline " + t.Type + " in the " + f.schema.ID + " lexicon."
+		if t.Description != "" {
+			comment += "\n\n" + t.Description
+		}
+		if err := f.structType(name, comment, t, "", false); err != nil {
+			return goTypeInfo{}, err
+		}
+		return goTypeInfo{name, true}, nil
+	case "ref":
+		return f.refType(t.Ref)
+	case "union":
+		name = naming.Unique(name, f.declared)
+		comment := name + " holds one of the members of a union; at most one field should be set."
+		if t.Description != "" {
+			comment += "\n\n" + t.Description
+		}
+		if err := f.unionType(name, comment, t); err != nil {
+			return goTypeInfo{}, err
+		}
+		return goTypeInfo{name, true}, nil
+	}
+	return goTypeInfo{}, fmt.Errorf("unsupported type %q", t.Type)
+}
+
+// refType returns the Go type for a reference to the definition with the given name.
+func (f *fileGen) refType(ref string) (goTypeInfo, error) {
+	id, def, t, err := gen.ResolveRef(f.schemas, f.schema.ID, ref)
+	if err != nil {
+		return goTypeInfo{}, err
+	}
+	info := goTypeInfo{
+		pointer: true,
+	}
+	switch t.Type {
+	case "token":
+		// A reference to a token holds the token's name.
+		info.expr = "string"
+		return info, nil
+	case "query", "procedure", "subscription":
+		return goTypeInfo{}, fmt.Errorf("cannot refer to %s definition %q", t.Type, ref)
+	case "array", "bytes", "unknown":
+		info.pointer = false
+	}
+	info.expr = f.typeName(id, def)
+	pkg, err := f.pkgPath(id)
+	if err != nil {
+		return goTypeInfo{}, err
+	}
+	if pkg == f.pkg {
+		return info, nil
+	}
+	f.addDep(pkg, gen.TypeName(id, def))
+	info.expr = f.importName(pkg) + "." + info.expr
+	return info, nil
+}
+
+// unionMember holds a member of a union type.
+type unionMember struct {
+	field  string
+	ref    string
+	typeID string
+	typ    goTypeInfo
+}
+
+// unionMembers returns the members of the union t.
+func (f *fileGen) unionMembers(t *gen.TypeSchema) ([]unionMember, error) {
+	var members []unionMember
+	fieldNames := map[string]bool{"Unknown": true}
+	for _, ref := range t.Refs {
+		typ, err := f.refType(ref)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, unionMember{
+			field:  naming.Unique(memberName(f.schema.ID, ref), fieldNames),
+			ref:    ref,
+			typeID: gen.TypeName(gen.SplitRef(f.schema.ID, ref)),
+			typ:    typ,
+		})
+	}
+	return members, nil
+}
+
+// writeUnionStruct writes the struct type that holds
+// one of the given members of the union t.
+func (f *fileGen) writeUnionStruct(buf *bytes.Buffer, goName, comment string, t *gen.TypeSchema, members []unionMember) {
+	writeComment(buf, "", comment)
+	fmt.Fprintf(buf, "type %s struct {\n", goName)
+	for _, m := range members {
+		fmt.Fprintf(buf, "\t%s *%s\n", m.field, m.typ.expr)
+	}
+	if !t.Closed {
+		fmt.Fprintf(buf, "\n\t// Unknown holds a member with a type not listed above.\n")
+		fmt.Fprintf(buf, "\tUnknown *%s\n", f.lexgo("Unknown"))
+	}
+	fmt.Fprintf(buf, "}\n\n")
+}
+
+// unionType generates a struct type that holds
+// one member of the union t.
+func (f *fileGen) unionType(goName, comment string, t *gen.TypeSchema) error {
+	members, err := f.unionMembers(t)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	f.writeUnionStruct(&buf, goName, comment, t, members)
+
+	jsonPkg := f.importName("encoding/json")
+	fmtPkg := f.importName("fmt")
+	fmt.Fprintf(&buf, "// MarshalJSON implements json.Marshaler.\n")
+	fmt.Fprintf(&buf, "func (u %s) MarshalJSON() ([]byte, error) {\n", goName)
+	fmt.Fprintf(&buf, "\tswitch {\n")
+	for _, m := range members {
+		fmt.Fprintf(&buf, "\tcase u.%s != nil:\n", m.field)
+		fmt.Fprintf(&buf, "\t\treturn %s(%q, u.%s)\n", f.lexgo("MarshalTyped"), m.typeID, m.field)
+	}
+	if !t.Closed {
+		fmt.Fprintf(&buf, "\tcase u.Unknown != nil:\n")
+		fmt.Fprintf(&buf, "\t\treturn %s.Marshal(u.Unknown)\n", jsonPkg)
+	}
+	fmt.Fprintf(&buf, "\t}\n")
+	fmt.Fprintf(&buf, "\treturn nil, %s.Errorf(\"no member set in %s\")\n", fmtPkg, goName)
+	fmt.Fprintf(&buf, "}\n\n")
+
+	fmt.Fprintf(&buf, "// UnmarshalJSON implements json.Unmarshaler.\n")
+	fmt.Fprintf(&buf, "func (u *%s) UnmarshalJSON(data []byte) error {\n", goName)
+	fmt.Fprintf(&buf, "\ttyp, err := %s(data)\n", f.lexgo("TypeOf"))
+	fmt.Fprintf(&buf, "\tif err != nil {\n\t\treturn err\n\t}\n")
+	fmt.Fprintf(&buf, "\t*u = %s{}\n", goName)
+	fmt.Fprintf(&buf, "\tswitch typ {\n")
+	for _, m := range members {
+		fmt.Fprintf(&buf, "\tcase %q:\n", m.typeID)
+		fmt.Fprintf(&buf, "\t\tu.%s = new(%s)\n", m.field, m.typ.expr)
+		fmt.Fprintf(&buf, "\t\treturn %s.Unmarshal(data, u.%s)\n", jsonPkg, m.field)
+	}
+	fmt.Fprintf(&buf, "\t}\n")
+	if t.Closed {
+		fmt.Fprintf(&buf, "\treturn %s.Errorf(\"unexpected $type %%q in %s\", typ)\n", fmtPkg, goName)
+	} else {
+		fmt.Fprintf(&buf, "\tu.Unknown = &%s{\n", f.lexgo("Unknown"))
+		fmt.Fprintf(&buf, "\t\tType: typ,\n")
+		fmt.Fprintf(&buf, "\t\tData: append(%s.RawMessage(nil), data...),\n", jsonPkg)
+		fmt.Fprintf(&buf, "\t}\n")
+		fmt.Fprintf(&buf, "\treturn nil\n")
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+	f.buf.Write(buf.Bytes())
+	return nil
+}
+
+// messageUnionType generates a struct type that holds one member
+// of the union t, which is the message type of a subscription.
+// The type of a message is carried in the frame header rather
+// than in a $type field, so the JSON encoding of the struct has
+// no $type field, and it's decoded with UnmarshalMessage.
+func (f *fileGen) messageUnionType(goName, comment string, t *gen.TypeSchema) error {
+	members, err := f.unionMembers(t)
+	if err != nil {
+		return err
+	}
+	comment += "\n\nA message's type is carried in the frame header rather than\nin a $type field, so use UnmarshalMessage to decode it."
+	var buf bytes.Buffer
+	f.writeUnionStruct(&buf, goName, comment, t, members)
+
+	jsonPkg := f.importName("encoding/json")
+	fmtPkg := f.importName("fmt")
+	fmt.Fprintf(&buf, "// MarshalJSON implements json.Marshaler.\n")
+	fmt.Fprintf(&buf, "func (u %s) MarshalJSON() ([]byte, error) {\n", goName)
+	fmt.Fprintf(&buf, "\tswitch {\n")
+	for _, m := range members {
+		fmt.Fprintf(&buf, "\tcase u.%s != nil:\n", m.field)
+		fmt.Fprintf(&buf, "\t\treturn %s.Marshal(u.%s)\n", jsonPkg, m.field)
+	}
+	if !t.Closed {
+		fmt.Fprintf(&buf, "\tcase u.Unknown != nil:\n")
+		fmt.Fprintf(&buf, "\t\treturn %s.Marshal(u.Unknown)\n", jsonPkg)
+	}
+	fmt.Fprintf(&buf, "\t}\n")
+	fmt.Fprintf(&buf, "\treturn nil, %s.Errorf(\"no member set in %s\")\n", fmtPkg, goName)
+	fmt.Fprintf(&buf, "}\n\n")
+
+	fmt.Fprintf(&buf, "// UnmarshalJSON implements json.Unmarshaler. It always fails,\n")
+	fmt.Fprintf(&buf, "// because the JSON doesn't record the type of the message.\n")
+	fmt.Fprintf(&buf, "func (u *%s) UnmarshalJSON(data []byte) error {\n", goName)
+	fmt.Fprintf(&buf, "\treturn %s.Errorf(\"cannot unmarshal %s without its type; use UnmarshalMessage\")\n", fmtPkg, goName)
+	fmt.Fprintf(&buf, "}\n\n")
+
+	fmt.Fprintf(&buf, "// UnmarshalMessage decodes the JSON in data as a message\n")
+	fmt.Fprintf(&buf, "// of the given type, as found in the frame header.\n")
+	fmt.Fprintf(&buf, "func (u *%s) UnmarshalMessage(typ string, data []byte) error {\n", goName)
+	fmt.Fprintf(&buf, "\t*u = %s{}\n", goName)
+	fmt.Fprintf(&buf, "\tswitch typ {\n")
+	for _, m := range members {
+		// The frame header holds the type as it's written
+		// in the lexicon, which may be relative.
+		cases := []string{fmt.Sprintf("%q", m.typeID)}
+		if m.ref != m.typeID {
+			cases = append(cases, fmt.Sprintf("%q", m.ref))
+		}
+		fmt.Fprintf(&buf, "\tcase %s:\n", strings.Join(cases, ", "))
+		fmt.Fprintf(&buf, "\t\tu.%s = new(%s)\n", m.field, m.typ.expr)
+		fmt.Fprintf(&buf, "\t\treturn %s.Unmarshal(data, u.%s)\n", jsonPkg, m.field)
+	}
+	fmt.Fprintf(&buf, "\t}\n")
+	if t.Closed {
+		fmt.Fprintf(&buf, "\treturn %s.Errorf(\"unexpected message type %%q in %s\", typ)\n", fmtPkg, goName)
+	} else {
+		fmt.Fprintf(&buf, "\tu.Unknown = &%s{\n", f.lexgo("Unknown"))
+		fmt.Fprintf(&buf, "\t\tType: typ,\n")
+		fmt.Fprintf(&buf, "\t\tData: append(%s.RawMessage(nil), data...),\n", jsonPkg)
+		fmt.Fprintf(&buf, "\t}\n")
+		fmt.Fprintf(&buf, "\treturn nil\n")
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+	f.buf.Write(buf.Bytes())
+	return nil
+}
+
+// addDep records that the current lexicon refers to the given
+// definition in the package with the given import path.
+func (f *fileGen) addDep(pkg, typeID string) {
+	arc := gen.Arc{From: f.pkg, To: pkg}
+	if f.deps.Arcs[arc] == nil {
+		f.deps.Arcs[arc] = make(map[gen.Arc]bool)
+	}
+	f.deps.Arcs[arc][gen.Arc{From: f.currentDef, To: typeID}] = true
+}
+
+// lexgo returns a qualified reference to the given
+// name in the lexgo package.
+func (f *fileGen) lexgo(name string) string {
+	return f.importName(LexgoPkg) + "." + name
+}
+
+// importName adds an import of the package with the
+// given path and returns the name to use for it.
+func (f *fileGen) importName(ipath string) string {
+	if name, ok := f.imports[ipath]; ok {
+		return name
+	}
+	name := packageName(ipath)
+	if name == "defs" || f.importNames[name] {
+		// defs is commonly used and meaningless, so
+		// qualify it with the first element of its parent
+		// directory, as in feeddefs for feed.bsky.app/defs.
+		parent, _, _ := strings.Cut(path.Base(path.Dir(ipath)), ".")
+		name = naming.Unique(naming.Identifier(parent)+name, f.importNames)
+	}
+	f.importNames[name] = true
+	f.imports[ipath] = name
+	return name
+}
+
+// memberName returns the name of the union member field
+// for the given reference from the lexicon with the given ID.
+// For example, "app.bsky.embed.images#view" becomes EmbedImagesView.
+func memberName(fromID, ref string) string {
+	id, def := gen.SplitRef(fromID, ref)
+	if id == fromID {
+		return naming.Exported(def)
+	}
+	parts := strings.Split(id, ".")
+	name := naming.Exported(parts[len(parts)-2]) + naming.Exported(parts[len(parts)-1])
+	if def != "main" {
+		name += naming.Exported(def)
+	}
+	return name
+}
+
+// packageName returns the Go package name for the package
+// with the given import path.
+func packageName(ipath string) string {
+	name := naming.Identifier(path.Base(ipath))
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
+
+// writeComment writes text to buf as a Go comment
+// with each line prefixed by indent.
+func writeComment(buf *bytes.Buffer, indent, text string) {
+	for _, line := range naming.CommentLines(text) {
+		if line == "" {
+			fmt.Fprintf(buf, "%s//\n", indent)
+		} else {
+			fmt.Fprintf(buf, "%s// %s\n", indent, line)
+		}
+	}
+}
+
+// isStdPkg reports whether ipath looks like the path
+// of a standard library package.
+func isStdPkg(ipath string) bool {
+	first, _, _ := strings.Cut(ipath, "/")
+	return !strings.Contains(first, ".")
+}
+
+func mainDescription(schema *gen.Schema) string {
+	if t := schema.Defs["main"]; t != nil {
+		return t.Description
+	}
+	return ""
+}
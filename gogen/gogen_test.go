@@ -0,0 +1,114 @@
+package gogen_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/gogen"
+)
+
+func TestGenerateBuilds(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+	schemas, err := gen.ReadLexicons("../gen/testdata/lexicons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := gogen.Generate(gogen.Config{
+		PackageRoot: "lexicon.me",
+	}, schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "go.sum", sum)
+	writeFile(t, dir, "go.mod", []byte(`module lexicon.me
+
+go 1.21
+
+require github.com/rogpeppe/lexicue v0.0.0
+
+replace github.com/rogpeppe/lexicue => `+root+"\n"))
+	// The test lexicons com.example.a to com.example.d refer
+	// to one another cyclically, so must share a package.
+	shared := "lexicon.me/shared/example.com/a"
+	for _, f := range files {
+		writeFile(t, dir, f.Path, f.Source)
+		if strings.HasPrefix(filepath.Base(f.Path), "com.example.") && f.Package != shared {
+			t.Errorf("%s generated into %s, want %s", f.Path, f.Package, shared)
+		}
+	}
+	writeFile(t, dir, "marshal_test.go", []byte(`package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"lexicon.me/feed.bsky.app/post"
+	"lexicon.me/sync.atproto.com/subscribeRepos"
+)
+
+func TestRecordType(t *testing.T) {
+	data, err := json.Marshal(post.Post{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), `+"`"+`{"$type":"app.bsky.feed.post","createdAt":"","text":"hello"}`+"`"+`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMessageType(t *testing.T) {
+	// Subscription messages have their type in the frame
+	// header, so they're encoded without a $type field.
+	const msg = `+"`"+`{"did":"did:plc:1234","seq":1,"time":"2024-01-01T00:00:00Z"}`+"`"+`
+	var m subscribeRepos.SubscribeReposMessage
+	if err := m.UnmarshalMessage("#identity", []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if m.Identity == nil || m.Identity.Did != "did:plc:1234" {
+		t.Fatalf("unexpected message %#v", m)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != msg {
+		t.Errorf("got %s, want %s", got, msg)
+	}
+	if err := json.Unmarshal([]byte(msg), &m); err == nil {
+		t.Errorf("unexpected success unmarshaling message without its type")
+	}
+}
+`))
+	cmd := exec.Command("go", "test", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not build: %v\n%s", err, out)
+	}
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
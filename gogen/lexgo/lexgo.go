@@ -0,0 +1,106 @@
+// Package lexgo holds types and functions used by the Go code
+// generated by the gogen package.
+package lexgo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CIDLink represents a lexicon cid-link value, which
+// is encoded in JSON as {"$link": cid}.
+type CIDLink struct {
+	Link string `json:"$link"`
+}
+
+// Blob represents a lexicon blob value.
+type Blob struct {
+	Ref      CIDLink `json:"ref"`
+	MimeType string  `json:"mimeType"`
+	Size     int64   `json:"size"`
+}
+
+// MarshalJSON implements json.Marshaler by adding
+// the "blob" $type field.
+func (b Blob) MarshalJSON() ([]byte, error) {
+	type plainBlob Blob
+	return json.Marshal(struct {
+		Type string `json:"$type"`
+		plainBlob
+	}{"blob", plainBlob(b)})
+}
+
+// Bytes represents a lexicon bytes value, which is encoded
+// in JSON as {"$bytes": base64data}.
+type Bytes []byte
+
+type jsonBytes struct {
+	Bytes string `json:"$bytes"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonBytes{base64.RawStdEncoding.EncodeToString(b)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var jb jsonBytes
+	if err := json.Unmarshal(data, &jb); err != nil {
+		return err
+	}
+	// Be lenient about padding.
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(jb.Bytes, "="))
+	if err != nil {
+		return err
+	}
+	*b = data
+	return nil
+}
+
+// Unknown holds a member of an open union whose $type
+// is not one of those listed in the lexicon.
+type Unknown struct {
+	// Type holds the value of the $type field, or for
+	// a subscription message, the type from the frame header.
+	Type string
+
+	// Data holds the JSON encoding of the whole value.
+	Data json.RawMessage
+}
+
+// MarshalJSON implements json.Marshaler by returning u.Data.
+func (u Unknown) MarshalJSON() ([]byte, error) {
+	return u.Data, nil
+}
+
+// TypeOf returns the value of the $type field in the JSON object in data.
+func TypeOf(data []byte) (string, error) {
+	var typed struct {
+		Type *string `json:"$type"`
+	}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return "", err
+	}
+	if typed.Type == nil {
+		return "", fmt.Errorf("no $type field found")
+	}
+	return *typed.Type, nil
+}
+
+// MarshalTyped returns the JSON encoding of v, which must
+// encode as a JSON object, with its $type field set to typ.
+func MarshalTyped(typ string, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("cannot set $type on %T: %v", v, err)
+	}
+	fields["$type"], _ = json.Marshal(typ)
+	return json.Marshal(fields)
+}
@@ -0,0 +1,81 @@
+// Package naming provides helpers for choosing the identifiers
+// used in code generated from lexicons.
+package naming
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// TypeNames returns the exported type name for each definition
+// in schema, avoiding the names in reserved. The main definition
+// is named after the lexicon itself.
+func TypeNames(schema *gen.Schema, reserved ...string) map[string]string {
+	names := make(map[string]string)
+	used := make(map[string]bool)
+	for _, name := range reserved {
+		used[name] = true
+	}
+	for _, name := range sorted.Keys(schema.Defs) {
+		if name != "main" {
+			names[name] = Unique(Exported(name), used)
+		}
+	}
+	if _, ok := schema.Defs["main"]; ok {
+		names["main"] = Exported(schema.ID[strings.LastIndex(schema.ID, ".")+1:])
+		if used[names["main"]] {
+			names["main"] = Unique("Main", used)
+		}
+	}
+	return names
+}
+
+// Exported returns a capitalized identifier for the given name.
+func Exported(name string) string {
+	name = Identifier(name)
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// Identifier returns s with any characters that aren't valid
+// in an identifier replaced by underscores. The result is valid
+// in both Go and TypeScript.
+func Identifier(s string) string {
+	if s == "" {
+		return "_"
+	}
+	r := []rune(s)
+	for i, c := range r {
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			r[i] = '_'
+		}
+	}
+	return string(r)
+}
+
+// Unique returns name, with a numeric suffix if needed
+// to make it distinct from the names in used, and adds the
+// result to used.
+func Unique(name string, used map[string]bool) string {
+	n := name
+	for i := 1; used[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	used[n] = true
+	return n
+}
+
+// CommentLines returns the lines of text, without any
+// surrounding white space, for writing as a comment.
+// It returns nil if text is empty.
+func CommentLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(text), "\n")
+}
@@ -0,0 +1,15 @@
+// Package sorted provides helpers for iterating over
+// maps in a deterministic order.
+package sorted
+
+import "sort"
+
+// Keys returns the keys of m in sorted order.
+func Keys[V any](m map[string]V) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,107 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// lexicueComponentPrefix holds the prefix of the names
+// of the lexicue definitions in the result of Components.
+const lexicueComponentPrefix = "lexicue."
+
+// ComponentName returns the name of the component holding the schema
+// for the given lexicon definition in the result of Components,
+// for example "app.bsky.feed.defs.postView" for the postView
+// definition in app.bsky.feed.defs.
+//
+// The schemas for the parts of XRPC definitions are named by adding
+// a hyphen followed by the name of the part, as in
+// "app.bsky.feed.getTimeline-output". The parts are named
+// parameters, input, output, message and errorBody.
+func ComponentName(id, def string) string {
+	return strings.Replace(gen.TypeName(id, def), "#", ".", 1)
+}
+
+// Components returns the JSON-encoded schemas for all the definitions
+// in the given lexicons, and for the lexicue definitions they refer to,
+// keyed by component name (see ComponentName). It's intended for
+// embedding the schemas in another document, such as an OpenAPI
+// specification. References between the schemas are formed by adding
+// refPrefix to the component name, so for OpenAPI refPrefix
+// would be "#/components/schemas/".
+//
+// All the lexicons referred to must be included.
+func Components(refPrefix string, schemas []*gen.Schema) (map[string]json.RawMessage, error) {
+	g := &generator{
+		schemas:   make(map[string]*gen.Schema),
+		lexicue:   make(map[string]bool),
+		refPrefix: refPrefix,
+	}
+	for _, schema := range schemas {
+		g.schemas[schema.ID] = schema
+	}
+	components := make(map[string]schema)
+	add := func(name string, s schema) error {
+		if _, ok := components[name]; ok {
+			return fmt.Errorf("duplicate component name %q", name)
+		}
+		components[name] = s
+		return nil
+	}
+	var errs []string
+	for _, id := range sorted.Keys(g.schemas) {
+		lex := g.schemas[id]
+		f := &fileGen{
+			generator: g,
+			schema:    lex,
+		}
+		for _, name := range sorted.Keys(lex.Defs) {
+			f.currentDef = name
+			t := lex.Defs[name]
+			s, err := f.def(t)
+			if err == nil {
+				switch t.Type {
+				case "query", "procedure", "subscription":
+					parts := s["$defs"].(schema)
+					for _, part := range sorted.Keys(parts) {
+						if err = add(ComponentName(id, name)+"-"+part, parts[part].(schema)); err != nil {
+							break
+						}
+					}
+				default:
+					err = add(ComponentName(id, name), s)
+				}
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: bad definition %q: %v", id, name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	names := make([]string, 0, len(g.lexicue))
+	for _, name := range sorted.Keys(g.lexicue) {
+		names = append(names, "#"+name)
+	}
+	defs, err := lexicueDefs(names, refPrefix+lexicueComponentPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for name, s := range defs {
+		components[lexicueComponentPrefix+name] = s
+	}
+	result := make(map[string]json.RawMessage)
+	for name, s := range components {
+		data, err := marshalCompact(s)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = data
+	}
+	return result, nil
+}
@@ -0,0 +1,739 @@
+// Package jsonschema generates JSON Schema (draft 2020-12)
+// documents from atproto lexicon schemas.
+//
+// Each lexicon is generated into its own schema document, laid out
+// in the same way as the generated CUE (see gen.PackagePath), so
+// the schema for app.bsky.feed.post is in feed.bsky.app/post.json.
+// Each lexicon definition becomes an entry in the document's $defs,
+// and references between lexicons become relative $refs.
+// The string formats and the blob and cid-link shapes are translated
+// from the lexicue CUE package into lexicue.json, which is generated
+// alongside the other documents.
+//
+// XRPC definitions have no schema of their own; instead their
+// $defs entry holds the schemas for the parts of the endpoint in
+// its own $defs, named parameters, input, output, message and
+// errorBody, as used by the generated CUE.
+//
+// Lexicon string lengths are measured in UTF-8 bytes and grapheme
+// clusters, which JSON Schema cannot express, so those limits are
+// recorded with the custom keywords minBytes, maxBytes and maxGraphemes.
+// The standard minLength and maxLength keywords hold the closest limits
+// in code points that admit every valid string; they are exact for ASCII.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// LexicueFile holds the path of the document holding the
+// definitions that all the generated documents depend on.
+const LexicueFile = "lexicue.json"
+
+// Config holds configuration for Generate.
+type Config struct {
+	// BaseURI holds the absolute URI that the $id
+	// of each generated document is relative to,
+	// for example "https://example.com/lexicons/".
+	BaseURI string
+}
+
+// File holds a generated JSON Schema document.
+type File struct {
+	// Path holds the slash-separated path of the file
+	// relative to the output directory.
+	Path string
+
+	// Source holds the JSON source.
+	Source []byte
+}
+
+// schema holds a JSON Schema object.
+type schema map[string]any
+
+// keywordOrder holds the keywords that are written first, in order,
+// when marshaling a schema. Other keywords are written in sorted
+// order, except $defs, which always comes last.
+var keywordOrder = []string{"$schema", "$id", "$ref", "title", "description", "type"}
+
+// MarshalJSON implements json.Marshaler by writing
+// the keywords in a conventional order.
+func (s schema) MarshalJSON() ([]byte, error) {
+	var keys []string
+	for _, k := range keywordOrder {
+		if _, ok := s[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range sorted.Keys(s) {
+		if !slices.Contains(keywordOrder, k) && k != "$defs" {
+			keys = append(keys, k)
+		}
+	}
+	if _, ok := s["$defs"]; ok {
+		keys = append(keys, "$defs")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		data, err := marshalCompact(s[k])
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%q:%s", k, data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Generate generates a JSON Schema document for each of the
+// given lexicons, followed by the document holding the shared
+// definitions. All the lexicons referred to must be included.
+func Generate(cfg Config, schemas []*gen.Schema) ([]*File, error) {
+	if cfg.BaseURI != "" && !strings.HasSuffix(cfg.BaseURI, "/") {
+		cfg.BaseURI += "/"
+	}
+	g := &generator{
+		cfg:     cfg,
+		schemas: make(map[string]*gen.Schema),
+		lexicue: make(map[string]bool),
+	}
+	for _, schema := range schemas {
+		g.schemas[schema.ID] = schema
+	}
+	var files []*File
+	var errs []string
+	for _, id := range sorted.Keys(g.schemas) {
+		f, err := g.generate(g.schemas[id])
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", id, err))
+			continue
+		}
+		files = append(files, f)
+	}
+	f, err := g.lexicueFile()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, f)
+	if len(errs) > 0 {
+		return files, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return files, nil
+}
+
+type generator struct {
+	cfg     Config
+	schemas map[string]*gen.Schema
+
+	// lexicue holds the names of the lexicue
+	// definitions referred to.
+	lexicue map[string]bool
+
+	// refPrefix holds the prefix of references to components
+	// when generating components (see Components). When it's
+	// empty, references are relative to the generated documents.
+	refPrefix string
+}
+
+// fileGen holds the state for generating the
+// JSON Schema document for a single lexicon.
+type fileGen struct {
+	*generator
+	schema *gen.Schema
+	path   string
+
+	// currentDef holds the name of the definition being generated.
+	currentDef string
+}
+
+func (g *generator) generate(lex *gen.Schema) (*File, error) {
+	p, err := documentPath(lex.ID)
+	if err != nil {
+		return nil, err
+	}
+	f := &fileGen{
+		generator: g,
+		schema:    lex,
+		path:      p,
+	}
+	defs := make(schema)
+	for _, name := range sorted.Keys(lex.Defs) {
+		f.currentDef = name
+		s, err := f.def(lex.Defs[name])
+		if err != nil {
+			return nil, fmt.Errorf("bad definition %q: %v", name, err)
+		}
+		defs[name] = s
+	}
+	doc := f.document()
+	doc["title"] = lex.ID
+	if main := lex.Defs["main"]; main != nil {
+		if main.Description != "" {
+			doc["description"] = main.Description
+		}
+		switch main.Type {
+		case "query", "procedure", "subscription":
+		default:
+			// The document itself validates instances
+			// of the main definition.
+			doc["$ref"] = "#/$defs/main"
+		}
+	}
+	doc["$defs"] = defs
+	data, err := marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		Path:   p,
+		Source: data,
+	}, nil
+}
+
+// lexicueFile returns the document holding the lexicue
+// definitions referred to by the generated documents.
+func (g *generator) lexicueFile() (*File, error) {
+	names := make([]string, 0, len(g.lexicue))
+	for _, name := range sorted.Keys(g.lexicue) {
+		names = append(names, "#"+name)
+	}
+	defs, err := lexicueDefs(names, "#/$defs/")
+	if err != nil {
+		return nil, err
+	}
+	doc := (&fileGen{generator: g, path: LexicueFile}).document()
+	doc["title"] = gen.LexicuePkg
+	doc["$defs"] = defs
+	data, err := marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		Path:   LexicueFile,
+		Source: data,
+	}, nil
+}
+
+// document returns the skeleton of the JSON Schema document for f.
+func (f *fileGen) document() schema {
+	doc := schema{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+	}
+	if f.cfg.BaseURI != "" {
+		doc["$id"] = f.cfg.BaseURI + f.path
+	}
+	return doc
+}
+
+// def returns the schema for the given lexicon definition.
+func (f *fileGen) def(t *gen.TypeSchema) (schema, error) {
+	var s schema
+	var err error
+	switch t.Type {
+	case "query", "procedure", "subscription":
+		s, err = f.xrpcDefs(t)
+	case "record":
+		s, err = f.objectType(t.Record, true)
+		if err == nil {
+			// Records are always tagged with their type.
+			s["properties"].(schema)["$type"] = schema{"const": f.schema.ID}
+			s["required"] = sortedUnique(append([]string{"$type"}, t.Record.Required...))
+		}
+	case "token":
+		s = schema{"const": gen.TypeName(f.schema.ID, f.currentDef)}
+	case "object":
+		s, err = f.objectType(t, false)
+		if err == nil {
+			// Any object may be tagged with its type, and
+			// it must be when it's a member of a union.
+			s["properties"].(schema)["$type"] = schema{"const": gen.TypeName(f.schema.ID, f.currentDef)}
+		}
+	case "image", "video", "audio":
+		s = f.lexicueRef("#" + t.Type)
+		props := make(schema)
+		addMaximum(props, "width", t.MaxWidth)
+		addMaximum(props, "height", t.MaxHeight)
+		addMaximum(props, "length", t.MaxLength)
+		addMaximum(props, "size", t.MaxSize)
+		if len(props) > 0 {
+			s["properties"] = props
+		}
+	default:
+		s, err = f.jsonType(t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Description != "" && s["description"] == nil {
+		s["description"] = t.Description
+	}
+	return s, nil
+}
+
+// xrpcDefs returns a schema holding the schemas for the parts of an
+// XRPC endpoint in its $defs. The schema itself accepts any value.
+func (f *fileGen) xrpcDefs(t *gen.TypeSchema) (schema, error) {
+	defs := make(schema)
+	if t.Parameters != nil {
+		s, err := f.objectType(t.Parameters, true)
+		if err != nil {
+			return nil, fmt.Errorf("bad parameters: %v", err)
+		}
+		defs["parameters"] = s
+	}
+	for _, body := range []struct {
+		name string
+		body *gen.BodyType
+	}{
+		{"input", t.Input},
+		{"output", t.Output},
+	} {
+		if body.body == nil || body.body.Schema == nil {
+			continue
+		}
+		s, err := f.jsonType(body.body.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %v", body.name, err)
+		}
+		defs[body.name] = s
+	}
+	if t.Message != nil && t.Message.Schema != nil {
+		var s schema
+		var err error
+		if t.Message.Schema.Type == "union" {
+			// Subscription messages carry their type in the
+			// frame header rather than in a $type field,
+			// so there's nothing to discriminate on.
+			s, err = f.unionType(t.Message.Schema, false)
+		} else {
+			s, err = f.jsonType(t.Message.Schema)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad message: %v", err)
+		}
+		defs["message"] = s
+	}
+	defs["errorBody"] = f.errorBody(t.Errors)
+	return schema{"$defs": defs}, nil
+}
+
+// errorBody returns the schema for the body of an error response
+// from an XRPC endpoint with the given errors.
+func (f *fileGen) errorBody(errs []gen.Error) schema {
+	errorSchema := f.lexicueRef("#xrpcGenericError")
+	if len(errs) > 0 {
+		var names []any
+		for _, e := range errs {
+			names = append(names, e.Name)
+		}
+		errorSchema = anyOf(errorSchema, schema{"enum": names})
+	}
+	return schema{
+		"type": "object",
+		"properties": schema{
+			"error":   errorSchema,
+			"message": schema{"type": "string"},
+		},
+		"required":             []string{"error"},
+		"additionalProperties": false,
+	}
+}
+
+// objectType returns the schema for the object or params type t.
+// Lexicon objects are closed, apart from record bodies and XRPC
+// parameters, so other properties are allowed only when open is true.
+func (f *fileGen) objectType(t *gen.TypeSchema, open bool) (schema, error) {
+	props := make(schema)
+	for _, name := range sorted.Keys(t.Properties) {
+		pt := t.Properties[name]
+		s, err := f.jsonType(pt)
+		if err != nil {
+			return nil, fmt.Errorf("bad property %q: %v", name, err)
+		}
+		if slices.Contains(t.Nullable, name) {
+			s = anyOf(s, schema{"type": "null"})
+		}
+		if pt.Description != "" {
+			s["description"] = pt.Description
+		}
+		props[name] = s
+	}
+	s := schema{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(t.Required) > 0 {
+		s["required"] = sortedUnique(append([]string{}, t.Required...))
+	}
+	if !open {
+		s["additionalProperties"] = false
+	}
+	return s, nil
+}
+
+// jsonType returns the schema for a non-definition lexicon type.
+func (f *fileGen) jsonType(t *gen.TypeSchema) (schema, error) {
+	switch t.Type {
+	case "ref":
+		return f.refType(t.Ref)
+	case "union":
+		return f.unionType(t, true)
+	case "object", "params":
+		return f.objectType(t, false)
+	case "blob":
+		s := f.lexicueRef("#blob")
+		props := make(schema)
+		var required []string
+		if t.MaxSize != nil {
+			addMaximum(props, "size", t.MaxSize)
+			required = append(required, "size")
+		}
+		if len(t.Accept) > 0 {
+			var accept []schema
+			for _, mimeType := range t.Accept {
+				if prefix, ok := strings.CutSuffix(mimeType, "*"); ok {
+					accept = append(accept, schema{"pattern": "^" + quoteMeta(prefix)})
+				} else {
+					accept = append(accept, schema{"const": mimeType})
+				}
+			}
+			props["mimeType"] = anyOf(accept...)
+			required = append(required, "mimeType")
+		}
+		if len(props) > 0 {
+			s["properties"] = props
+			s["required"] = required
+		}
+		return s, nil
+	case "cid-link":
+		return f.lexicueRef("#cidLink"), nil
+	case "bytes":
+		return schema{
+			"type": "object",
+			"properties": schema{
+				"$bytes": schema{
+					"type":            "string",
+					"contentEncoding": "base64",
+				},
+			},
+			"required":             []string{"$bytes"},
+			"additionalProperties": false,
+		}, nil
+	case "unknown":
+		return schema{}, nil
+	case "array":
+		if t.Items == nil {
+			return nil, fmt.Errorf("array has no items")
+		}
+		items, err := f.jsonType(t.Items)
+		if err != nil {
+			return nil, err
+		}
+		s := schema{
+			"type":  "array",
+			"items": items,
+		}
+		if t.MinLength != nil {
+			s["minItems"] = *t.MinLength
+		}
+		if t.MaxLength != nil {
+			s["maxItems"] = *t.MaxLength
+		}
+		return s, nil
+	case "boolean", "integer", "number":
+		if t.Const != nil {
+			return schema{"const": t.Const}, nil
+		}
+		s := schema{"type": t.Type}
+		if t.Enum != nil {
+			if len(t.Enum) == 0 {
+				return nil, fmt.Errorf("empty enum")
+			}
+			s["enum"] = t.Enum
+		}
+		if t.Minimum != nil {
+			s["minimum"] = t.Minimum
+		}
+		if t.Maximum != nil {
+			s["maximum"] = t.Maximum
+		}
+		if t.Default != nil {
+			s["default"] = t.Default
+		}
+		return s, nil
+	case "string":
+		return f.stringType(t)
+	}
+	return nil, fmt.Errorf("unknown type %q", t.Type)
+}
+
+// stringType returns the schema for the string type t.
+func (f *fileGen) stringType(t *gen.TypeSchema) (schema, error) {
+	if t.Const != nil {
+		return schema{"const": t.Const}, nil
+	}
+	s := schema{"type": "string"}
+	if t.Enum != nil {
+		if len(t.Enum) == 0 {
+			return nil, fmt.Errorf("empty enum")
+		}
+		s["enum"] = t.Enum
+	}
+	if t.Format != "" {
+		def, ok := gen.StringFormats[t.Format]
+		if !ok {
+			return nil, fmt.Errorf("unknown string format %q", t.Format)
+		}
+		s = allOf(s, f.lexicueRef(def))
+	}
+	if t.MinLength != nil {
+		// A UTF-8 encoded code point is at most 4 bytes long.
+		s["minLength"] = (*t.MinLength + 3) / 4
+		s["minBytes"] = *t.MinLength
+	}
+	if t.MaxLength != nil {
+		s["maxLength"] = *t.MaxLength
+		s["maxBytes"] = *t.MaxLength
+	}
+	if t.MaxGraphemes != nil {
+		s["maxGraphemes"] = *t.MaxGraphemes
+	}
+	if len(t.KnownValues) > 0 {
+		s["knownValues"] = t.KnownValues
+	}
+	if t.Default != nil {
+		s["default"] = t.Default
+	}
+	return s, nil
+}
+
+// refType returns the schema for a reference to the definition with the given name.
+func (f *fileGen) refType(ref string) (schema, error) {
+	id, def, t, err := gen.ResolveRef(f.schemas, f.schema.ID, ref)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Type {
+	case "query", "procedure", "subscription":
+		return nil, fmt.Errorf("cannot refer to %s definition %q", t.Type, ref)
+	}
+	if f.refPrefix != "" {
+		return schema{"$ref": f.refPrefix + ComponentName(id, def)}, nil
+	}
+	if id == f.schema.ID {
+		return schema{"$ref": "#/$defs/" + def}, nil
+	}
+	p, err := documentPath(id)
+	if err != nil {
+		return nil, err
+	}
+	return schema{"$ref": relRef(f.path, p) + "#/$defs/" + def}, nil
+}
+
+// unionType returns the schema for the members of the given union.
+// If tagged is true, each member is required to have a $type field
+// holding its type name, and unless the union is closed, objects
+// of any other type are allowed too.
+func (f *fileGen) unionType(t *gen.TypeSchema, tagged bool) (schema, error) {
+	if len(t.Refs) == 0 {
+		return nil, fmt.Errorf("no elements in union")
+	}
+	var members []schema
+	var typeNames []any
+	for _, ref := range t.Refs {
+		s, err := f.refType(ref)
+		if err != nil {
+			return nil, err
+		}
+		if tagged {
+			typeName := gen.TypeName(gen.SplitRef(f.schema.ID, ref))
+			typeNames = append(typeNames, typeName)
+			s["properties"] = schema{"$type": schema{"const": typeName}}
+			s["required"] = []string{"$type"}
+		}
+		members = append(members, s)
+	}
+	if tagged && !t.Closed {
+		// Unions are open by default, so allow any object
+		// with a type that isn't one of those listed.
+		s := f.lexicueRef("#unknownObject")
+		s["properties"] = schema{"$type": schema{"not": schema{"enum": typeNames}}}
+		members = append(members, s)
+	}
+	return anyOf(members...), nil
+}
+
+// lexicueRef returns a reference to the lexicue definition
+// with the given name, for example "#blob".
+func (f *fileGen) lexicueRef(name string) schema {
+	f.lexicue[name[1:]] = true
+	if f.refPrefix != "" {
+		return schema{"$ref": f.refPrefix + lexicueComponentPrefix + name[1:]}
+	}
+	return schema{"$ref": relRef(f.path, LexicueFile) + "#/$defs/" + name[1:]}
+}
+
+// documentPath returns the path of the document generated
+// for the lexicon with the given ID.
+func documentPath(id string) (string, error) {
+	p, err := gen.PackagePath("", id)
+	if err != nil {
+		return "", err
+	}
+	return p + ".json", nil
+}
+
+// relRef returns the relative URI reference to the
+// document at path to from the document at path from.
+func relRef(from, to string) string {
+	if path.Dir(from) == path.Dir(to) {
+		return path.Base(to)
+	}
+	return strings.Repeat("../", strings.Count(from, "/")) + to
+}
+
+func addMaximum(props schema, name string, n *int) {
+	if n != nil {
+		props[name] = schema{"maximum": *n}
+	}
+}
+
+// allOf returns a schema that requires all the given schemas
+// to match. Schemas without conflicting keywords are merged.
+func allOf(schemas ...schema) schema {
+	result := make(schema)
+	var rest []schema
+	for _, s := range schemas {
+		if not1, ok := result["not"].(schema); ok {
+			if not2, ok := s["not"].(schema); ok {
+				// Neither A nor B is the same as not (A or B).
+				s = copySchema(s)
+				s["not"] = anyOf(not1, not2)
+				delete(result, "not")
+			}
+		}
+		if !mergeable(result, s) {
+			rest = append(rest, s)
+			continue
+		}
+		for k, v := range s {
+			result[k] = v
+		}
+	}
+	if len(rest) > 0 {
+		result["allOf"] = rest
+	}
+	return result
+}
+
+func copySchema(s schema) schema {
+	s1 := make(schema)
+	for k, v := range s {
+		s1[k] = v
+	}
+	return s1
+}
+
+func mergeable(s1, s2 schema) bool {
+	for k, v := range s2 {
+		if v1, ok := s1[k]; ok && !reflect.DeepEqual(v, v1) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyOf returns a schema that requires at least one of the given
+// schemas to match. A choice between constants is expressed as an enum.
+func anyOf(schemas ...schema) schema {
+	var members []schema
+	for _, s := range schemas {
+		if choices, ok := s["anyOf"].([]schema); ok && len(s) == 1 {
+			members = append(members, choices...)
+		} else if values, ok := s["enum"].([]any); ok && len(s) == 1 {
+			for _, v := range values {
+				members = append(members, schema{"const": v})
+			}
+		} else {
+			members = append(members, s)
+		}
+	}
+	if len(members) == 1 {
+		return members[0]
+	}
+	var values []any
+	for _, m := range members {
+		v, ok := m["const"]
+		if !ok || len(m) != 1 {
+			return schema{"anyOf": members}
+		}
+		values = append(values, v)
+	}
+	return schema{"enum": values}
+}
+
+// quoteMeta is like regexp.QuoteMeta, but only quotes
+// characters that are special in ECMA 262 regular expressions,
+// as used by JSON Schema.
+func quoteMeta(s string) string {
+	var buf strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`\^$.|?*+()[]{}/`, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// marshal returns the indented JSON encoding of s.
+func marshal(s schema) ([]byte, error) {
+	data, err := marshalCompact(s)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "\t"); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// marshalCompact is like json.Marshal but doesn't escape HTML
+// characters, which are common in patterns.
+func marshalCompact(x any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(x); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func sortedUnique(xs []string) []string {
+	sort.Strings(xs)
+	j := 0
+	for i, x := range xs {
+		if i == 0 || x != xs[j-1] {
+			xs[j] = x
+			j++
+		}
+	}
+	return xs[:j]
+}
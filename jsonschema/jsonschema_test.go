@@ -0,0 +1,280 @@
+package jsonschema_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	validator "github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/jsonschema"
+)
+
+const baseURI = "https://example.com/lexicons/"
+
+const testLexicon = `{
+	"lexicon": 1,
+	"id": "com.example.check",
+	"defs": {
+		"main": {
+			"type": "record",
+			"key": "tid",
+			"record": {
+				"type": "object",
+				"properties": {
+					"did": {"type": "string", "format": "did"},
+					"handle": {"type": "string", "format": "handle"},
+					"createdAt": {"type": "string", "format": "datetime"},
+					"short": {"type": "string", "maxLength": 4},
+					"long": {"type": "string", "minLength": 4},
+					"few": {"type": "string", "maxGraphemes": 2},
+					"avatar": {"type": "blob", "accept": ["image/*"], "maxSize": 1000},
+					"file": {"type": "blob"},
+					"open": {"type": "union", "refs": ["#a", "#b"]},
+					"closed": {"type": "union", "refs": ["#a", "#b"], "closed": true}
+				}
+			}
+		},
+		"a": {
+			"type": "object",
+			"required": ["x"],
+			"properties": {"x": {"type": "string"}}
+		},
+		"b": {
+			"type": "object",
+			"required": ["y"],
+			"properties": {"y": {"type": "integer"}}
+		}
+	}
+}`
+
+const testCID = "QmZ4tDuvesekSs4qM5ZBKpXiZGun7S2CYtEZRB3DYXkjGx"
+
+var agreementTests = []struct {
+	testName string
+	// record holds the properties of the record,
+	// which are checked against the main definition.
+	record string
+	valid  bool
+	// cueOnly holds whether the instance is invalid only because of
+	// a limit that JSON Schema can't express, so that the JSON Schema
+	// accepts it even though the CUE doesn't.
+	cueOnly bool
+}{{
+	testName: "Empty",
+	record:   `{}`,
+	valid:    true,
+}, {
+	testName: "ValidFormats",
+	record:   `{"did": "did:plc:z72i7hdynmk6r22z27h6tvur", "handle": "alice.bsky.social", "createdAt": "2023-04-05T06:07:08.123Z"}`,
+	valid:    true,
+}, {
+	testName: "BadDID",
+	record:   `{"did": "plc:z72i7hdynmk6r22z27h6tvur"}`,
+}, {
+	testName: "BadHandle",
+	record:   `{"handle": "alice"}`,
+}, {
+	testName: "BadDatetime",
+	record:   `{"createdAt": "yesterday"}`,
+}, {
+	testName: "MaxBytesASCII",
+	record:   `{"short": "abcd"}`,
+	valid:    true,
+}, {
+	testName: "TooManyBytesASCII",
+	record:   `{"short": "abcde"}`,
+}, {
+	testName: "TooManyBytesMultibyte",
+	// Three code points but six bytes.
+	record:  `{"short": "ééé"}`,
+	cueOnly: true,
+}, {
+	testName: "TooFewBytesASCII",
+	// The minimum length in code points admits any
+	// string that might have enough bytes.
+	record:  `{"long": "abc"}`,
+	cueOnly: true,
+}, {
+	testName: "MinBytesMultibyte",
+	// Two code points but four bytes.
+	record: `{"long": "éé"}`,
+	valid:  true,
+}, {
+	testName: "MaxGraphemes",
+	// Four code points but two grapheme clusters.
+	record: `{"few": "éé"}`,
+	valid:  true,
+}, {
+	testName: "TooManyGraphemes",
+	record:   `{"few": "abc"}`,
+	cueOnly:  true,
+}, {
+	testName: "Blob",
+	record:   `{"avatar": {"$type": "blob", "ref": {"$link": "` + testCID + `"}, "mimeType": "image/png", "size": 1000}}`,
+	valid:    true,
+}, {
+	testName: "BlobTooLarge",
+	record:   `{"avatar": {"$type": "blob", "ref": {"$link": "` + testCID + `"}, "mimeType": "image/png", "size": 1001}}`,
+}, {
+	testName: "BlobNotAccepted",
+	record:   `{"avatar": {"$type": "blob", "ref": {"$link": "` + testCID + `"}, "mimeType": "text/plain", "size": 10}}`,
+}, {
+	testName: "BlobMissingRef",
+	record:   `{"file": {"$type": "blob", "mimeType": "text/plain", "size": 10}}`,
+}, {
+	testName: "BlobBadLink",
+	record:   `{"file": {"$type": "blob", "ref": {"$link": "nope"}, "mimeType": "text/plain", "size": 10}}`,
+}, {
+	testName: "LegacyBlob",
+	record:   `{"file": {"cid": "` + testCID + `", "mimeType": "text/plain"}}`,
+	valid:    true,
+}, {
+	testName: "LegacyBlobMissingMimeType",
+	record:   `{"file": {"cid": "` + testCID + `"}}`,
+}, {
+	testName: "LegacyBlobWithSizeLimit",
+	// A legacy blob has no size, so it can't satisfy maxSize.
+	record: `{"avatar": {"cid": "` + testCID + `", "mimeType": "image/png"}}`,
+}, {
+	testName: "OpenUnionMember",
+	record:   `{"open": {"$type": "com.example.check#a", "x": "hello"}}`,
+	valid:    true,
+}, {
+	testName: "OpenUnionInvalidMember",
+	record:   `{"open": {"$type": "com.example.check#b", "y": "hello"}}`,
+}, {
+	testName: "OpenUnionUnknownType",
+	record:   `{"open": {"$type": "com.example.other", "z": true}}`,
+	valid:    true,
+}, {
+	testName: "OpenUnionMissingType",
+	record:   `{"open": {"x": "hello"}}`,
+}, {
+	testName: "ClosedUnionMember",
+	record:   `{"closed": {"$type": "com.example.check#b", "y": 1}}`,
+	valid:    true,
+}, {
+	testName: "ClosedUnionUnknownType",
+	record:   `{"closed": {"$type": "com.example.other", "z": true}}`,
+}}
+
+// TestAgreesWithCUE checks that the generated JSON Schema
+// gives the same results as the generated CUE.
+func TestAgreesWithCUE(t *testing.T) {
+	var schema gen.Schema
+	if err := json.Unmarshal([]byte(testLexicon), &schema); err != nil {
+		t.Fatal(err)
+	}
+	jsonSchema := compileJSONSchema(t, &schema, "com.example.check")
+
+	ctx := cuecontext.New()
+	cueSchema := cueRecord(t, ctx, "com.example.check")
+
+	for _, test := range agreementTests {
+		t.Run(test.testName, func(t *testing.T) {
+			var record map[string]any
+			if err := json.Unmarshal([]byte(test.record), &record); err != nil {
+				t.Fatal(err)
+			}
+			record["$type"] = "com.example.check"
+			data, err := json.Marshal(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cueErr := gen.ValidateInstance(ctx, cueSchema, data, "instance.json")
+			if got := cueErr == nil; got != test.valid {
+				t.Errorf("CUE: got valid %v, want %v (error: %v)", got, test.valid, cueErr)
+			}
+			jsonErr := jsonSchema.Validate(record)
+			if got, want := jsonErr == nil, test.valid || test.cueOnly; got != want {
+				t.Errorf("JSON Schema: got valid %v, want %v (error: %v)", got, want, jsonErr)
+			}
+		})
+	}
+}
+
+// compileJSONSchema generates JSON Schema for the given lexicon
+// and returns the schema for the lexicon with the given ID.
+func compileJSONSchema(t *testing.T, schema *gen.Schema, id string) *validator.Schema {
+	t.Helper()
+	files, err := jsonschema.Generate(jsonschema.Config{
+		BaseURI: baseURI,
+	}, []*gen.Schema{schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := validator.NewCompiler()
+	c.Draft = validator.Draft2020
+	c.AssertFormat = true
+	for _, f := range files {
+		if err := c.AddResource(baseURI+f.Path, bytes.NewReader(f.Source)); err != nil {
+			t.Fatalf("%s: %v", f.Path, err)
+		}
+	}
+	p, err := gen.PackagePath("", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Compile(baseURI + p + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// cueRecord returns the CUE for the record of the lexicon
+// in testLexicon, which has the given ID.
+func cueRecord(t *testing.T, ctx *cue.Context, id string) cue.Value {
+	t.Helper()
+	g, err := gen.New(gen.Config{
+		ModuleRoot: "lexicon.me/defs",
+		UseMap:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := g.GenerateJSON([]byte(testLexicon), "lexicon.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := g.Load(ctx, []*gen.File{f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := l.Part(id, "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestGenerateDocuments(t *testing.T) {
+	schemas, err := gen.ReadLexicons("../gen/testdata/lexicons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := jsonschema.Generate(jsonschema.Config{
+		BaseURI: baseURI,
+	}, schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every generated document must be a valid schema,
+	// with all its references resolved.
+	c := validator.NewCompiler()
+	c.Draft = validator.Draft2020
+	for _, f := range files {
+		if err := c.AddResource(baseURI+f.Path, bytes.NewReader(f.Source)); err != nil {
+			t.Fatalf("%s: %v", f.Path, err)
+		}
+	}
+	for _, f := range files {
+		if _, err := c.Compile(baseURI + f.Path); err != nil {
+			t.Errorf("%s: %v", f.Path, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package jsonschema
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/literal"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+// lexicueDefs returns JSON Schema translations of the given
+// definitions in the lexicue CUE package (for example "#blob"),
+// along with all the definitions that they refer to, keyed
+// by name without the leading #. References between the definitions
+// are formed by adding refPrefix to the name.
+//
+// Translating the CUE rather than duplicating it means that
+// the patterns and limits stay in sync with those used by
+// the generated CUE.
+func lexicueDefs(names []string, refPrefix string) (map[string]schema, error) {
+	f, err := parser.ParseFile("lexicue.cue", gen.LexicueSource)
+	if err != nil {
+		return nil, err
+	}
+	decls := make(map[string]ast.Expr)
+	for _, decl := range f.Decls {
+		field, ok := decl.(*ast.Field)
+		if !ok {
+			continue
+		}
+		name, _, err := ast.LabelName(field.Label)
+		if err == nil && strings.HasPrefix(name, "#") {
+			decls[name] = field.Value
+		}
+	}
+	defs := make(map[string]schema)
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+		if _, ok := defs[name[1:]]; ok {
+			continue
+		}
+		e, ok := decls[name]
+		if !ok {
+			return nil, fmt.Errorf("no definition %s in lexicue package", name)
+		}
+		c := cueConverter{
+			refPrefix: refPrefix,
+		}
+		s, err := c.convert(e)
+		if err != nil {
+			return nil, fmt.Errorf("cannot translate %s: %v", name, err)
+		}
+		defs[name[1:]] = s
+		names = append(names, c.refs...)
+	}
+	return defs, nil
+}
+
+// cueConverter translates the subset of CUE used by
+// the lexicue package definitions to JSON Schema.
+type cueConverter struct {
+	refPrefix string
+
+	// refs holds the definitions referred to.
+	refs []string
+}
+
+func (c *cueConverter) convert(e ast.Expr) (schema, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return c.convert(e.X)
+	case *ast.Ident:
+		switch e.Name {
+		case "_":
+			return schema{}, nil
+		case "string":
+			return schema{"type": "string"}, nil
+		case "bool":
+			return schema{"type": "boolean"}, nil
+		case "int":
+			return schema{"type": "integer"}, nil
+		case "uint":
+			return schema{"type": "integer", "minimum": 0}, nil
+		case "number":
+			return schema{"type": "number"}, nil
+		}
+		if strings.HasPrefix(e.Name, "#") {
+			c.refs = append(c.refs, e.Name)
+			return schema{"$ref": c.refPrefix + e.Name[1:]}, nil
+		}
+	case *ast.BasicLit:
+		v, err := literalValue(e)
+		if err != nil {
+			return nil, err
+		}
+		return schema{"const": v}, nil
+	case *ast.UnaryExpr:
+		lit, ok := e.X.(*ast.BasicLit)
+		if !ok {
+			break
+		}
+		v, err := literalValue(lit)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.MAT:
+			return schema{"type": "string", "pattern": v}, nil
+		case token.NMAT:
+			return schema{"not": schema{"pattern": v}}, nil
+		case token.NEQ:
+			return schema{"not": schema{"const": v}}, nil
+		case token.LEQ:
+			return schema{"maximum": v}, nil
+		case token.LSS:
+			return schema{"exclusiveMaximum": v}, nil
+		case token.GEQ:
+			return schema{"minimum": v}, nil
+		case token.GTR:
+			return schema{"exclusiveMinimum": v}, nil
+		}
+	case *ast.BinaryExpr:
+		x, err := c.convert(e.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := c.convert(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.AND:
+			return allOf(x, y), nil
+		case token.OR:
+			return anyOf(x, y), nil
+		}
+	case *ast.CallExpr:
+		if fun, ok := e.Fun.(*ast.SelectorExpr); ok && isSelector(fun, "strings", "MaxRunes") && len(e.Args) == 1 {
+			// JSON Schema counts code points, as MaxRunes does.
+			if lit, ok := e.Args[0].(*ast.BasicLit); ok && lit.Kind == token.INT {
+				n, err := strconv.Atoi(lit.Value)
+				if err != nil {
+					return nil, err
+				}
+				return schema{"maxLength": n}, nil
+			}
+		}
+	case *ast.SelectorExpr:
+		if isSelector(e, "time", "Time") {
+			return schema{"type": "string", "format": "date-time"}, nil
+		}
+	case *ast.StructLit:
+		s := schema{
+			"type": "object",
+		}
+		props := make(schema)
+		var required []string
+		open := false
+		for _, decl := range e.Elts {
+			switch decl := decl.(type) {
+			case *ast.Ellipsis:
+				open = true
+			case *ast.Field:
+				name, _, err := ast.LabelName(decl.Label)
+				if err != nil {
+					return nil, err
+				}
+				if strings.HasPrefix(name, "#") || strings.HasPrefix(name, "_") {
+					// Definitions and hidden fields don't appear in data.
+					continue
+				}
+				v, err := c.convert(decl.Value)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %v", name, err)
+				}
+				props[name] = v
+				if decl.Constraint != token.OPTION {
+					required = append(required, name)
+				}
+			default:
+				return nil, fmt.Errorf("unsupported declaration in struct")
+			}
+		}
+		if len(props) > 0 {
+			s["properties"] = props
+		}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		if !open {
+			// Definitions are closed.
+			s["additionalProperties"] = false
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported CUE expression %T", e)
+}
+
+// literalValue returns the value of the given CUE literal.
+func literalValue(lit *ast.BasicLit) (any, error) {
+	switch lit.Kind {
+	case token.STRING:
+		return literal.Unquote(lit.Value)
+	case token.INT:
+		return strconv.ParseInt(lit.Value, 0, 64)
+	case token.FLOAT:
+		return strconv.ParseFloat(lit.Value, 64)
+	case token.TRUE:
+		return true, nil
+	case token.FALSE:
+		return false, nil
+	}
+	return nil, fmt.Errorf("unsupported literal %s", lit.Value)
+}
+
+func isSelector(e *ast.SelectorExpr, pkg, name string) bool {
+	x, ok := e.X.(*ast.Ident)
+	if !ok || x.Name != pkg {
+		return false
+	}
+	sel, _, _ := ast.LabelName(e.Sel)
+	return sel == name
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kr/fs"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/lint"
+)
+
+// runLint implements the lint command, which checks
+// lexicons against the rules in the lint package.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	disable := flags.String("disable", "", "don't check the rules in the comma-separated `list` of rule IDs")
+	listRules := flags.Bool("rules", false, "list the rules and exit")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue lint [flags] [lexiconfile.json | directory]...\n")
+		fmt.Fprintf(os.Stderr, "\nEach problem is printed as: file:line:column: message (rule)\n\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if *listRules {
+		for _, r := range lint.Rules {
+			fmt.Printf("%-14s %s\n", r.ID, r.Doc)
+		}
+		return 0
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+	}
+	var cfg lint.Config
+	if *disable != "" {
+		cfg.Disabled = strings.Split(*disable, ",")
+	}
+	for _, id := range cfg.Disabled {
+		if !isRule(id) {
+			fmt.Fprintf(os.Stderr, "unknown rule %q\n", id)
+			return 2
+		}
+	}
+	files, ok := readLintFiles(flags.Args())
+	diags, err := lint.Lint(files, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if !ok || len(diags) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readLintFiles reads the lexicon files found by walking each
+// of the given paths. It prints an error for each file that can't
+// be read and reports whether all the files were read.
+func readLintFiles(paths []string) ([]*lint.File, bool) {
+	var files []*lint.File
+	ok := true
+	for _, p := range paths {
+		for w := fs.Walk(p); w.Step(); {
+			if err := w.Err(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", w.Path(), err)
+				ok = false
+				continue
+			}
+			if w.Stat().IsDir() || (w.Path() != p && !strings.HasSuffix(w.Path(), ".json")) {
+				continue
+			}
+			data, err := os.ReadFile(w.Path())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				ok = false
+				continue
+			}
+			var schema gen.Schema
+			if err := json.Unmarshal(data, &schema); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", w.Path(), err)
+				ok = false
+				continue
+			}
+			files = append(files, &lint.File{
+				Filename: w.Path(),
+				Data:     data,
+				Schema:   &schema,
+			})
+		}
+	}
+	return files, ok
+}
+
+func isRule(id string) bool {
+	for _, r := range lint.Rules {
+		if r.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,349 @@
+// Package lint checks lexicons against rules of style and
+// consistency that go beyond what the lexicon schema requires.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// Rule describes a lint rule.
+type Rule struct {
+	// ID holds the name of the rule, as used in diagnostics
+	// and to disable the rule.
+	ID string
+
+	// Doc describes what the rule checks.
+	Doc string
+}
+
+// Rules holds all the rules checked by Lint.
+var Rules = []Rule{
+	{"schema", "lexicon documents conform to the lexicon schema"},
+	{"nsid", "lexicon IDs are valid NSIDs"},
+	{"def-name", "definition names are camelCase"},
+	{"primary-def", "record, query, procedure, subscription, image, video and audio definitions are named main"},
+	{"main-def", "main definitions are of a primary type: record, query, procedure, subscription, image, video or audio"},
+	{"record-key", "records specify a key type"},
+	{"required", "required fields are present in properties"},
+	{"nullable", "nullable fields are present in properties"},
+	{"default", "defaults are within the minimum, maximum and enum of their type"},
+	{"ref", "references resolve to definitions of a type that can be referred to"},
+	{"union-empty", "unions have at least one member"},
+	{"max-graphemes", "strings with maxGraphemes also have maxLength"},
+	{"description", "definitions and properties have descriptions"},
+}
+
+// Diagnostic describes a problem found by Lint.
+type Diagnostic struct {
+	// Pos holds the position of the problem.
+	Pos gen.Position
+
+	// Rule holds the ID of the rule that was broken.
+	Rule string
+
+	// Msg describes the problem.
+	Msg string
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%v: %s (%s)", d.Pos, d.Msg, d.Rule)
+}
+
+// File holds a lexicon to be checked.
+type File struct {
+	// Filename holds the name of the file, used for diagnostics.
+	Filename string
+
+	// Data holds the JSON contents of the file.
+	Data []byte
+
+	// Schema holds the lexicon decoded from Data.
+	Schema *gen.Schema
+}
+
+// Config holds configuration for Lint.
+type Config struct {
+	// Disabled holds the IDs of rules that are not checked.
+	Disabled []string
+}
+
+// Lint checks the given lexicons and returns the problems found,
+// ordered by file and then by position within each file.
+// References are resolved within the given set of lexicons.
+func Lint(files []*File, cfg Config) ([]*Diagnostic, error) {
+	l := &linter{
+		disabled:  make(map[string]bool),
+		refErrors: make(map[string][]*gen.RefError),
+	}
+	for _, id := range cfg.Disabled {
+		l.disabled[id] = true
+	}
+	schemas := make([]*gen.Schema, len(files))
+	for i, f := range files {
+		schemas[i] = f.Schema
+	}
+	if errs, ok := gen.ResolveRefs(schemas).(gen.RefErrors); ok {
+		for _, e := range errs {
+			l.refErrors[e.ID] = append(l.refErrors[e.ID], e)
+		}
+	}
+	var lexiconSchema cue.Value
+	if !l.disabled["schema"] {
+		var err error
+		lexiconSchema, err = gen.LexiconSchema(cuecontext.New())
+		if err != nil {
+			return nil, fmt.Errorf("cannot load lexicon schema: %v", err)
+		}
+	}
+	var diags []*Diagnostic
+	for _, f := range files {
+		l.file = f
+		l.pos = gen.NewJSONPositions(f.Filename, f.Data)
+		l.diags = nil
+		if lexiconSchema.Exists() {
+			l.checkSchema(lexiconSchema)
+		}
+		l.lintSchema(f.Schema)
+		sort.SliceStable(l.diags, func(i, j int) bool {
+			pi, pj := l.diags[i].Pos, l.diags[j].Pos
+			if pi.Line != pj.Line {
+				return pi.Line < pj.Line
+			}
+			return pi.Column < pj.Column
+		})
+		diags = append(diags, l.diags...)
+	}
+	return diags, nil
+}
+
+type linter struct {
+	disabled map[string]bool
+
+	// refErrors holds the references that don't
+	// resolve, keyed by lexicon ID.
+	refErrors map[string][]*gen.RefError
+
+	// file, pos and diags relate to the file being checked.
+	file  *File
+	pos   *gen.JSONPositions
+	diags []*Diagnostic
+}
+
+// report adds a diagnostic for the value at the given path
+// unless the rule is disabled.
+func (l *linter) report(rule string, path []string, msg string, args ...any) {
+	if l.disabled[rule] {
+		return
+	}
+	l.diags = append(l.diags, &Diagnostic{
+		Pos:  l.pos.Pos(path...),
+		Rule: rule,
+		Msg:  fmt.Sprintf(msg, args...),
+	})
+}
+
+// checkSchema checks the file against the lexicon schema.
+func (l *linter) checkSchema(lexiconSchema cue.Value) {
+	errs, _ := gen.ValidateLexicon(l.file.Data, l.file.Filename, lexiconSchema).(gen.SchemaErrors)
+	for _, e := range errs {
+		l.report("schema", e.Path, "%s", e.Msg)
+	}
+}
+
+func (l *linter) lintSchema(schema *gen.Schema) {
+	l.lintNSID(schema.ID)
+	for _, name := range sorted.Keys(schema.Defs) {
+		t := schema.Defs[name]
+		path := []string{"defs", name}
+		if !defNamePat.MatchString(name) {
+			l.report("def-name", path, "definition name %q is not camelCase", name)
+		}
+		switch {
+		case isPrimary(t.Type) && name != "main":
+			l.report("primary-def", path, "%s definition must be named main", t.Type)
+		case !isPrimary(t.Type) && name == "main":
+			l.report("main-def", path, "main definition has type %s rather than a primary type", t.Type)
+		}
+		l.lintType(t, path, true)
+	}
+	for _, e := range l.refErrors[schema.ID] {
+		l.report("ref", e.Path, "reference %q: %s", e.Ref, e.Msg)
+	}
+}
+
+var (
+	defNamePat       = regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)
+	domainSegmentPat = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	nameSegmentPat   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*$`)
+)
+
+// Limits on NSIDs.
+const (
+	minNSIDSegments  = 3
+	maxNSIDLength    = 317
+	maxDomainLength  = 253
+	maxSegmentLength = 63
+)
+
+// lintNSID checks that id is a valid NSID, as described
+// in https://atproto.com/specs/nsid.
+func (l *linter) lintNSID(id string) {
+	path := []string{"id"}
+	if len(id) > maxNSIDLength {
+		l.report("nsid", path, "NSID is longer than %d characters", maxNSIDLength)
+	}
+	segments := strings.Split(id, ".")
+	if len(segments) < minNSIDSegments {
+		l.report("nsid", path, "NSID %q has fewer than %d segments", id, minNSIDSegments)
+		return
+	}
+	domain, name := segments[:len(segments)-1], segments[len(segments)-1]
+	if len(strings.Join(domain, ".")) > maxDomainLength {
+		l.report("nsid", path, "NSID domain authority is longer than %d characters", maxDomainLength)
+	}
+	for i, seg := range domain {
+		switch {
+		case len(seg) > maxSegmentLength:
+			l.report("nsid", path, "NSID segment %q is longer than %d characters", seg, maxSegmentLength)
+		case !domainSegmentPat.MatchString(seg):
+			l.report("nsid", path, "invalid NSID domain segment %q", seg)
+		case i == 0 && seg[0] >= '0' && seg[0] <= '9':
+			l.report("nsid", path, "NSID cannot start with a digit")
+		}
+	}
+	if len(name) > maxSegmentLength || !nameSegmentPat.MatchString(name) {
+		l.report("nsid", path, "invalid NSID name segment %q", name)
+	}
+}
+
+// lintType checks the type t at the given path. If describe is true,
+// t is a definition or property, so it should have a description.
+func (l *linter) lintType(t *gen.TypeSchema, path []string, describe bool) {
+	if t == nil {
+		return
+	}
+	if describe && t.Description == "" {
+		l.report("description", path, "missing description")
+	}
+	switch t.Type {
+	case "record":
+		if t.Key == "" {
+			l.report("record-key", path, "record has no key")
+		}
+		l.lintType(t.Record, elem(path, "record"), false)
+	case "query", "procedure", "subscription":
+		l.lintType(t.Parameters, elem(path, "parameters"), false)
+		if t.Input != nil {
+			l.lintType(t.Input.Schema, elem(path, "input", "schema"), false)
+		}
+		if t.Output != nil {
+			l.lintType(t.Output.Schema, elem(path, "output", "schema"), false)
+		}
+		if t.Message != nil {
+			l.lintType(t.Message.Schema, elem(path, "message", "schema"), false)
+		}
+	case "object", "params":
+		l.lintFieldList("required", t.Required, t, path)
+		l.lintFieldList("nullable", t.Nullable, t, path)
+		for _, name := range sorted.Keys(t.Properties) {
+			l.lintType(t.Properties[name], elem(path, "properties", name), true)
+		}
+	case "array":
+		l.lintType(t.Items, elem(path, "items"), false)
+	case "union":
+		if len(t.Refs) == 0 {
+			l.report("union-empty", path, "union has no members")
+		}
+	case "string":
+		if t.MaxGraphemes != nil && t.MaxLength == nil {
+			l.report("max-graphemes", elem(path, "maxGraphemes"), "string has maxGraphemes but no maxLength")
+		}
+	}
+	if t.Default != nil {
+		l.lintDefault(t, path)
+	}
+}
+
+// lintFieldList checks that each field named in the list
+// with the given name is a property of t.
+func (l *linter) lintFieldList(listName string, names []string, t *gen.TypeSchema, path []string) {
+	for i, name := range names {
+		if t.Properties[name] == nil {
+			l.report(listName, elem(path, listName, fmt.Sprint(i)), "%s field %q is not in properties", listName, name)
+		}
+	}
+}
+
+// lintDefault checks that the default value of t is allowed by t.
+func (l *linter) lintDefault(t *gen.TypeSchema, path []string) {
+	path = elem(path, "default")
+	if len(t.Enum) > 0 && !inEnum(t.Default, t.Enum) {
+		l.report("default", path, "default %s is not in enum", jsonValue(t.Default))
+	}
+	x, ok := number(t.Default)
+	if !ok {
+		return
+	}
+	if min, ok := number(t.Minimum); ok && x < min {
+		l.report("default", path, "default %s is less than minimum %s", jsonValue(t.Default), jsonValue(t.Minimum))
+	}
+	if max, ok := number(t.Maximum); ok && x > max {
+		l.report("default", path, "default %s is greater than maximum %s", jsonValue(t.Default), jsonValue(t.Maximum))
+	}
+}
+
+// isPrimary reports whether typ is a primary type, which
+// is generated as a lexicue definition of the same name.
+func isPrimary(typ string) bool {
+	switch typ {
+	case "record", "query", "procedure", "subscription", "image", "video", "audio":
+		return true
+	}
+	return false
+}
+
+// elem returns path with the given elements appended,
+// without changing the contents of path.
+func elem(path []string, elems ...string) []string {
+	return append(path[:len(path):len(path)], elems...)
+}
+
+func inEnum(x any, enum []any) bool {
+	for _, y := range enum {
+		if jsonValue(x) == jsonValue(y) {
+			return true
+		}
+	}
+	return false
+}
+
+// number returns x as a float64 if it's a JSON number.
+func number(x any) (float64, bool) {
+	switch x := x.(type) {
+	case float64:
+		return x, true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil && !math.IsInf(f, 0)
+	}
+	return 0, false
+}
+
+func jsonValue(x any) string {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return fmt.Sprint(x)
+	}
+	return string(data)
+}
@@ -0,0 +1,275 @@
+package lint_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/lint"
+)
+
+var lintTests = []struct {
+	rule    string
+	lexicon string
+	want    []string
+}{{
+	rule: "schema",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "main": {"type": "foo"}
+  }
+}`,
+	want: []string{
+		`test.json:5:14: unknown type "foo" (allowed types are array, audio, blob, boolean, bytes, cid-link, image, integer, number, object, procedure, query, record, ref, string, subscription, token, union, unknown, video) (schema)`,
+	},
+}, {
+	rule: "nsid",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.1example.a_b",
+  "defs": {}
+}`,
+	want: []string{
+		`test.json:3:3: invalid NSID name segment "a_b" (nsid)`,
+	},
+}, {
+	rule: "def-name",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "Bad_name": {"type": "token"}
+  }
+}`,
+	want: []string{
+		`test.json:5:5: definition name "Bad_name" is not camelCase (def-name)`,
+	},
+}, {
+	rule: "primary-def",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "main": {"type": "token"},
+    "other": {"type": "query"}
+  }
+}`,
+	want: []string{
+		`test.json:6:5: query definition must be named main (primary-def)`,
+	},
+}, {
+	rule: "main-def",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "main": {"type": "token"}
+  }
+}`,
+	want: []string{
+		`test.json:5:5: main definition has type token rather than a primary type (main-def)`,
+	},
+}, {
+	rule: "record-key",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "main": {"type": "record", "record": {"type": "object", "properties": {}}}
+  }
+}`,
+	want: []string{
+		`test.json:5:5: record has no key (record-key)`,
+	},
+}, {
+	rule: "required",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "obj": {
+      "type": "object",
+      "required": ["a", "b"],
+      "properties": {"a": {"type": "string"}}
+    }
+  }
+}`,
+	want: []string{
+		`test.json:7:25: required field "b" is not in properties (required)`,
+	},
+}, {
+	rule: "nullable",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "obj": {
+      "type": "object",
+      "nullable": ["b"],
+      "properties": {"a": {"type": "string"}}
+    }
+  }
+}`,
+	want: []string{
+		`test.json:7:20: nullable field "b" is not in properties (nullable)`,
+	},
+}, {
+	rule: "default",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "a": {"type": "integer", "minimum": 1, "maximum": 10, "default": 11},
+    "b": {"type": "string", "enum": ["x", "y"], "default": "z"}
+  }
+}`,
+	want: []string{
+		`test.json:5:59: default 11 is greater than maximum 10 (default)`,
+		`test.json:6:49: default "z" is not in enum (default)`,
+	},
+}, {
+	rule: "ref",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "a": {"type": "ref", "ref": "#missing"}
+  }
+}`,
+	want: []string{
+		`test.json:5:26: reference "#missing": lexicon "com.example.test" has no definition "missing" (ref)`,
+	},
+}, {
+	rule: "union-empty",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "a": {"type": "union", "refs": []}
+  }
+}`,
+	want: []string{
+		`test.json:5:5: union has no members (union-empty)`,
+	},
+}, {
+	rule: "max-graphemes",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "a": {"type": "string", "maxGraphemes": 10},
+    "b": {"type": "string", "maxGraphemes": 10, "maxLength": 100}
+  }
+}`,
+	want: []string{
+		`test.json:5:29: string has maxGraphemes but no maxLength (max-graphemes)`,
+	},
+}, {
+	rule: "description",
+	lexicon: `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "obj": {
+      "type": "object",
+      "description": "An object.",
+      "properties": {
+        "a": {"type": "string"},
+        "b": {"type": "string", "description": "B."}
+      }
+    }
+  }
+}`,
+	want: []string{
+		`test.json:9:9: missing description (description)`,
+	},
+}}
+
+func TestLint(t *testing.T) {
+	tested := make(map[string]bool)
+	for _, test := range lintTests {
+		t.Run(test.rule, func(t *testing.T) {
+			tested[test.rule] = true
+			// Check the rule on its own, so that the test
+			// isn't affected by any other rules the lexicon breaks.
+			var disabled []string
+			for _, r := range lint.Rules {
+				if r.ID != test.rule {
+					disabled = append(disabled, r.ID)
+				}
+			}
+			files := []*lint.File{testFile(t, test.lexicon)}
+			diags, err := lint.Lint(files, lint.Config{Disabled: disabled})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := diagStrings(diags), strings.Join(test.want, "\n"); got != want {
+				t.Errorf("unexpected diagnostics\ngot:\n%s\nwant:\n%s", got, want)
+			}
+			diags, err = lint.Lint(files, lint.Config{Disabled: append(disabled, test.rule)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diags) > 0 {
+				t.Errorf("unexpected diagnostics with rule disabled:\n%s", diagStrings(diags))
+			}
+		})
+	}
+	for _, r := range lint.Rules {
+		if !tested[r.ID] {
+			t.Errorf("no test for rule %q", r.ID)
+		}
+	}
+}
+
+func TestLintValid(t *testing.T) {
+	files := []*lint.File{testFile(t, `{
+  "lexicon": 1,
+  "id": "com.example.test",
+  "defs": {
+    "main": {
+      "type": "record",
+      "description": "A record.",
+      "key": "tid",
+      "record": {
+        "type": "object",
+        "required": ["text"],
+        "properties": {
+          "text": {"type": "string", "description": "The text.", "maxLength": 300, "maxGraphemes": 100}
+        }
+      }
+    }
+  }
+}`)}
+	diags, err := lint.Lint(files, lint.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) > 0 {
+		t.Errorf("unexpected diagnostics:\n%s", diagStrings(diags))
+	}
+}
+
+func testFile(t *testing.T, lexicon string) *lint.File {
+	t.Helper()
+	var schema gen.Schema
+	if err := json.Unmarshal([]byte(lexicon), &schema); err != nil {
+		t.Fatal(err)
+	}
+	return &lint.File{
+		Filename: "test.json",
+		Data:     []byte(lexicon),
+		Schema:   &schema,
+	}
+}
+
+func diagStrings(diags []*lint.Diagnostic) string {
+	var lines []string
+	for _, d := range diags {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var lintCommandTests = []struct {
+	testName   string
+	args       []string
+	wantStatus int
+	wantStdout string
+	wantStderr string
+}{{
+	testName:   "Clean",
+	args:       []string{"-disable", "description,main-def", "gen/testdata/lexicons"},
+	wantStatus: 0,
+}, {
+	testName: "Problems",
+	// The image definition is primary, so main-def doesn't apply.
+	args:       []string{"gen/testdata/lexicons/com/example/pic.json"},
+	wantStatus: 1,
+	wantStdout: "gen/testdata/lexicons/com/example/pic.json:5:5: missing description (description)\n",
+}, {
+	testName:   "UnknownRule",
+	args:       []string{"-disable", "description,nonexistent", "gen/testdata/lexicons"},
+	wantStatus: 2,
+	wantStderr: "unknown rule \"nonexistent\"\n",
+}}
+
+func TestLintCommand(t *testing.T) {
+	for _, test := range lintCommandTests {
+		t.Run(test.testName, func(t *testing.T) {
+			stdout, stderr, status := runLexicueStatus(t, append([]string{"lint"}, test.args...)...)
+			if status != test.wantStatus {
+				t.Errorf("got exit status %d, want %d; stderr:\n%s", status, test.wantStatus, stderr)
+			}
+			if string(stdout) != test.wantStdout {
+				t.Errorf("unexpected output\ngot:\n%s\nwant:\n%s", stdout, test.wantStdout)
+			}
+			if !strings.Contains(string(stderr), test.wantStderr) {
+				t.Errorf("unexpected error output\ngot:\n%s\nwant:\n%s", stderr, test.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestMain(m *testing.M) {
+	// When the test binary is run by runLexicue,
+	// it behaves as the lexicue command.
+	if os.Getenv("LEXICUE_TEST_MAIN") != "" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runLexicue runs the lexicue command with the given arguments
+// and returns its standard output.
+func runLexicue(t *testing.T, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "LEXICUE_TEST_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("lexicue %s: %v\n%s", strings.Join(args, " "), err, stderr.Bytes())
+	}
+	return out
+}
+
+// runLexicueStatus is like runLexicue but also returns the standard
+// error and exit status of the command rather than failing when
+// the status is non-zero.
+func runLexicueStatus(t *testing.T, args ...string) (stdout, stderr []byte, status int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "LEXICUE_TEST_MAIN=1")
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	out, err := cmd.Output()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("lexicue %s: %v", strings.Join(args, " "), err)
+		}
+		status = exitErr.ExitCode()
+	}
+	return out, errBuf.Bytes(), status
+}
+
+var goldenTests = []struct {
+	testName string
+	flags    []string
+}{{
+	testName: "default",
+}, {
+	testName: "map",
+	flags:    []string{"-m"},
+}, {
+	testName: "break-cycles",
+	flags:    []string{"-break-cycles", "-graph-format", "dot", "-graph-level", "definition"},
+}}
+
+func TestGenerateDeterministic(t *testing.T) {
+	const dir = "gen/testdata/lexicons"
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := slices.Clone(files)
+	slices.Reverse(reversed)
+	shuffled := slices.Clone(files)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	for _, test := range goldenTests {
+		t.Run(test.testName, func(t *testing.T) {
+			want := runLexicue(t, append(test.flags, dir)...)
+			for i, args := range [][]string{{dir}, files, reversed, shuffled} {
+				got := runLexicue(t, append(test.flags, args...)...)
+				if !bytes.Equal(got, want) {
+					t.Errorf("run %d: output differs from the first run", i)
+				}
+			}
+			golden := filepath.Join("testdata", "generate", test.testName+".txtar")
+			if *update {
+				if err := os.WriteFile(golden, want, 0o666); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			data, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, want) {
+				t.Errorf("output differs from %s; run with -update to update it", golden)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/openapi"
+)
+
+// runOpenAPI implements the openapi command, which generates
+// an OpenAPI specification for the XRPC endpoints in lexicons.
+func runOpenAPI(args []string) int {
+	flags := flag.NewFlagSet("openapi", flag.ExitOnError)
+	outFile := flags.String("o", "", "write the specification to `file` rather than stdout")
+	title := flags.String("title", "XRPC API", "use `title` as the title of the API")
+	version := flags.String("version", "0.0.0", "use `version` as the version of the API")
+	serverURL := flags.String("server", "", "specify the `url` of the server hosting the API")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue openapi [flags] [lexiconfile.json | directory]...\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+	}
+	schemas, err := gen.ReadLexicons(flags.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	data, err := openapi.Generate(openapi.Config{
+		Title:     *title,
+		Version:   *version,
+		ServerURL: *serverURL,
+	}, schemas)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *outFile == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*outFile, data, 0o666); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
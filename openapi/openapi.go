@@ -0,0 +1,254 @@
+// Package openapi generates OpenAPI 3.1 specifications for
+// the XRPC endpoints defined by atproto lexicons.
+//
+// Each query becomes a GET operation and each procedure a POST
+// operation on the path /xrpc/<nsid>. Subscriptions are served
+// over WebSockets, which OpenAPI cannot describe, so they are
+// omitted. The schemas are generated by the jsonschema package and
+// included as components, so all the definitions in the lexicons
+// are available even when no endpoint refers to them.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+	"github.com/rogpeppe/lexicue/jsonschema"
+)
+
+// Config holds configuration for Generate.
+type Config struct {
+	// Title holds the title of the API.
+	Title string
+
+	// Version holds the version of the API.
+	Version string
+
+	// ServerURL holds the URL of the server hosting the API.
+	// If it's empty, no servers are specified.
+	ServerURL string
+}
+
+const componentsRef = "#/components/schemas/"
+
+type document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       info                 `json:"info"`
+	Servers    []server             `json:"servers,omitempty"`
+	Paths      map[string]*pathItem `json:"paths"`
+	Components components           `json:"components"`
+}
+
+type info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type server struct {
+	URL string `json:"url"`
+}
+
+type pathItem struct {
+	Get  *operation `json:"get,omitempty"`
+	Post *operation `json:"post,omitempty"`
+}
+
+type operation struct {
+	OperationID string               `json:"operationId"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string          `json:"name"`
+	In          string          `json:"in"`
+	Description string          `json:"description,omitempty"`
+	Required    bool            `json:"required,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type response struct {
+	Description string                `json:"description"`
+	Content     map[string]*mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+type components struct {
+	Schemas map[string]json.RawMessage `json:"schemas"`
+}
+
+// Generate returns an OpenAPI specification, encoded as JSON, for the
+// queries and procedures in the given lexicons. All the lexicons
+// referred to must be included.
+func Generate(cfg Config, schemas []*gen.Schema) ([]byte, error) {
+	schemaComponents, err := jsonschema.Components(componentsRef, schemas)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document{
+		OpenAPI: "3.1.0",
+		Info: info{
+			Title:   cfg.Title,
+			Version: cfg.Version,
+		},
+		Paths: make(map[string]*pathItem),
+		Components: components{
+			Schemas: schemaComponents,
+		},
+	}
+	if cfg.ServerURL != "" {
+		doc.Servers = []server{{cfg.ServerURL}}
+	}
+	var errs []string
+	for _, schema := range schemas {
+		t := schema.Defs["main"]
+		if t == nil || (t.Type != "query" && t.Type != "procedure") {
+			continue
+		}
+		op, err := newOperation(schema.ID, t, schemaComponents)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", schema.ID, err))
+			continue
+		}
+		item := &pathItem{}
+		if t.Type == "query" {
+			item.Get = op
+		} else {
+			item.Post = op
+		}
+		doc.Paths["/xrpc/"+schema.ID] = item
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	// The parameter schemas are inlined into the
+	// operations, so there's no need to keep them.
+	for name := range schemaComponents {
+		if strings.HasSuffix(name, "-parameters") {
+			delete(schemaComponents, name)
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// HTML characters are common in patterns.
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newOperation returns the operation for the XRPC endpoint
+// with the given ID and definition.
+func newOperation(id string, t *gen.TypeSchema, schemaComponents map[string]json.RawMessage) (*operation, error) {
+	name := jsonschema.ComponentName(id, "main")
+	op := &operation{
+		OperationID: id,
+		Description: t.Description,
+		Tags:        []string{id[:strings.LastIndex(id, ".")]},
+		Responses:   make(map[string]*response),
+	}
+	if data := schemaComponents[name+"-parameters"]; data != nil {
+		params, err := parameters(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad parameters: %v", err)
+		}
+		op.Parameters = params
+	}
+	if t.Input != nil {
+		op.RequestBody = &requestBody{
+			Required: true,
+			Content:  content(t.Input, name+"-input"),
+		}
+	}
+	op.Responses["200"] = &response{
+		Description: "Success",
+		Content:     content(t.Output, name+"-output"),
+	}
+	// Any endpoint may return one of the generic errors,
+	// so there's always an error response.
+	desc := "Error"
+	if len(t.Errors) > 0 {
+		var names []string
+		for _, e := range t.Errors {
+			names = append(names, e.Name)
+		}
+		desc += " (" + strings.Join(names, ", ") + ")"
+	}
+	op.Responses["400"] = &response{
+		Description: desc,
+		Content: map[string]*mediaType{
+			"application/json": {
+				Schema: ref(name + "-errorBody"),
+			},
+		},
+	}
+	return op, nil
+}
+
+// parameters returns the query parameters described by the
+// JSON-encoded schema for the parameters of an endpoint.
+func parameters(data json.RawMessage) ([]*parameter, error) {
+	var paramsSchema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Required   []string                   `json:"required"`
+	}
+	if err := json.Unmarshal(data, &paramsSchema); err != nil {
+		return nil, err
+	}
+	var params []*parameter
+	for _, name := range sorted.Keys(paramsSchema.Properties) {
+		s := paramsSchema.Properties[name]
+		var desc struct {
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(s, &desc); err != nil {
+			return nil, err
+		}
+		params = append(params, &parameter{
+			Name:        name,
+			In:          "query",
+			Description: desc.Description,
+			Required:    slices.Contains(paramsSchema.Required, name),
+			Schema:      s,
+		})
+	}
+	return params, nil
+}
+
+// content returns the content of an XRPC body, using the
+// component with the given name for its schema.
+func content(body *gen.BodyType, name string) map[string]*mediaType {
+	if body == nil {
+		return nil
+	}
+	mt := &mediaType{}
+	if body.Schema != nil {
+		mt.Schema = ref(name)
+	}
+	return map[string]*mediaType{
+		body.Encoding: mt,
+	}
+}
+
+func ref(name string) json.RawMessage {
+	data, _ := json.Marshal(map[string]string{"$ref": componentsRef + name})
+	return data
+}
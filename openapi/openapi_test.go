@@ -0,0 +1,78 @@
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/openapi"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerate(t *testing.T) {
+	schemas, err := gen.ReadLexicons("testdata/lexicons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := openapi.Generate(openapi.Config{
+		Title:     "Test API",
+		Version:   "1.0.0",
+		ServerURL: "https://example.com",
+	}, schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const golden = "testdata/spec.json"
+	if *update {
+		if err := os.WriteFile(golden, data, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("output differs from %s; run with -update to update it", golden)
+		}
+	}
+	// Every reference must name a component
+	// that's in the specification.
+	var spec struct {
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	var check func(x any)
+	check = func(x any) {
+		switch x := x.(type) {
+		case map[string]any:
+			if ref, ok := x["$ref"].(string); ok {
+				name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+				if !ok || spec.Components.Schemas[name] == nil {
+					t.Errorf("unresolved reference %q", ref)
+				}
+			}
+			for _, v := range x {
+				check(v)
+			}
+		case []any:
+			for _, v := range x {
+				check(v)
+			}
+		}
+	}
+	var all any
+	if err := json.Unmarshal(data, &all); err != nil {
+		t.Fatal(err)
+	}
+	check(all)
+}
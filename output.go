@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// output represents a destination for generated files.
+type output interface {
+	// WriteFile writes a generated file. The name is slash-separated
+	// and relative to the root of the output.
+	WriteFile(name string, data []byte) error
+
+	// Close finishes writing the output.
+	Close() error
+}
+
+// txtarOutput writes files as sections of a txtar archive.
+type txtarOutput struct {
+	w io.Writer
+}
+
+func (out *txtarOutput) WriteFile(name string, data []byte) error {
+	if _, err := fmt.Fprintf(out.w, "-- %s --\n", name); err != nil {
+		return err
+	}
+	_, err := out.w.Write(data)
+	return err
+}
+
+func (out *txtarOutput) Close() error {
+	return nil
+}
+
+// cueManifestFile holds the name of the manifest file, relative
+// to the output directory, used when generating CUE.
+// The manifest records the files written by the previous run
+// along with a hash of their contents. It's used to
+// tell generated files from hand-edited ones.
+const cueManifestFile = "cue.mod/lexicue.sum"
+
+// dirOutput writes files into a directory tree.
+type dirOutput struct {
+	dir      string
+	manifest string
+	clean    bool
+	force    bool
+
+	// oldSums holds the hashes of the files written by
+	// the previous run, keyed by file name.
+	oldSums map[string]string
+
+	// sums holds the hashes of the files written by this run.
+	sums map[string]string
+}
+
+// newDirOutput returns an output that writes files into dir,
+// recording them in the given manifest file, which is
+// relative to dir. If clean is true, files recorded as generated by a previous
+// run that are not written this time will be removed on Close.
+// If force is true, files will be overwritten or removed even when they
+// have been changed since they were generated.
+func newDirOutput(dir, manifest string, clean, force bool) (*dirOutput, error) {
+	out := &dirOutput{
+		dir:      dir,
+		manifest: manifest,
+		clean:    clean,
+		force:    force,
+		sums:     make(map[string]string),
+	}
+	oldSums, err := readManifest(filepath.Join(dir, filepath.FromSlash(manifest)))
+	if err != nil {
+		return nil, err
+	}
+	out.oldSums = oldSums
+	return out, nil
+}
+
+func (out *dirOutput) WriteFile(name string, data []byte) error {
+	p := filepath.Join(out.dir, filepath.FromSlash(name))
+	if err := out.checkModified(name, p, data); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, data, 0o666); err != nil {
+		return err
+	}
+	out.sums[name] = hashOf(data)
+	return nil
+}
+
+// checkModified returns an error if the file at path p, with the given output-relative name,
+// exists but has been changed since it was last generated, unless
+// it already holds the given data.
+func (out *dirOutput) checkModified(name, p string, data []byte) error {
+	if out.force {
+		return nil
+	}
+	old, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if bytes.Equal(old, data) {
+		return nil
+	}
+	sum, ok := out.oldSums[name]
+	if !ok {
+		return fmt.Errorf("refusing to overwrite %s: file was not generated by lexicue (use -f to override)", p)
+	}
+	if sum != hashOf(old) {
+		return fmt.Errorf("refusing to overwrite %s: file has been modified since it was generated (use -f to override)", p)
+	}
+	return nil
+}
+
+func (out *dirOutput) Close() error {
+	var errs []string
+	for _, name := range sorted.Keys(out.oldSums) {
+		if _, ok := out.sums[name]; ok {
+			continue
+		}
+		if !out.clean {
+			// Remember the stale file so that a later run with -clean
+			// can remove it.
+			out.sums[name] = out.oldSums[name]
+			continue
+		}
+		if err := out.remove(name); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := out.writeManifest(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// remove removes the stale generated file with the given name,
+// along with any directories left empty as a result.
+func (out *dirOutput) remove(name string) error {
+	p := filepath.Join(out.dir, filepath.FromSlash(name))
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !out.force && hashOf(data) != out.oldSums[name] {
+		// Keep it in the manifest so that we'll try again next time.
+		out.sums[name] = out.oldSums[name]
+		return fmt.Errorf("not removing %s: file has been modified since it was generated (use -f to override)", p)
+	}
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		// Remove fails on non-empty directories, which is what we want.
+		if err := os.Remove(filepath.Join(out.dir, filepath.FromSlash(dir))); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (out *dirOutput) writeManifest() error {
+	var buf bytes.Buffer
+	for _, name := range sorted.Keys(out.sums) {
+		fmt.Fprintf(&buf, "%s  %s\n", out.sums[name], name)
+	}
+	p := filepath.Join(out.dir, filepath.FromSlash(out.manifest))
+	if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+		return err
+	}
+	return os.WriteFile(p, buf.Bytes(), 0o666)
+}
+
+// readManifest reads the manifest written by writeManifest.
+// It returns an empty map if the file does not exist.
+func readManifest(p string) (map[string]string, error) {
+	sums := make(map[string]string)
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sums, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: invalid manifest line", p, lineNum)
+		}
+		sums[name] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeOutput writes the given files, keyed by name, to dir with
+// a dirOutput and returns the error from Close or the first
+// error from WriteFile.
+func writeOutput(t *testing.T, dir string, clean, force bool, files map[string]string) error {
+	t.Helper()
+	out, err := newDirOutput(dir, cueManifestFile, clean, force)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := out.WriteFile(name, []byte(data)); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func readTestFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeTestFile(t *testing.T, dir, name, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirOutputManifest(t *testing.T) {
+	dir := t.TempDir()
+	err := writeOutput(t, dir, false, false, map[string]string{
+		"a.cue":     "a\n",
+		"sub/b.cue": "b\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, dir, "sub/b.cue"); got != "b\n" {
+		t.Errorf("unexpected contents of sub/b.cue: %q", got)
+	}
+	want := hashOf([]byte("a\n")) + "  a.cue\n" + hashOf([]byte("b\n")) + "  sub/b.cue\n"
+	if got := readTestFile(t, dir, cueManifestFile); got != want {
+		t.Errorf("unexpected manifest\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	sums, err := readManifest(filepath.Join(dir, filepath.FromSlash(cueManifestFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 2 || sums["a.cue"] != hashOf([]byte("a\n")) || sums["sub/b.cue"] != hashOf([]byte("b\n")) {
+		t.Errorf("unexpected manifest entries %v", sums)
+	}
+
+	// Generated files that haven't been changed can be overwritten.
+	if err := writeOutput(t, dir, false, false, map[string]string{"a.cue": "a2\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, dir, "a.cue"); got != "a2\n" {
+		t.Errorf("unexpected contents of a.cue: %q", got)
+	}
+	// The manifest still records the file that wasn't
+	// written this time, so that -clean can remove it later.
+	want = hashOf([]byte("a2\n")) + "  a.cue\n" + hashOf([]byte("b\n")) + "  sub/b.cue\n"
+	if got := readTestFile(t, dir, cueManifestFile); got != want {
+		t.Errorf("unexpected manifest\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDirOutputInvalidManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "cue.mod"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, cueManifestFile, "nonsense\n")
+	_, err := newDirOutput(dir, cueManifestFile, false, false)
+	if err == nil || !strings.HasSuffix(err.Error(), "lexicue.sum:1: invalid manifest line") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+var dirOutputOverwriteTests = []struct {
+	testName string
+	// edit holds the contents to write to a.cue after it's
+	// first generated, or the empty string to remove it
+	// from the manifest instead.
+	edit    string
+	force   bool
+	data    string
+	wantErr string
+}{{
+	testName: "Modified",
+	edit:     "edited\n",
+	data:     "a2\n",
+	wantErr:  "file has been modified since it was generated (use -f to override)",
+}, {
+	testName: "ModifiedSameData",
+	// Writing what's already there is always fine.
+	edit: "a2\n",
+	data: "a2\n",
+}, {
+	testName: "ModifiedForce",
+	edit:     "edited\n",
+	force:    true,
+	data:     "a2\n",
+}, {
+	testName: "Unknown",
+	data:     "a2\n",
+	wantErr:  "file was not generated by lexicue (use -f to override)",
+}, {
+	testName: "UnknownForce",
+	force:    true,
+	data:     "a2\n",
+}}
+
+func TestDirOutputOverwrite(t *testing.T) {
+	for _, test := range dirOutputOverwriteTests {
+		t.Run(test.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := writeOutput(t, dir, false, false, map[string]string{"a.cue": "a\n"}); err != nil {
+				t.Fatal(err)
+			}
+			want := test.edit
+			if test.edit != "" {
+				writeTestFile(t, dir, "a.cue", test.edit)
+			} else {
+				writeTestFile(t, dir, cueManifestFile, "")
+				want = "a\n"
+			}
+			err := writeOutput(t, dir, false, test.force, map[string]string{"a.cue": test.data})
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				want = test.data
+			} else {
+				wantErr := "refusing to overwrite " + filepath.Join(dir, "a.cue") + ": " + test.wantErr
+				if err == nil || err.Error() != wantErr {
+					t.Fatalf("unexpected error\ngot  %v\nwant %s", err, wantErr)
+				}
+			}
+			if got := readTestFile(t, dir, "a.cue"); got != want {
+				t.Errorf("unexpected contents of a.cue: got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDirOutputClean(t *testing.T) {
+	dir := t.TempDir()
+	err := writeOutput(t, dir, false, false, map[string]string{
+		"a.cue":          "a\n",
+		"sub/b.cue":      "b\n",
+		"sub/deep/c.cue": "c\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file in a generated directory that isn't
+	// generated keeps its directory in place.
+	writeTestFile(t, dir, "sub/keep.cue", "keep\n")
+	// Stale files that have been modified aren't removed.
+	writeTestFile(t, dir, "a.cue", "edited\n")
+
+	err = writeOutput(t, dir, true, false, map[string]string{"other.cue": "other\n"})
+	wantErr := "not removing " + filepath.Join(dir, "a.cue") + ": file has been modified since it was generated (use -f to override)"
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("unexpected error\ngot  %v\nwant %s", err, wantErr)
+	}
+	for _, name := range []string{"sub/b.cue", "sub/deep/c.cue", "sub/deep"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed (error %v)", name, err)
+		}
+	}
+	for _, name := range []string{"a.cue", "sub/keep.cue", "other.cue"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+	// The modified file stays in the manifest so that
+	// a later run can try again.
+	want := hashOf([]byte("a\n")) + "  a.cue\n" + hashOf([]byte("other\n")) + "  other.cue\n"
+	if got := readTestFile(t, dir, cueManifestFile); got != want {
+		t.Errorf("unexpected manifest\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// With force, it's removed.
+	if err := writeOutput(t, dir, true, true, map[string]string{"other.cue": "other\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.cue")); !os.IsNotExist(err) {
+		t.Errorf("a.cue was not removed (error %v)", err)
+	}
+	want = hashOf([]byte("other\n")) + "  other.cue\n"
+	if got := readTestFile(t, dir, cueManifestFile); got != want {
+		t.Errorf("unexpected manifest\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCleanCommand(t *testing.T) {
+	dir := t.TempDir()
+	const (
+		pic       = "gen/testdata/lexicons/com/example/pic.json"
+		strongRef = "gen/testdata/lexicons/com/atproto/repo/strongRef.json"
+	)
+	runLexicue(t, "-o", dir, pic, strongRef)
+	if _, err := os.Stat(filepath.Join(dir, "example.com", "pic", "defs.cue")); err != nil {
+		t.Fatal(err)
+	}
+	// Without -clean, the files for a lexicon that's
+	// no longer generated are left alone.
+	runLexicue(t, "-o", dir, strongRef)
+	if _, err := os.Stat(filepath.Join(dir, "example.com", "pic", "defs.cue")); err != nil {
+		t.Fatal(err)
+	}
+	runLexicue(t, "-o", dir, "-clean", strongRef)
+	if _, err := os.Stat(filepath.Join(dir, "example.com")); !os.IsNotExist(err) {
+		t.Errorf("stale package was not removed (error %v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "repo.atproto.com", "strongRef", "defs.cue")); err != nil {
+		t.Error(err)
+	}
+	if got := readTestFile(t, dir, cueManifestFile); strings.Contains(got, "example.com") {
+		t.Errorf("manifest still mentions the stale package:\n%s", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/xrpc"
+)
+
+// runProxy implements the proxy command, which forwards XRPC
+// traffic to an upstream server, logging any requests or responses
+// that don't conform to a set of lexicons.
+func runProxy(args []string) int {
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	lexiconDir := flags.String("lexicons", "", "read lexicons from `dir`")
+	upstream := flags.String("upstream", "", "forward requests to the server at `url`")
+	addr := flags.String("addr", "localhost:8080", "listen on `address`")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue proxy -lexicons dir -upstream url [flags]\n")
+		fmt.Fprintf(os.Stderr, "\nEach violation is logged as: nsid direction part: path: message\n\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if *lexiconDir == "" || *upstream == "" || flags.NArg() > 0 {
+		flags.Usage()
+	}
+	upstreamURL, err := url.Parse(*upstream)
+	if err != nil || upstreamURL.Scheme == "" || upstreamURL.Host == "" {
+		fmt.Fprintf(os.Stderr, "invalid upstream URL %q\n", *upstream)
+		return 2
+	}
+	ctx := cuecontext.New()
+	lexicons, err := loadLexicons(ctx, *lexiconDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	p := xrpc.NewProxy(xrpc.NewChecker(ctx, lexicons), xrpc.ProxyConfig{
+		Upstream: upstreamURL,
+		Report: func(v *xrpc.Violation) {
+			log.Printf("%v", v)
+		},
+	})
+	log.Printf("proxying %d lexicons on http://%s/xrpc/ to %s", len(lexicons.IDs()), *addr, upstreamURL)
+	if err := http.ListenAndServe(*addr, p); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
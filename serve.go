@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/xrpc"
+)
+
+// runServe implements the serve command, which runs a mock
+// XRPC server for the queries and procedures in a set of lexicons.
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	lexiconDir := flags.String("lexicons", "", "read lexicons from `dir`")
+	fixtureDir := flags.String("fixtures", "", "read the response to each endpoint from <nsid>.json in `dir`")
+	addr := flags.String("addr", "localhost:8080", "listen on `address`")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue serve -lexicons dir [flags]\n")
+		fmt.Fprintf(os.Stderr, "\nRequests with a %s header get the named error in response.\n\n", xrpc.ErrorHeader)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if *lexiconDir == "" || flags.NArg() > 0 {
+		flags.Usage()
+	}
+	fixtures, err := readFixtures(*fixtureDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	ctx := cuecontext.New()
+	lexicons, err := loadLexicons(ctx, *lexiconDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	srv, err := xrpc.NewMockServer(xrpc.NewChecker(ctx, lexicons), xrpc.MockConfig{
+		Fixtures: fixtures,
+		Logf:     log.Printf,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	log.Printf("serving %d lexicons on http://%s/xrpc/", len(lexicons.IDs()), *addr)
+	if err := http.ListenAndServe(*addr, srv); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readFixtures reads the fixture files in dir, keyed by NSID.
+// It returns no fixtures if dir is empty.
+func readFixtures(dir string) (map[string][]byte, error) {
+	fixtures := make(map[string][]byte)
+	if dir == "" {
+		return fixtures, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		nsid, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fixtures[nsid] = data
+	}
+	return fixtures, nil
+}
@@ -0,0 +1,523 @@
+// Package tsgen generates TypeScript types from atproto lexicon schemas.
+//
+// Each lexicon is generated into its own module, laid out in the
+// same way as the generated CUE (see gen.PackagePath), so the
+// types for app.bsky.feed.post are in feed.bsky.app/post.ts.
+// The generated modules refer to the types in lexicue.ts,
+// which is generated alongside them.
+//
+// Unions are generated as TypeScript unions discriminated
+// by their $type field, apart from the message unions of
+// subscriptions, which carry their type in the frame header.
+package tsgen
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/naming"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// LexicueFile holds the path of the module holding the
+// types that all the generated modules depend on.
+const LexicueFile = "lexicue.ts"
+
+//go:embed lexicue.ts
+var lexicueSource []byte
+
+// File holds a generated TypeScript source file.
+type File struct {
+	// Path holds the slash-separated path of the file
+	// relative to the output directory.
+	Path string
+
+	// Source holds the TypeScript source.
+	Source []byte
+}
+
+// Generate generates a TypeScript module for each of the given
+// lexicons, followed by the module holding the shared types.
+// All the lexicons referred to must be included.
+func Generate(schemas []*gen.Schema) ([]*File, error) {
+	g := &generator{
+		schemas: make(map[string]*gen.Schema),
+	}
+	for _, schema := range schemas {
+		g.schemas[schema.ID] = schema
+	}
+	var files []*File
+	var errs []string
+	for _, id := range sorted.Keys(g.schemas) {
+		f, err := g.generate(g.schemas[id])
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", id, err))
+			continue
+		}
+		files = append(files, f)
+	}
+	files = append(files, &File{
+		Path:   LexicueFile,
+		Source: lexicueSource,
+	})
+	if len(errs) > 0 {
+		return files, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return files, nil
+}
+
+type generator struct {
+	schemas map[string]*gen.Schema
+}
+
+// fileGen holds the state for generating the
+// TypeScript module for a single lexicon.
+type fileGen struct {
+	*generator
+	schema *gen.Schema
+	path   string
+
+	// buf holds the generated declarations.
+	buf strings.Builder
+
+	// imports maps from module path to the name used for it.
+	imports map[string]string
+
+	// importNames holds the names used for imports.
+	importNames map[string]bool
+
+	// declared holds the names of all the declared types and constants.
+	declared map[string]bool
+}
+
+func (g *generator) generate(schema *gen.Schema) (*File, error) {
+	p, err := modulePath(schema.ID)
+	if err != nil {
+		return nil, err
+	}
+	f := &fileGen{
+		generator:   g,
+		schema:      schema,
+		path:        p,
+		imports:     make(map[string]string),
+		importNames: make(map[string]bool),
+		declared:    map[string]bool{"NSID": true},
+	}
+	f.printf("/** The ID of the lexicon. */\n")
+	f.printf("export const NSID = %s;\n\n", literal(schema.ID))
+	names := naming.TypeNames(schema, "NSID")
+	for _, name := range names {
+		f.declared[name] = true
+	}
+	for _, name := range sorted.Keys(schema.Defs) {
+		if err := f.def(names[name], name, schema.Defs[name]); err != nil {
+			return nil, fmt.Errorf("bad definition %q: %v", name, err)
+		}
+	}
+	var src strings.Builder
+	fmt.Fprintf(&src, "// Code generated by lexicue gen-ts; DO NOT EDIT.\n\n")
+	if len(f.imports) > 0 {
+		for _, mpath := range sorted.Keys(f.imports) {
+			fmt.Fprintf(&src, "import type * as %s from %s;\n", f.imports[mpath], literal(relImport(p, mpath)))
+		}
+		fmt.Fprintf(&src, "\n")
+	}
+	src.WriteString(strings.TrimSuffix(f.buf.String(), "\n"))
+	return &File{
+		Path:   p,
+		Source: []byte(src.String()),
+	}, nil
+}
+
+func (f *fileGen) printf(format string, args ...any) {
+	fmt.Fprintf(&f.buf, format, args...)
+}
+
+// def generates the TypeScript declarations for the lexicon
+// definition with the given name.
+func (f *fileGen) def(tsName, name string, t *gen.TypeSchema) error {
+	typeID := gen.TypeName(f.schema.ID, name)
+	comment := tsName + " is generated from the " + typeID + " lexicon definition."
+	if t.Description != "" {
+		comment += "\n\n" + t.Description
+	}
+	switch t.Type {
+	case "token":
+		writeComment(&f.buf, "", comment)
+		f.printf("export const %s = %s;\n", tsName, literal(typeID))
+		f.printf("export type %s = typeof %s;\n\n", tsName, tsName)
+		return nil
+	case "record":
+		return f.interfaceType(tsName, comment, t.Record, literal(typeID), true)
+	case "object":
+		return f.interfaceType(tsName, comment, t, literal(typeID), false)
+	case "query", "procedure", "subscription":
+		return f.xrpcTypes(tsName, t)
+	}
+	typ, err := f.tsType(t, "")
+	if err != nil {
+		return err
+	}
+	writeComment(&f.buf, "", comment)
+	f.printf("export type %s = %s;\n\n", tsName, typ)
+	return nil
+}
+
+// xrpcTypes generates types for the parameters,
+// bodies and errors of an XRPC endpoint.
+func (f *fileGen) xrpcTypes(tsName string, t *gen.TypeSchema) error {
+	if t.Parameters != nil && len(t.Parameters.Properties) > 0 {
+		name := naming.Unique(tsName+"Params", f.declared)
+		comment := name + " holds the parameters for " + f.schema.ID + "."
+		if err := f.interfaceType(name, comment, t.Parameters, "", false); err != nil {
+			return fmt.Errorf("bad parameters: %v", err)
+		}
+	}
+	for _, body := range []struct {
+		name string
+		body *gen.BodyType
+	}{
+		{"Input", t.Input},
+		{"Output", t.Output},
+	} {
+		if body.body == nil || body.body.Schema == nil {
+			continue
+		}
+		if err := f.bodyType(naming.Unique(tsName+body.name, f.declared), strings.ToLower(body.name), body.body.Schema); err != nil {
+			return fmt.Errorf("bad %s: %v", strings.ToLower(body.name), err)
+		}
+	}
+	if t.Message != nil && t.Message.Schema != nil {
+		if err := f.bodyType(naming.Unique(tsName+"Message", f.declared), "message", t.Message.Schema); err != nil {
+			return fmt.Errorf("bad message: %v", err)
+		}
+	}
+	if len(t.Errors) > 0 {
+		name := naming.Unique(tsName+"Error", f.declared)
+		writeComment(&f.buf, "", name+" holds the name of an error returned by "+f.schema.ID+".")
+		var names []string
+		for _, e := range t.Errors {
+			names = append(names, literal(e.Name))
+		}
+		f.printf("export type %s = %s;\n\n", name, strings.Join(names, " | "))
+	}
+	return nil
+}
+
+// bodyType generates a type with the given name for
+// the schema of an XRPC body or message.
+func (f *fileGen) bodyType(tsName, what string, t *gen.TypeSchema) error {
+	comment := tsName + " holds the " + what + " for " + f.schema.ID + "."
+	if t.Type == "object" {
+		return f.interfaceType(tsName, comment, t, "", false)
+	}
+	var typ string
+	var err error
+	if what == "message" && t.Type == "union" {
+		// Subscription messages carry their type in the
+		// frame header rather than in a $type field,
+		// so there's nothing to discriminate on.
+		typ, err = f.unionType(t, false)
+	} else {
+		typ, err = f.tsType(t, "")
+	}
+	if err != nil {
+		return err
+	}
+	writeComment(&f.buf, "", comment)
+	f.printf("export type %s = %s;\n\n", tsName, typ)
+	return nil
+}
+
+// interfaceType generates an interface type for the object t.
+// If typeID is non-empty, the interface includes a $type field
+// with that type, which is required if isRecord is true.
+func (f *fileGen) interfaceType(tsName, comment string, t *gen.TypeSchema, typeID string, isRecord bool) error {
+	body, err := f.objectBody(t, typeID, isRecord, "")
+	if err != nil {
+		return err
+	}
+	writeComment(&f.buf, "", comment)
+	f.printf("export interface %s %s\n\n", tsName, body)
+	return nil
+}
+
+// objectBody returns the body of an object type for t, with
+// closing brace at the given indentation. See interfaceType
+// for the meaning of typeID and isRecord.
+func (f *fileGen) objectBody(t *gen.TypeSchema, typeID string, isRecord bool, indent string) (string, error) {
+	var buf strings.Builder
+	fieldIndent := indent + "  "
+	buf.WriteString("{\n")
+	if typeID != "" {
+		optional := "?"
+		if isRecord {
+			optional = ""
+		}
+		fmt.Fprintf(&buf, "%s$type%s: %s;\n", fieldIndent, optional, typeID)
+	}
+	for _, name := range sorted.Keys(t.Properties) {
+		pt := t.Properties[name]
+		typ, err := f.tsType(pt, fieldIndent)
+		if err != nil {
+			return "", fmt.Errorf("bad property %q: %v", name, err)
+		}
+		optional := "?"
+		if slices.Contains(t.Required, name) {
+			optional = ""
+		}
+		if slices.Contains(t.Nullable, name) {
+			typ += " | null"
+		}
+		writeComment(&buf, fieldIndent, pt.Description)
+		fmt.Fprintf(&buf, "%s%s%s: %s;\n", fieldIndent, propertyName(name), optional, typ)
+	}
+	buf.WriteString(indent + "}")
+	return buf.String(), nil
+}
+
+// tsType returns the TypeScript type expression for t. Any inline
+// object types are indented to the given level.
+func (f *fileGen) tsType(t *gen.TypeSchema, indent string) (string, error) {
+	switch t.Type {
+	case "string":
+		switch {
+		case t.Const != nil:
+			return literal(t.Const), nil
+		case len(t.Enum) > 0:
+			return literals(t.Enum), nil
+		case len(t.KnownValues) > 0:
+			// The (string & {}) allows any string while
+			// still keeping the known values for completion.
+			var values []any
+			for _, v := range t.KnownValues {
+				if def, ok := strings.CutPrefix(v, "#"); ok && f.schema.Defs[def] != nil && f.schema.Defs[def].Type == "token" {
+					// A token's value is its fully qualified name.
+					v = gen.TypeName(f.schema.ID, def)
+				}
+				values = append(values, v)
+			}
+			return literals(values) + " | (string & {})", nil
+		}
+		return "string", nil
+	case "integer", "number":
+		switch {
+		case t.Const != nil:
+			return literal(t.Const), nil
+		case len(t.Enum) > 0:
+			return literals(t.Enum), nil
+		}
+		return "number", nil
+	case "boolean":
+		if t.Const != nil {
+			return literal(t.Const), nil
+		}
+		return "boolean", nil
+	case "bytes":
+		return f.lexicue("Bytes"), nil
+	case "cid-link":
+		return f.lexicue("CIDLink"), nil
+	case "blob", "image", "video", "audio":
+		return f.lexicue("Blob"), nil
+	case "unknown":
+		return "unknown", nil
+	case "array":
+		if t.Items == nil {
+			return "", fmt.Errorf("array has no items")
+		}
+		elem, err := f.tsType(t.Items, indent)
+		if err != nil {
+			return "", err
+		}
+		if strings.ContainsAny(elem, " \n") {
+			return "Array<" + elem + ">", nil
+		}
+		return elem + "[]", nil
+	case "object", "params":
+		return f.objectBody(t, "", false, indent)
+	case "ref":
+		return f.refType(t.Ref)
+	case "union":
+		return f.unionType(t, true)
+	}
+	return "", fmt.Errorf("unsupported type %q", t.Type)
+}
+
+// refType returns the TypeScript type for a reference to the definition with the given name.
+func (f *fileGen) refType(ref string) (string, error) {
+	id, def, t, err := gen.ResolveRef(f.schemas, f.schema.ID, ref)
+	if err != nil {
+		return "", err
+	}
+	switch t.Type {
+	case "query", "procedure", "subscription":
+		return "", fmt.Errorf("cannot refer to %s definition %q", t.Type, ref)
+	}
+	name := naming.TypeNames(f.schemas[id], "NSID")[def]
+	if id == f.schema.ID {
+		return name, nil
+	}
+	p, err := modulePath(id)
+	if err != nil {
+		return "", err
+	}
+	return f.importName(p) + "." + name, nil
+}
+
+// unionType returns a union of the members of t. If tagged is true,
+// each member has a required $type field so that the union is
+// discriminated by it, and unless the union is closed, objects
+// of any other type are allowed too.
+func (f *fileGen) unionType(t *gen.TypeSchema, tagged bool) (string, error) {
+	var members []string
+	for _, ref := range t.Refs {
+		typ, err := f.refType(ref)
+		if err != nil {
+			return "", err
+		}
+		_, _, rt, _ := gen.ResolveRef(f.schemas, f.schema.ID, ref)
+		if tagged && rt.Type == "object" {
+			// Records already have a required $type field,
+			// but it's optional for objects.
+			typeID := gen.TypeName(gen.SplitRef(f.schema.ID, ref))
+			typ = "(" + typ + " & { $type: " + literal(typeID) + " })"
+		}
+		members = append(members, typ)
+	}
+	if tagged && !t.Closed {
+		members = append(members, f.lexicue("Unknown"))
+	}
+	if len(members) == 0 {
+		return "never", nil
+	}
+	return strings.Join(members, " | "), nil
+}
+
+// lexicue returns a qualified reference to the given
+// name in the lexicue module.
+func (f *fileGen) lexicue(name string) string {
+	return f.importName(LexicueFile) + "." + name
+}
+
+// importName adds an import of the module with the
+// given path and returns the name to use for it.
+func (f *fileGen) importName(mpath string) string {
+	if name, ok := f.imports[mpath]; ok {
+		return name
+	}
+	name := naming.Identifier(strings.TrimSuffix(path.Base(mpath), ".ts"))
+	if name == "defs" || f.importNames[name] || reserved[name] {
+		// defs is commonly used and meaningless, so
+		// qualify it with the first element of its parent
+		// directory, as in feedDefs for feed.bsky.app/defs.
+		parent, _, _ := strings.Cut(path.Base(path.Dir(mpath)), ".")
+		name = naming.Unique(naming.Identifier(parent)+naming.Exported(name), f.importNames)
+	}
+	f.importNames[name] = true
+	f.imports[mpath] = name
+	return name
+}
+
+// modulePath returns the path of the module generated
+// for the lexicon with the given ID.
+func modulePath(id string) (string, error) {
+	p, err := gen.PackagePath("", id)
+	if err != nil {
+		return "", err
+	}
+	return p + ".ts", nil
+}
+
+// relImport returns the module specifier used to import
+// the module at path to from the module at path from.
+// Imports use a .js extension, as TypeScript requires
+// for ECMAScript modules.
+func relImport(from, to string) string {
+	to = strings.TrimSuffix(to, ".ts") + ".js"
+	dir := path.Dir(from)
+	prefix := "./"
+	for dir != "." && !strings.HasPrefix(to, dir+"/") {
+		dir = path.Dir(dir)
+		prefix += "../"
+	}
+	if dir != "." {
+		to = strings.TrimPrefix(to, dir+"/")
+	}
+	if strings.HasPrefix(prefix, "./../") {
+		prefix = prefix[len("./"):]
+	}
+	return prefix + to
+}
+
+// reserved holds the JavaScript reserved words that
+// might plausibly be used as lexicon name elements.
+var reserved = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true,
+	"const": true, "continue": true, "debugger": true, "default": true,
+	"delete": true, "do": true, "else": true, "enum": true,
+	"export": true, "extends": true, "false": true, "finally": true,
+	"for": true, "function": true, "if": true, "import": true,
+	"in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true,
+	"throw": true, "true": true, "try": true, "typeof": true,
+	"var": true, "void": true, "while": true, "with": true,
+}
+
+var identPat = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// propertyName returns name in a form suitable for
+// use as a property name in an object type.
+func propertyName(name string) string {
+	if identPat.MatchString(name) {
+		return name
+	}
+	return literal(name)
+}
+
+// literal returns x, which must be a JSON-compatible
+// scalar, as a TypeScript literal.
+func literal(x any) string {
+	data, err := json.Marshal(x)
+	if err != nil {
+		// Can't happen: lexicon values come from JSON.
+		panic(err)
+	}
+	return string(data)
+}
+
+// literals returns a union of the literal types of xs.
+func literals(xs []any) string {
+	lits := make([]string, len(xs))
+	for i, x := range xs {
+		lits[i] = literal(x)
+	}
+	return strings.Join(lits, " | ")
+}
+
+// writeComment writes text to buf as a documentation
+// comment with each line prefixed by indent.
+func writeComment(buf *strings.Builder, indent, text string) {
+	lines := naming.CommentLines(strings.ReplaceAll(text, "*/", "*\\/"))
+	switch len(lines) {
+	case 0:
+		return
+	case 1:
+		fmt.Fprintf(buf, "%s/** %s */\n", indent, lines[0])
+		return
+	}
+	fmt.Fprintf(buf, "%s/**\n", indent)
+	for _, line := range lines {
+		if line == "" {
+			fmt.Fprintf(buf, "%s *\n", indent)
+		} else {
+			fmt.Fprintf(buf, "%s * %s\n", indent, line)
+		}
+	}
+	fmt.Fprintf(buf, "%s */\n", indent)
+}
@@ -0,0 +1,141 @@
+package tsgen_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/tsgen"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	schemas, err := gen.ReadLexicons("../gen/testdata/lexicons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := tsgen.Generate(schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&buf, "-- %s --\n%s\n", f.Path, bytes.TrimSuffix(f.Source, []byte("\n")))
+	}
+	const golden = "testdata/lexicons.txtar"
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("output differs from %s; run with -update to update it", golden)
+	}
+}
+
+var typeTests = []struct {
+	testName   string
+	properties string
+	required   []string
+	nullable   []string
+	// want holds the expected declaration of each property.
+	want []string
+}{{
+	testName:   "OptionalAndNullable",
+	properties: `{"a": {"type": "string"}, "b": {"type": "string"}, "c": {"type": "string"}, "d": {"type": "string"}}`,
+	required:   []string{"a", "b"},
+	nullable:   []string{"b", "d"},
+	want: []string{
+		"a: string;",
+		"b: string | null;",
+		"c?: string;",
+		"d?: string | null;",
+	},
+}, {
+	testName:   "KnownValues",
+	properties: `{"s": {"type": "string", "knownValues": ["plain", "#tok", "com.example.other#tok"]}}`,
+	want: []string{
+		`s?: "plain" | "com.example.test#tok" | "com.example.other#tok" | (string & {});`,
+	},
+}, {
+	testName:   "Enum",
+	properties: `{"s": {"type": "string", "enum": ["x", "y"]}}`,
+	want: []string{
+		`s?: "x" | "y";`,
+	},
+}, {
+	testName:   "OpenUnion",
+	properties: `{"u": {"type": "union", "refs": ["#obj", "com.example.other"]}}`,
+	want: []string{
+		`u?: (Obj & { $type: "com.example.test#obj" }) | other.Other | lexicue.Unknown;`,
+	},
+}, {
+	testName:   "ClosedUnion",
+	properties: `{"u": {"type": "union", "refs": ["#obj", "com.example.other"], "closed": true}}`,
+	want: []string{
+		`u?: (Obj & { $type: "com.example.test#obj" }) | other.Other;`,
+	},
+}}
+
+func TestTypes(t *testing.T) {
+	other := testSchema(t, "com.example.other", `{
+		"main": {"type": "record", "key": "tid", "record": {"type": "object", "properties": {}}},
+		"tok": {"type": "token"}
+	}`)
+	for _, test := range typeTests {
+		t.Run(test.testName, func(t *testing.T) {
+			main, err := json.Marshal(map[string]any{
+				"type":       "object",
+				"properties": json.RawMessage(test.properties),
+				"required":   test.required,
+				"nullable":   test.nullable,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema := testSchema(t, "com.example.test", `{
+				"main": `+string(main)+`,
+				"obj": {"type": "object", "properties": {}},
+				"tok": {"type": "token"}
+			}`)
+			files, err := tsgen.Generate([]*gen.Schema{schema, other})
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := string(files[1].Source)
+			_, body, _ := strings.Cut(src, "export interface Test {\n")
+			body, _, _ = strings.Cut(body, "\n}")
+			var got []string
+			for _, line := range strings.Split(body, "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "$type") {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("unexpected properties\ngot:\n%s\nwant:\n%s\nsource:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"), src)
+			}
+		})
+	}
+}
+
+// testSchema returns a lexicon with the given ID and definitions.
+func testSchema(t *testing.T, id, defs string) *gen.Schema {
+	t.Helper()
+	var schema gen.Schema
+	data := `{"lexicon": 1, "id": "` + id + `", "defs": ` + defs + `}`
+	if err := json.Unmarshal([]byte(data), &schema); err != nil {
+		t.Fatal(err)
+	}
+	return &schema
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+// runValidate implements the validate command, which checks
+// JSON instances against the CUE generated from a set of lexicons.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	lexiconDir := flags.String("lexicons", "", "read lexicons from `dir`")
+	defName := flags.String("def", "", "validate against the lexicon definition `nsid[#name]` rather than the one named by each instance's $type")
+	part := flags.String("part", "", "validate against the given `part` of the definition (one of "+strings.Join(gen.PartNames(), ", ")+"); records default to record")
+	jsonLines := flags.Bool("l", false, "treat all input as JSON Lines (implied for files ending in .jsonl or .ndjson)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lexicue validate -lexicons dir [flags] [file.json | file.jsonl | -]...\n")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if *lexiconDir == "" || flags.NArg() < 1 {
+		flags.Usage()
+	}
+	if *part != "" && !slices.Contains(gen.PartNames(), *part) {
+		flags.Usage()
+	}
+	ctx := cuecontext.New()
+	lexicons, err := loadLexicons(ctx, *lexiconDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	v := &validator{
+		ctx:      ctx,
+		lexicons: lexicons,
+		defName:  *defName,
+		part:     *part,
+		schemas:  make(map[string]cue.Value),
+	}
+	exitStatus := 0
+	for _, arg := range flags.Args() {
+		var data []byte
+		var err error
+		if arg == "-" {
+			data, err = io.ReadAll(os.Stdin)
+			arg = "<stdin>"
+		} else {
+			data, err = os.ReadFile(arg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitStatus = 1
+			continue
+		}
+		var ok bool
+		if *jsonLines || strings.HasSuffix(arg, ".jsonl") || strings.HasSuffix(arg, ".ndjson") {
+			ok = v.validateLines(arg, data)
+		} else {
+			ok = v.validate(arg, arg, data)
+		}
+		if !ok {
+			exitStatus = 1
+		}
+	}
+	return exitStatus
+}
+
+// loadLexicons generates CUE for all the lexicons in dir, after
+// checking the references between them, and loads it without
+// writing it to disk.
+func loadLexicons(ctx *cue.Context, dir string) (*gen.Lexicons, error) {
+	// Use a single package so that there's no
+	// possibility of import cycles.
+	g, err := gen.New(gen.Config{
+		ModuleRoot: "lexicon.me/defs",
+		UseMap:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := gen.ReadLexicons(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := gen.ResolveRefs(schemas); err != nil {
+		return nil, err
+	}
+	var files []*gen.File
+	var errs []string
+	for _, schema := range schemas {
+		f, err := g.Generate(schema)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return g.Load(ctx, files)
+}
+
+// validator validates JSON instances against lexicons.
+type validator struct {
+	ctx      *cue.Context
+	lexicons *gen.Lexicons
+	defName  string
+	part     string
+
+	// schemas caches the schema for each definition name.
+	schemas map[string]cue.Value
+}
+
+// validateLines validates each line of data as a separate instance,
+// ignoring blank lines. It reports whether all the instances are valid.
+func (v *validator) validateLines(filename string, data []byte) bool {
+	ok := true
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if !v.validate(fmt.Sprintf("%s:%d", filename, lineNum), filename, line) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// validate validates a single JSON instance, printing any errors
+// prefixed with the given location. It reports whether
+// the instance is valid.
+func (v *validator) validate(location, filename string, data []byte) bool {
+	schema, err := v.schemaFor(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", location, err)
+		return false
+	}
+	err = gen.ValidateInstance(v.ctx, schema, data, filename)
+	if err == nil {
+		return true
+	}
+	if errs, ok := err.(gen.InstanceErrors); ok {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", location, e)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", location, err)
+	}
+	return false
+}
+
+// schemaFor returns the schema to validate the JSON instance in data against.
+func (v *validator) schemaFor(data []byte) (cue.Value, error) {
+	name := v.defName
+	if name == "" {
+		var typed struct {
+			Type string `json:"$type"`
+		}
+		if err := json.Unmarshal(data, &typed); err != nil {
+			return cue.Value{}, err
+		}
+		if typed.Type == "" {
+			return cue.Value{}, fmt.Errorf("no $type field found (use -def to specify the definition)")
+		}
+		name = typed.Type
+	}
+	if schema, ok := v.schemas[name]; ok {
+		return schema, nil
+	}
+	id, defName, _ := strings.Cut(name, "#")
+	if defName == "" {
+		defName = "main"
+	}
+	lex := v.lexicons.Schema(id)
+	if lex == nil || lex.Defs[defName] == nil {
+		// Let Def produce the error.
+		_, err := v.lexicons.Def(name)
+		return cue.Value{}, err
+	}
+	part := v.part
+	if part == "" {
+		switch t := lex.Defs[defName]; t.Type {
+		case "record":
+			part = "record"
+		case "query", "procedure", "subscription":
+			return cue.Value{}, fmt.Errorf("definition %q is an XRPC %s (use -part to specify what to validate)", name, t.Type)
+		}
+	}
+	var schema cue.Value
+	var err error
+	if part != "" {
+		schema, err = v.lexicons.Part(name, part)
+	} else {
+		schema, err = v.lexicons.Def(name)
+	}
+	if err != nil {
+		return cue.Value{}, err
+	}
+	v.schemas[name] = schema
+	return schema, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPost = `{"$type": "app.bsky.feed.post", "text": "hello", "createdAt": "2023-04-05T06:07:08Z"}`
+
+var validateTests = []struct {
+	testName string
+	// file holds the name of the instance file, which is
+	// passed to the command after args, and data its contents.
+	file       string
+	data       string
+	args       []string
+	wantStatus int
+	wantStderr string
+}{{
+	testName: "Type",
+	file:     "post.json",
+	data:     testPost,
+}, {
+	testName:   "TypeInvalid",
+	file:       "post.json",
+	data:       `{"$type": "app.bsky.feed.post", "text": "hello"}`,
+	wantStatus: 1,
+	wantStderr: "post.json: createdAt: field is required but not present\n",
+}, {
+	testName: "Graphemes",
+	// The problem is reported at the text itself rather
+	// than at the hidden field holding its length.
+	file:       "post.json",
+	data:       `{"$type": "app.bsky.feed.post", "text": "` + strings.Repeat("é", 301) + `", "createdAt": "2023-04-05T06:07:08Z"}`,
+	wantStatus: 1,
+	wantStderr: "post.json: text: invalid value 301 (out of bound <=300)\n",
+}, {
+	testName:   "NoType",
+	file:       "post.json",
+	data:       `{"text": "hello"}`,
+	wantStatus: 1,
+	wantStderr: "post.json: no $type field found (use -def to specify the definition)\n",
+}, {
+	testName:   "UnknownType",
+	file:       "post.json",
+	data:       `{"$type": "com.example.nonexistent"}`,
+	wantStatus: 1,
+	wantStderr: "post.json: unknown lexicon \"com.example.nonexistent\"\n",
+}, {
+	testName: "Def",
+	file:     "ref.json",
+	data:     `{"uri": "at://did:plc:z72i7hdynmk6r22z27h6tvur/app.bsky.feed.post/3k2a", "cid": "bafyreigh2akiscaildc"}`,
+	args:     []string{"-def", "com.atproto.repo.strongRef"},
+}, {
+	testName: "DefOverridesType",
+	// The instance is checked against the given definition,
+	// which doesn't allow its $type.
+	file:       "post.json",
+	data:       testPost,
+	args:       []string{"-def", "com.atproto.repo.strongRef"},
+	wantStatus: 1,
+	wantStderr: "post.json: $type: conflicting values \"app.bsky.feed.post\" and \"com.atproto.repo.strongRef\"\npost.json: text: field not allowed\npost.json: createdAt: field not allowed\n",
+}, {
+	testName:   "DefXRPC",
+	file:       "input.json",
+	data:       `{}`,
+	args:       []string{"-def", "com.atproto.repo.createRecord"},
+	wantStatus: 1,
+	wantStderr: "input.json: definition \"com.atproto.repo.createRecord\" is an XRPC procedure (use -part to specify what to validate)\n",
+}, {
+	testName:   "PartInput",
+	file:       "input.json",
+	data:       `{"repo": "alice.bsky.social", "collection": "app.bsky.feed.post"}`,
+	args:       []string{"-def", "com.atproto.repo.createRecord", "-part", "input"},
+	wantStatus: 1,
+	wantStderr: "input.json: record: field is required but not present\n",
+}, {
+	testName:   "PartOutput",
+	file:       "output.json",
+	data:       `{"uri": "at://did:plc:z72i7hdynmk6r22z27h6tvur/app.bsky.feed.post/3k2a", "cid": "x"}`,
+	args:       []string{"-def", "com.atproto.repo.createRecord", "-part", "output"},
+	wantStatus: 1,
+	wantStderr: "output.json: cid: invalid value \"x\" (out of bound =~\"^[a-zA-Z0-9+=]{8,256}$\")\n",
+}, {
+	testName: "JSONLines",
+	// Line numbers count blank lines too.
+	file:       "posts.jsonl",
+	data:       testPost + "\n\n" + `{"$type": "app.bsky.feed.post", "text": "hello"}` + "\n" + testPost + "\n",
+	wantStatus: 1,
+	wantStderr: "posts.jsonl:3: createdAt: field is required but not present\n",
+}, {
+	testName:   "JSONLinesFlag",
+	file:       "posts.txt",
+	data:       testPost + "\n" + `{"$type": "app.bsky.feed.post", "createdAt": "2023-04-05T06:07:08Z"}` + "\n",
+	args:       []string{"-l"},
+	wantStatus: 1,
+	wantStderr: "posts.txt:2: text: field is required but not present\n",
+}}
+
+func TestValidateCommand(t *testing.T) {
+	for _, test := range validateTests {
+		t.Run(test.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			p := filepath.Join(dir, test.file)
+			if err := os.WriteFile(p, []byte(test.data), 0o666); err != nil {
+				t.Fatal(err)
+			}
+			args := append([]string{"validate", "-lexicons", "gen/testdata/lexicons"}, test.args...)
+			_, stderr, status := runLexicueStatus(t, append(args, p)...)
+			if status != test.wantStatus {
+				t.Errorf("got exit status %d, want %d; stderr:\n%s", status, test.wantStatus, stderr)
+			}
+			// Make the file names in the output independent
+			// of the temporary directory.
+			got := strings.ReplaceAll(string(stderr), dir+string(filepath.Separator), "")
+			if got != test.wantStderr {
+				t.Errorf("unexpected error output\ngot:\n%s\nwant:\n%s", got, test.wantStderr)
+			}
+		})
+	}
+}
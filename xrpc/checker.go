@@ -0,0 +1,157 @@
+// Package xrpc checks XRPC requests and responses against the CUE
+// generated from lexicons, and implements a mock XRPC server
+// and a validating reverse proxy that make use of those checks.
+package xrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"cuelang.org/go/cue"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+// Checker checks XRPC requests and responses against a set of
+// lexicons. It is safe to call its methods concurrently.
+type Checker struct {
+	// mu guards ctx and the values derived from it,
+	// which are not safe for concurrent use.
+	mu       sync.Mutex
+	ctx      *cue.Context
+	lexicons *gen.Lexicons
+}
+
+// NewChecker returns a Checker that checks against the given lexicons,
+// which must have been loaded using ctx. The caller should not
+// use ctx or lexicons after calling NewChecker.
+func NewChecker(ctx *cue.Context, lexicons *gen.Lexicons) *Checker {
+	return &Checker{
+		ctx:      ctx,
+		lexicons: lexicons,
+	}
+}
+
+// Endpoint returns the definition of the query or
+// procedure with the given NSID.
+func (c *Checker) Endpoint(nsid string) (*gen.TypeSchema, error) {
+	lex := c.lexicons.Schema(nsid)
+	if lex == nil {
+		return nil, fmt.Errorf("unknown lexicon %q", nsid)
+	}
+	t := lex.Defs["main"]
+	if t == nil || (t.Type != "query" && t.Type != "procedure") {
+		return nil, fmt.Errorf("%q is not a query or procedure", nsid)
+	}
+	return t, nil
+}
+
+// CheckParams checks the query parameters of a request to the
+// endpoint with the given NSID. The values are converted to the
+// types declared by the lexicon before checking them, so
+// an invalid integer, for example, results in an error.
+//
+// If the parameters are well formed but don't conform
+// to the lexicon, the returned error is of type gen.InstanceErrors.
+func (c *Checker) CheckParams(nsid string, query url.Values) error {
+	t, err := c.Endpoint(nsid)
+	if err != nil {
+		return err
+	}
+	if t.Parameters == nil {
+		// There's nothing to check against.
+		return nil
+	}
+	data, err := paramsJSON(t.Parameters, query)
+	if err != nil {
+		return err
+	}
+	return c.check(nsid, "parameters", data)
+}
+
+// CheckBody checks the JSON in data against the schema for the
+// given part of the endpoint with the given NSID, which must be
+// one of "input", "output" or "error". It returns an error if
+// the endpoint has no schema for the part.
+//
+// If the body is valid JSON but doesn't conform
+// to the lexicon, the returned error is of type gen.InstanceErrors.
+func (c *Checker) CheckBody(nsid, part string, data []byte) error {
+	if _, err := c.Endpoint(nsid); err != nil {
+		return err
+	}
+	return c.check(nsid, part, data)
+}
+
+func (c *Checker) check(nsid, part string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	schema, err := c.lexicons.Part(nsid, part)
+	if err != nil {
+		return err
+	}
+	return gen.ValidateInstance(c.ctx, schema, data, nsid+" "+part)
+}
+
+// paramsJSON returns the given query parameters as a JSON object,
+// with the values converted to the types declared by params.
+// Parameters not declared by params are left as strings.
+func paramsJSON(params *gen.TypeSchema, query url.Values) ([]byte, error) {
+	obj := make(map[string]any)
+	for name, values := range query {
+		pt := params.Properties[name]
+		switch {
+		case pt == nil:
+			if len(values) == 1 {
+				obj[name] = values[0]
+			} else {
+				obj[name] = values
+			}
+		case pt.Type == "array":
+			if pt.Items == nil {
+				return nil, fmt.Errorf("parameter %q has no item type", name)
+			}
+			list := make([]any, len(values))
+			for i, v := range values {
+				x, err := paramValue(pt.Items, v)
+				if err != nil {
+					return nil, fmt.Errorf("bad value for parameter %q: %v", name, err)
+				}
+				list[i] = x
+			}
+			obj[name] = list
+		default:
+			if len(values) > 1 {
+				return nil, fmt.Errorf("parameter %q specified more than once", name)
+			}
+			x, err := paramValue(pt, values[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad value for parameter %q: %v", name, err)
+			}
+			obj[name] = x
+		}
+	}
+	return json.Marshal(obj)
+}
+
+// paramValue converts the query parameter value v to the type t.
+func paramValue(t *gen.TypeSchema, v string) (any, error) {
+	switch t.Type {
+	case "integer":
+		return strconv.ParseInt(v, 10, 64)
+	case "number":
+		return strconv.ParseFloat(v, 64)
+	case "boolean":
+		switch v {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean %q", v)
+	}
+	return v, nil
+}
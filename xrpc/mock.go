@@ -0,0 +1,190 @@
+package xrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/internal/sorted"
+)
+
+// ErrorHeader holds the name of the request header that asks
+// MockServer to respond with the error named in the header value.
+// The error must be declared by the endpoint's lexicon
+// or be one of the generic XRPC errors.
+const ErrorHeader = "Lexicue-Error"
+
+// genericErrors maps from each generic XRPC error, which any
+// endpoint may return, to the HTTP status used for it.
+var genericErrors = map[string]int{
+	"InvalidRequest":         http.StatusBadRequest,
+	"ExpiredToken":           http.StatusBadRequest,
+	"InvalidToken":           http.StatusBadRequest,
+	"AuthenticationRequired": http.StatusUnauthorized,
+	"Forbidden":              http.StatusForbidden,
+	"PayloadTooLarge":        http.StatusRequestEntityTooLarge,
+	"RateLimitExceeded":      http.StatusTooManyRequests,
+	"InternalServerError":    http.StatusInternalServerError,
+	"MethodNotImplemented":   http.StatusNotImplemented,
+	"UpstreamFailure":        http.StatusBadGateway,
+	"NotEnoughResources":     http.StatusServiceUnavailable,
+	"UpstreamTimeout":        http.StatusGatewayTimeout,
+}
+
+// MockConfig holds configuration for NewMockServer.
+type MockConfig struct {
+	// Fixtures maps from endpoint NSID to the JSON body
+	// of the response to successful requests.
+	Fixtures map[string][]byte
+
+	// Logf is used to log each request, if it's not nil.
+	Logf func(format string, args ...any)
+}
+
+// MockServer is an http.Handler that serves the queries and
+// procedures in a set of lexicons under /xrpc/, checking each
+// request against the lexicons.
+//
+// Valid requests get the fixture response for the endpoint, or
+// an error if the request has an ErrorHeader header. Endpoints
+// without fixtures respond with a MethodNotImplemented error,
+// unless they have no output, in which case they respond with
+// an empty body.
+type MockServer struct {
+	checker *Checker
+	cfg     MockConfig
+}
+
+// NewMockServer returns a MockServer that serves the endpoints
+// known to c. It returns an error if any of the fixtures
+// don't conform to the lexicons.
+func NewMockServer(c *Checker, cfg MockConfig) (*MockServer, error) {
+	var errs []string
+	for _, nsid := range sorted.Keys(cfg.Fixtures) {
+		t, err := c.Endpoint(nsid)
+		if err == nil && (t.Output == nil || t.Output.Schema == nil) {
+			err = fmt.Errorf("endpoint has no output schema")
+		}
+		if err == nil {
+			err = c.CheckBody(nsid, "output", cfg.Fixtures[nsid])
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("fixture for %s: %v", nsid, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	if cfg.Logf == nil {
+		cfg.Logf = func(string, ...any) {}
+	}
+	return &MockServer{
+		checker: c,
+		cfg:     cfg,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *MockServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	nsid, ok := strings.CutPrefix(req.URL.Path, "/xrpc/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	status, resp := s.serve(nsid, req)
+	s.cfg.Logf("%s %s: %d", req.Method, req.URL, status)
+	if status != http.StatusOK {
+		s.cfg.Logf("\t%s", resp)
+	}
+	if resp != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// serve returns the status and JSON body of
+// the response to the request for endpoint nsid.
+func (s *MockServer) serve(nsid string, req *http.Request) (int, []byte) {
+	t, err := s.checker.Endpoint(nsid)
+	if err != nil {
+		return errorResponse(http.StatusNotImplemented, "MethodNotImplemented", err.Error())
+	}
+	method := http.MethodGet
+	if t.Type == "procedure" {
+		method = http.MethodPost
+	}
+	if req.Method != method {
+		return errorResponse(http.StatusMethodNotAllowed, "InvalidRequest", fmt.Sprintf("%s requires the %s method", nsid, method))
+	}
+	if err := s.checker.CheckParams(nsid, req.URL.Query()); err != nil {
+		return errorResponse(http.StatusBadRequest, "InvalidRequest", "invalid parameters: "+err.Error())
+	}
+	if t.Input != nil {
+		if err := s.checkInput(nsid, t.Input, req); err != nil {
+			return errorResponse(http.StatusBadRequest, "InvalidRequest", "invalid input: "+err.Error())
+		}
+	}
+	if name := req.Header.Get(ErrorHeader); name != "" {
+		if status, ok := genericErrors[name]; ok {
+			return errorResponse(status, name, "mock error")
+		}
+		for _, e := range t.Errors {
+			if e.Name == name {
+				return errorResponse(http.StatusBadRequest, name, "mock error")
+			}
+		}
+		return errorResponse(http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("error %q is not declared by %s", name, nsid))
+	}
+	if t.Output == nil {
+		return http.StatusOK, nil
+	}
+	fixture, ok := s.cfg.Fixtures[nsid]
+	if !ok {
+		return errorResponse(http.StatusNotImplemented, "MethodNotImplemented", "no fixture for "+nsid)
+	}
+	return http.StatusOK, fixture
+}
+
+// checkInput checks that the body of req conforms to
+// the input of the endpoint with the given NSID.
+func (s *MockServer) checkInput(nsid string, input *gen.BodyType, req *http.Request) error {
+	if err := checkContentType(req.Header, input.Encoding); err != nil {
+		return err
+	}
+	if input.Schema == nil {
+		return nil
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return s.checker.CheckBody(nsid, "input", data)
+}
+
+// mediaTypeMatches reports whether the media type t matches
+// the lexicon encoding pattern, which may be a range
+// such as "*/*" or "image/*".
+func mediaTypeMatches(pattern, t string) bool {
+	if pattern == "*/*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(t, prefix)
+	}
+	return t == pattern
+}
+
+func errorResponse(status int, name, msg string) (int, []byte) {
+	data, err := json.Marshal(struct {
+		Error   string `json:"error"`
+		Message string `json:"message,omitempty"`
+	}{name, msg})
+	if err != nil {
+		panic(err)
+	}
+	return status, data
+}
@@ -0,0 +1,227 @@
+package xrpc_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/rogpeppe/lexicue/gen"
+	"github.com/rogpeppe/lexicue/xrpc"
+)
+
+// newChecker returns a Checker for the lexicons in gen/testdata/lexicons.
+func newChecker(t *testing.T) *xrpc.Checker {
+	t.Helper()
+	schemas, err := gen.ReadLexicons("../gen/testdata/lexicons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gen.New(gen.Config{
+		ModuleRoot: "lexicon.me/defs",
+		UseMap:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*gen.File
+	for _, schema := range schemas {
+		f, err := g.Generate(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	ctx := cuecontext.New()
+	l, err := g.Load(ctx, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xrpc.NewChecker(ctx, l)
+}
+
+const timelineFixture = `{"feed":[],"cursor":"c1"}`
+
+var mockTests = []struct {
+	testName    string
+	method      string
+	path        string
+	header      http.Header
+	body        string
+	wantStatus  int
+	wantError   string
+	wantBody    string
+	wantMessage string
+}{{
+	testName:   "QueryWithFixture",
+	method:     "GET",
+	path:       "/xrpc/app.bsky.feed.getTimeline?limit=10",
+	wantStatus: http.StatusOK,
+	wantBody:   timelineFixture,
+}, {
+	testName:    "ParamOutOfRange",
+	method:      "GET",
+	path:        "/xrpc/app.bsky.feed.getTimeline?limit=1000",
+	wantStatus:  http.StatusBadRequest,
+	wantError:   "InvalidRequest",
+	wantMessage: "invalid parameters",
+}, {
+	testName:    "ParamWrongType",
+	method:      "GET",
+	path:        "/xrpc/app.bsky.feed.getTimeline?limit=lots",
+	wantStatus:  http.StatusBadRequest,
+	wantError:   "InvalidRequest",
+	wantMessage: `bad value for parameter "limit"`,
+}, {
+	testName:   "WrongMethod",
+	method:     "POST",
+	path:       "/xrpc/app.bsky.feed.getTimeline",
+	wantStatus: http.StatusMethodNotAllowed,
+	wantError:  "InvalidRequest",
+}, {
+	testName:   "UnknownEndpoint",
+	method:     "GET",
+	path:       "/xrpc/com.example.nothing",
+	wantStatus: http.StatusNotImplemented,
+	wantError:  "MethodNotImplemented",
+}, {
+	testName:   "NotXRPC",
+	method:     "GET",
+	path:       "/other",
+	wantStatus: http.StatusNotFound,
+}, {
+	testName:   "DeclaredError",
+	method:     "GET",
+	path:       "/xrpc/app.bsky.feed.getTimeline",
+	header:     http.Header{xrpc.ErrorHeader: {"BlockedActor"}},
+	wantStatus: http.StatusBadRequest,
+	wantError:  "BlockedActor",
+}, {
+	testName:   "GenericError",
+	method:     "GET",
+	path:       "/xrpc/app.bsky.feed.getTimeline",
+	header:     http.Header{xrpc.ErrorHeader: {"RateLimitExceeded"}},
+	wantStatus: http.StatusTooManyRequests,
+	wantError:  "RateLimitExceeded",
+}, {
+	testName:    "UndeclaredError",
+	method:      "GET",
+	path:        "/xrpc/app.bsky.feed.getTimeline",
+	header:      http.Header{xrpc.ErrorHeader: {"InvalidSwap"}},
+	wantStatus:  http.StatusBadRequest,
+	wantError:   "InvalidRequest",
+	wantMessage: `error "InvalidSwap" is not declared`,
+}, {
+	testName:   "ProcedureWithoutFixture",
+	method:     "POST",
+	path:       "/xrpc/com.atproto.repo.createRecord",
+	header:     http.Header{"Content-Type": {"application/json"}},
+	body:       `{"repo":"alice.test","collection":"app.bsky.feed.post","record":{}}`,
+	wantStatus: http.StatusNotImplemented,
+	wantError:  "MethodNotImplemented",
+}, {
+	testName:    "InvalidInput",
+	method:      "POST",
+	path:        "/xrpc/com.atproto.repo.createRecord",
+	header:      http.Header{"Content-Type": {"application/json"}},
+	body:        `{"repo":"alice.test","record":{}}`,
+	wantStatus:  http.StatusBadRequest,
+	wantError:   "InvalidRequest",
+	wantMessage: "invalid input",
+}, {
+	testName:    "WrongContentType",
+	method:      "POST",
+	path:        "/xrpc/com.atproto.repo.createRecord",
+	header:      http.Header{"Content-Type": {"text/plain"}},
+	body:        `{"repo":"alice.test","collection":"app.bsky.feed.post","record":{}}`,
+	wantStatus:  http.StatusBadRequest,
+	wantError:   "InvalidRequest",
+	wantMessage: `content type "text/plain" does not match "application/json"`,
+}, {
+	testName:   "ErrorAfterValidInput",
+	method:     "POST",
+	path:       "/xrpc/com.atproto.repo.createRecord",
+	header:     http.Header{"Content-Type": {"application/json"}, xrpc.ErrorHeader: {"InvalidSwap"}},
+	body:       `{"repo":"alice.test","collection":"app.bsky.feed.post","record":{}}`,
+	wantStatus: http.StatusBadRequest,
+	wantError:  "InvalidSwap",
+}}
+
+func TestMockServer(t *testing.T) {
+	s, err := xrpc.NewMockServer(newChecker(t), xrpc.MockConfig{
+		Fixtures: map[string][]byte{
+			"app.bsky.feed.getTimeline": []byte(timelineFixture),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	for _, test := range mockTests {
+		t.Run(test.testName, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, srv.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("got status %d, want %d; body %s", resp.StatusCode, test.wantStatus, body)
+			}
+			if test.wantBody != "" && string(body) != test.wantBody {
+				t.Errorf("got body %s, want %s", body, test.wantBody)
+			}
+			if test.wantError == "" {
+				return
+			}
+			var e struct {
+				Error   string `json:"error"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(body, &e); err != nil {
+				t.Fatalf("cannot unmarshal error response %q: %v", body, err)
+			}
+			if e.Error != test.wantError {
+				t.Errorf("got error %q, want %q (message %q)", e.Error, test.wantError, e.Message)
+			}
+			if !strings.Contains(e.Message, test.wantMessage) {
+				t.Errorf("got message %q, want it to contain %q", e.Message, test.wantMessage)
+			}
+		})
+	}
+}
+
+func TestMockServerBadFixtures(t *testing.T) {
+	_, err := xrpc.NewMockServer(newChecker(t), xrpc.MockConfig{
+		Fixtures: map[string][]byte{
+			"app.bsky.feed.getTimeline":       []byte(`{"cursor":"c1"}`),
+			"com.atproto.sync.subscribeRepos": []byte(`{}`),
+		},
+	})
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	for _, want := range []string{
+		"fixture for app.bsky.feed.getTimeline:",
+		`fixture for com.atproto.sync.subscribeRepos: "com.atproto.sync.subscribeRepos" is not a query or procedure`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package xrpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/rogpeppe/lexicue/gen"
+)
+
+// Violation describes a way in which XRPC traffic
+// doesn't conform to the lexicons.
+type Violation struct {
+	// NSID holds the ID of the endpoint.
+	NSID string
+
+	// Direction holds "request" or "response".
+	Direction string
+
+	// Part holds the part of the endpoint definition that
+	// was checked: one of "parameters", "input", "output" or "error".
+	// It's empty when the problem isn't specific to any part,
+	// such as when the endpoint is unknown.
+	Part string
+
+	// Path holds the path to the problem within the checked value,
+	// as reported by CUE, or nil if there's no specific location.
+	Path []string
+
+	// Msg holds a description of the problem.
+	Msg string
+}
+
+func (v *Violation) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s %s", v.NSID, v.Direction)
+	if v.Part != "" {
+		fmt.Fprintf(&buf, " %s", v.Part)
+	}
+	if v.Path != nil {
+		e := gen.InstanceError{Path: v.Path}
+		fmt.Fprintf(&buf, ": %s", e.PathString())
+	}
+	fmt.Fprintf(&buf, ": %s", v.Msg)
+	return buf.String()
+}
+
+// ProxyConfig holds configuration for NewProxy.
+type ProxyConfig struct {
+	// Upstream holds the URL of the server that
+	// requests are forwarded to.
+	Upstream *url.URL
+
+	// Report is called with each violation found.
+	// It may be called concurrently.
+	Report func(v *Violation)
+}
+
+// Proxy is an http.Handler that forwards all requests to an upstream
+// server, checking XRPC requests and responses against the lexicons
+// on the way and reporting any violations. Traffic is forwarded
+// whether it's valid or not.
+//
+// So that responses can be checked, requests are forwarded without
+// any Accept-Encoding header. Subscriptions are forwarded unchecked.
+type Proxy struct {
+	checker *Checker
+	cfg     ProxyConfig
+	proxy   *httputil.ReverseProxy
+}
+
+// NewProxy returns a Proxy that checks traffic against
+// the endpoints known to c.
+func NewProxy(c *Checker, cfg ProxyConfig) *Proxy {
+	p := &Proxy{
+		checker: c,
+		cfg:     cfg,
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(cfg.Upstream)
+			r.Out.Header.Del("Accept-Encoding")
+		},
+		ModifyResponse: p.checkResponse,
+	}
+	return p
+}
+
+// nsidKey is the context key for the NSID of the
+// endpoint that a proxied request is for.
+type nsidKey struct{}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if nsid, ok := strings.CutPrefix(req.URL.Path, "/xrpc/"); ok && !p.isSubscription(nsid) {
+		p.checkRequest(nsid, req)
+		// The upstream URL may have a path of its own, so
+		// the response can't be checked by looking at the
+		// path of the outgoing request.
+		req = req.WithContext(context.WithValue(req.Context(), nsidKey{}, nsid))
+	}
+	p.proxy.ServeHTTP(w, req)
+}
+
+// isSubscription reports whether nsid refers to a subscription,
+// which is carried over a WebSocket rather than plain HTTP.
+func (p *Proxy) isSubscription(nsid string) bool {
+	lex := p.checker.lexicons.Schema(nsid)
+	if lex == nil {
+		return false
+	}
+	t := lex.Defs["main"]
+	return t != nil && t.Type == "subscription"
+}
+
+// checkRequest checks a request to the endpoint with the given NSID.
+func (p *Proxy) checkRequest(nsid string, req *http.Request) {
+	t, err := p.checker.Endpoint(nsid)
+	if err != nil {
+		p.report(nsid, "request", "", err)
+		return
+	}
+	if err := p.checker.CheckParams(nsid, req.URL.Query()); err != nil {
+		p.report(nsid, "request", "parameters", err)
+	}
+	if t.Input == nil || t.Input.Schema == nil || req.Body == nil {
+		return
+	}
+	data, err := readBody(&req.Body)
+	if err != nil {
+		p.report(nsid, "request", "input", err)
+		return
+	}
+	if err := checkContentType(req.Header, t.Input.Encoding); err != nil {
+		p.report(nsid, "request", "input", err)
+		return
+	}
+	if err := p.checker.CheckBody(nsid, "input", data); err != nil {
+		p.report(nsid, "request", "input", err)
+	}
+}
+
+// checkResponse checks a response from the upstream server.
+// It always returns nil so that the response is forwarded.
+func (p *Proxy) checkResponse(resp *http.Response) error {
+	nsid, ok := resp.Request.Context().Value(nsidKey{}).(string)
+	if !ok {
+		return nil
+	}
+	t, err := p.checker.Endpoint(nsid)
+	if err != nil {
+		// The problem has already been reported for the request.
+		return nil
+	}
+	var part, encoding string
+	switch {
+	case resp.StatusCode == http.StatusOK && t.Output != nil && t.Output.Schema != nil:
+		part, encoding = "output", t.Output.Encoding
+	case resp.StatusCode >= 400:
+		part, encoding = "error", "application/json"
+	default:
+		return nil
+	}
+	data, err := readBody(&resp.Body)
+	if err != nil {
+		p.report(nsid, "response", part, err)
+		return nil
+	}
+	if err := checkContentType(resp.Header, encoding); err != nil {
+		p.report(nsid, "response", part, err)
+		return nil
+	}
+	if err := p.checker.CheckBody(nsid, part, data); err != nil {
+		p.report(nsid, "response", part, err)
+	}
+	return nil
+}
+
+// report reports err as one or more violations.
+func (p *Proxy) report(nsid, direction, part string, err error) {
+	if p.cfg.Report == nil {
+		return
+	}
+	errs, ok := err.(gen.InstanceErrors)
+	if !ok {
+		p.cfg.Report(&Violation{
+			NSID:      nsid,
+			Direction: direction,
+			Part:      part,
+			Msg:       err.Error(),
+		})
+		return
+	}
+	for _, e := range errs {
+		path := e.Path
+		if path == nil {
+			path = []string{}
+		}
+		p.cfg.Report(&Violation{
+			NSID:      nsid,
+			Direction: direction,
+			Part:      part,
+			Path:      path,
+			Msg:       e.Msg,
+		})
+	}
+}
+
+// checkContentType checks that the Content-Type
+// in h matches the lexicon encoding.
+func checkContentType(h http.Header, encoding string) error {
+	contentType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("bad content type: %v", err)
+	}
+	if !mediaTypeMatches(encoding, contentType) {
+		return fmt.Errorf("content type %q does not match %q", contentType, encoding)
+	}
+	return nil
+}
+
+// readBody reads all of *body and replaces it
+// with a reader that returns the same data.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, err
+}
@@ -0,0 +1,156 @@
+package xrpc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rogpeppe/lexicue/xrpc"
+)
+
+var proxyTests = []struct {
+	testName string
+	method   string
+	path     string
+	header   http.Header
+	body     string
+
+	// respStatus and respBody hold the upstream response.
+	respStatus int
+	respBody   string
+
+	wantViolations []string
+}{{
+	testName:   "Valid",
+	method:     "GET",
+	path:       "/xrpc/app.bsky.feed.getTimeline?limit=10",
+	respStatus: http.StatusOK,
+	respBody:   `{"feed":[]}`,
+}, {
+	testName:   "BadParams",
+	method:     "GET",
+	path:       "/xrpc/app.bsky.feed.getTimeline?limit=1000",
+	respStatus: http.StatusOK,
+	respBody:   `{"feed":[]}`,
+	wantViolations: []string{
+		"app.bsky.feed.getTimeline request parameters: limit: 2 errors in empty disjunction:",
+		"app.bsky.feed.getTimeline request parameters: limit: conflicting values 50 and 1000",
+		"app.bsky.feed.getTimeline request parameters: limit: invalid value 1000 (out of bound <=100)",
+	},
+}, {
+	testName:   "BadOutput",
+	method:     "GET",
+	path:       "/xrpc/app.bsky.feed.getTimeline",
+	respStatus: http.StatusOK,
+	respBody:   `{"cursor":"c1"}`,
+	wantViolations: []string{
+		"app.bsky.feed.getTimeline response output: feed: field is required but not present",
+	},
+}, {
+	testName:   "BadError",
+	method:     "GET",
+	path:       "/xrpc/app.bsky.feed.getTimeline",
+	respStatus: http.StatusBadRequest,
+	respBody:   `{"message":"no error name"}`,
+	wantViolations: []string{
+		"app.bsky.feed.getTimeline response error: error: field is required but not present",
+	},
+}, {
+	testName:   "BadInput",
+	method:     "POST",
+	path:       "/xrpc/com.atproto.repo.createRecord",
+	header:     http.Header{"Content-Type": {"application/json"}},
+	body:       `{"repo":"alice.test","record":{}}`,
+	respStatus: http.StatusOK,
+	respBody:   `{"uri":"at://alice.test/app.bsky.feed.post/1","cid":"bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"}`,
+	wantViolations: []string{
+		"com.atproto.repo.createRecord request input: collection: field is required but not present",
+	},
+}, {
+	testName:   "UnknownEndpoint",
+	method:     "GET",
+	path:       "/xrpc/com.example.nothing",
+	respStatus: http.StatusOK,
+	respBody:   `{}`,
+	wantViolations: []string{
+		`com.example.nothing request: unknown lexicon "com.example.nothing"`,
+	},
+}, {
+	testName:   "Subscription",
+	method:     "GET",
+	path:       "/xrpc/com.atproto.sync.subscribeRepos?cursor=notanumber",
+	respStatus: http.StatusOK,
+	respBody:   `anything`,
+}, {
+	testName:   "NotXRPC",
+	method:     "GET",
+	path:       "/other",
+	respStatus: http.StatusOK,
+	respBody:   `anything`,
+}}
+
+func TestProxy(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		violations []string
+		upstream   http.HandlerFunc
+	)
+	// The upstream server has a base path, which requests
+	// are forwarded under.
+	srv := httptest.NewServer(http.StripPrefix("/base", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstream(w, req)
+	})))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(xrpc.NewProxy(newChecker(t), xrpc.ProxyConfig{
+		Upstream: u,
+		Report: func(v *xrpc.Violation) {
+			mu.Lock()
+			defer mu.Unlock()
+			violations = append(violations, v.String())
+		},
+	}))
+	defer proxy.Close()
+	for _, test := range proxyTests {
+		t.Run(test.testName, func(t *testing.T) {
+			mu.Lock()
+			violations = nil
+			mu.Unlock()
+			wantPath, _, _ := strings.Cut(test.path, "?")
+			upstream = func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != wantPath {
+					t.Errorf("upstream got path %q, want %q", req.URL.Path, wantPath)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.respStatus)
+				w.Write([]byte(test.respBody))
+			}
+			req, err := http.NewRequest(test.method, proxy.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.respStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, test.respStatus)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.Join(violations, "\n") != strings.Join(test.wantViolations, "\n") {
+				t.Errorf("unexpected violations\ngot:\n%s\nwant:\n%s", strings.Join(violations, "\n"), strings.Join(test.wantViolations, "\n"))
+			}
+		})
+	}
+}